@@ -1,66 +1,152 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/alexmorten/patchy/db"
-	"github.com/alexmorten/patchy/internal/email"
+	"github.com/alexmorten/patchy/ingest"
+	"github.com/alexmorten/patchy/internal/mbox"
+	"github.com/alexmorten/patchy/series"
+	"github.com/alexmorten/patchy/threading"
 	"github.com/jackc/pgx/v5"
 )
 
-const messageBeginning = "From mboxrd@z"
-
 func main() {
+	formatName := flag.String("format", "mboxrd", "Mailbox format: mboxrd, mboxo or mboxcl2")
+	batchSize := flag.Int("batch-size", 1000, "Number of messages written per transaction")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of parser workers")
+	resume := flag.Bool("resume", false, "Continue from the last checkpoint of an earlier run on the same file")
+	checkpointEvery := flag.Int64("checkpoint-every", 10000, "Number of messages between checkpoints")
+	threads := flag.Bool("threads", false, "Rebuild all threads and series after importing, which rewrites them for the whole archive")
+	flag.Parse()
+
 	filename := "archive.utf8.txt"
+	if flag.NArg() > 0 {
+		filename = flag.Arg(0)
+	}
 
-	if len(os.Args) > 1 {
-		filename = os.Args[1]
+	format, err := mbox.ParseFormat(*formatName)
+	if err != nil {
+		panic(err)
 	}
 
-	conn, err := pgx.Connect(context.Background(), "postgresql://localhost:5432/patchy")
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, "postgresql://localhost:5432/patchy")
 	if err != nil {
 		panic(err)
 	}
-	querier := db.New(conn)
+	defer conn.Close(ctx)
 
-	f, err := os.Open(filename)
+	path, err := filepath.Abs(filename)
 	if err != nil {
 		panic(err)
 	}
-	defer f.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		panic(err)
+	}
+
+	opts := ingest.Options{
+		BatchSize: *batchSize,
+		Workers:   *workers,
+		OnBatch:   func(result ingest.BatchResult) { fmt.Println(result) },
+	}
+	var stats ingest.Stats
+	if info.IsDir() || strings.EqualFold(filepath.Ext(path), ".eml") {
+		stats = ingestFiles(ctx, conn, path, format, opts)
+	} else {
+		stats = ingestMbox(ctx, conn, path, format, *resume, *checkpointEvery, opts)
+	}
 
-	scanner := bufio.NewScanner(f)
-	text := ""
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, messageBeginning) {
-			insertMessage(text, querier)
-			text = ""
-			continue
+	fmt.Printf("\nDone: %d messages, %d written, %d skipped, %d failed in %d batches (%.0f msg/s, %v)\n",
+		stats.Messages, stats.Written, stats.Skipped, stats.Failed, stats.Batches,
+		stats.Throughput(), stats.Elapsed.Round(time.Millisecond))
+	if stats.Failed > 0 {
+		os.Exit(1)
+	}
+
+	if !*threads && stats.Written > 0 {
+		fmt.Println("Run patchy threads to thread the new messages")
+	}
+	if *threads && stats.Written > 0 {
+		fmt.Println("Rebuilding threads...")
+		count, err := threading.Rebuild(ctx, conn)
+		if err != nil {
+			panic(err)
 		}
-		text += line + "\n"
+		fmt.Printf("%d threads\n", count)
+
+		fmt.Println("Rebuilding series...")
+		count, err = series.Rebuild(ctx, conn)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%d series\n", count)
+	}
+}
+
+// ingestFiles imports a Maildir or .eml files. These are cheap to re-read,
+// so they are not checkpointed.
+func ingestFiles(ctx context.Context, conn *pgx.Conn, path string, format mbox.Format, opts ingest.Options) ingest.Stats {
+	src, err := ingest.OpenSource(path, format)
+	if err != nil {
+		panic(err)
+	}
+	defer src.Close()
+
+	ingester := ingest.New(conn, opts)
+	if err := ingester.Ingest(ctx, src); err != nil {
+		fmt.Printf("Error: %v\n", err)
 	}
+	return ingester.Stats()
 }
 
-func insertMessage(text string, querier *db.Queries) {
-	messageID := email.ExtractMessageID(text)
-	if messageID == "" {
-		fmt.Println("Warning: No Message-ID found in message:")
-		fmt.Println(text)
-		return
+// ingestMbox imports an mbox file and records progress in ingest_runs.
+func ingestMbox(ctx context.Context, conn *pgx.Conn, path string, format mbox.Format, resume bool, checkpointEvery int64, opts ingest.Options) ingest.Stats {
+	fmt.Printf("Hashing %s...\n", path)
+	hash, err := ingest.HashFile(path)
+	if err != nil {
+		panic(err)
 	}
 
-	_, err := querier.CreateDocument(context.Background(), db.CreateDocumentParams{
-		Text:      text,
-		Url:       messageID,
-		MessageID: messageID,
-	})
+	run, err := ingest.StartRun(ctx, db.New(conn), path, hash, resume, checkpointEvery)
+	if err != nil {
+		panic(err)
+	}
+	if run.Resumed() {
+		fmt.Printf("Resuming run %d at offset %d after %d messages\n", run.ID(), run.Offset(), run.Messages())
+	}
 
+	src, err := ingest.OpenMbox(path, format, run.Offset())
 	if err != nil {
-		fmt.Println(err)
+		panic(err)
+	}
+	defer src.Close()
+
+	opts.OnBatch = func(result ingest.BatchResult) {
+		fmt.Println(result)
+		if err := run.Record(ctx, result); err != nil {
+			fmt.Printf("  checkpoint failed: %v\n", err)
+		}
+	}
+	ingester := ingest.New(conn, opts)
+
+	// Once a batch has failed the checkpoint cannot advance, so Ingest stops
+	// and --resume picks up from there.
+	if err := ingester.Ingest(ctx, src); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		fmt.Println("Stopping, rerun with --resume to continue")
+		run.Fail()
+	}
+	if err := run.Finish(ctx); err != nil {
+		fmt.Printf("Error finishing run %d: %v\n", run.ID(), err)
 	}
+	return ingester.Stats()
 }
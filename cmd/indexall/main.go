@@ -1,7 +0,0 @@
-package main
-
-import "github.com/alexmorten/patchy/search"
-
-func main() {
-	search.IndexDocuments()
-}
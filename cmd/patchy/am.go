@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/alexmorten/patchy/internal/email"
+	"github.com/alexmorten/patchy/internal/mbox"
+	"github.com/alexmorten/patchy/series"
+	"github.com/jackc/pgx/v5"
+)
+
+func runAm(args []string) error {
+	flags := flag.NewFlagSet("am", flag.ExitOnError)
+	serverURL := flags.String("server", "", "Fetch from this Patchy server instead of the database")
+	output := flags.String("o", "", "Write the mbox to this file, - for stdout (default series-<id>.mbox)")
+	trailers := flags.Bool("trailers", true, "Add review trailers from replies to the patches")
+	apply := flags.Bool("apply", false, "Apply the patches with git am")
+	repo := flags.String("C", ".", "Repository to apply the patches in")
+	threeWay := flags.Bool("3way", false, "Retry patches that do not apply with git am --3way")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: patchy am [flags] <message-id|result-id>")
+	}
+
+	ctx := context.Background()
+	var name string
+	var data []byte
+	var unchanged []string
+	var err error
+	if *serverURL != "" {
+		name, data, unchanged, err = fetchMboxFromServer(ctx, *serverURL, flags.Arg(0), *trailers)
+	} else {
+		name, data, unchanged, err = fetchMboxFromDatabase(ctx, flags.Arg(0), *trailers)
+	}
+	if err != nil {
+		return err
+	}
+	if len(unchanged) > 0 {
+		fmt.Fprintf(os.Stderr, "Could not add trailers to messages %s, which are not plain text\n", strings.Join(unchanged, ", "))
+	}
+
+	msgs, err := readMbox(data)
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return fmt.Errorf("%s has no patches", flags.Arg(0))
+	}
+
+	if *output == "" {
+		*output = name
+	}
+	// The report goes to stderr when stdout carries the mbox.
+	report := os.Stdout
+	if *output == "-" {
+		_, err = os.Stdout.Write(data)
+		report = os.Stderr
+	} else if err = os.WriteFile(*output, data, 0o644); err == nil {
+		fmt.Fprintf(os.Stderr, "Wrote %d patches to %s\n", len(msgs), *output)
+	}
+	if err != nil || !*apply {
+		return err
+	}
+
+	results := applyPatches(*repo, msgs, *threeWay)
+	writeApplyReport(report, results)
+	if failed := len(results) - countApplied(results); failed > 0 {
+		return fmt.Errorf("%d of %d patches not applied", failed, len(results))
+	}
+	return nil
+}
+
+// parseRef tells a numeric result ID from a Message-ID.
+func parseRef(ref string) (int64, string) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return id, ""
+	}
+	return 0, strings.Trim(strings.TrimSpace(ref), "<>")
+}
+
+// fetchMboxFromDatabase builds the mbox of the series ref belongs to, or of
+// the message alone if it is not part of a series. It also returns the IDs
+// of the patches that trailers could not be added to.
+func fetchMboxFromDatabase(ctx context.Context, ref string, trailers bool) (string, []byte, []string, error) {
+	conn, err := connectToDatabase(ctx)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer conn.Close(ctx)
+	queries := db.New(conn)
+
+	var doc db.Doc
+	if id, messageID := parseRef(ref); messageID == "" {
+		doc, err = queries.GetDocumentByID(ctx, id)
+	} else {
+		doc, err = queries.GetDocumentByMessageID(ctx, messageID)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil, nil, fmt.Errorf("message %s not found", ref)
+	}
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	var buf bytes.Buffer
+	var unchanged []int64
+	name := fmt.Sprintf("%d.mbox", doc.ID)
+	patch, err := queries.GetSeriesPatchByDocID(ctx, doc.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		unchanged, err = series.WriteMessageMbox(ctx, queries, &buf, doc, trailers)
+	} else if err == nil {
+		name = fmt.Sprintf("series-%d.mbox", patch.SeriesID)
+		unchanged, err = series.WriteMbox(ctx, queries, &buf, patch.SeriesID, trailers)
+	}
+	if err != nil {
+		return "", nil, nil, err
+	}
+	ids := make([]string, len(unchanged))
+	for i, id := range unchanged {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	return name, buf.Bytes(), ids, nil
+}
+
+// fetchMboxFromServer does what fetchMboxFromDatabase does through the API.
+func fetchMboxFromServer(ctx context.Context, serverURL, ref string, trailers bool) (string, []byte, []string, error) {
+	base := strings.TrimRight(serverURL, "/")
+	var resultURL string
+	if id, messageID := parseRef(ref); messageID == "" {
+		resultURL = base + "/api/result/" + strconv.FormatInt(id, 10)
+	} else {
+		resultURL = base + "/api/message/" + url.PathEscape(messageID)
+	}
+
+	body, _, err := httpGet(ctx, resultURL)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var result struct {
+		ID       string `json:"id"`
+		SeriesID string `json:"series_id"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", nil, nil, fmt.Errorf("decoding %s: %w", resultURL, err)
+	}
+
+	query := "?trailers=" + strconv.FormatBool(trailers)
+	name := result.ID + ".mbox"
+	mboxURL := base + "/api/result/" + url.PathEscape(result.ID) + "/raw" + query
+	if result.SeriesID != "" {
+		name = "series-" + result.SeriesID + ".mbox"
+		mboxURL = base + "/api/series/" + url.PathEscape(result.SeriesID) + "/mbox" + query
+	}
+	data, header, err := httpGet(ctx, mboxURL)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	// The server lists the patches it could not add trailers to.
+	return name, data, header.Values("X-Patchy-Trailers-Unchanged"), nil
+}
+
+func httpGet(ctx context.Context, u string) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("GET %s: %s: %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, resp.Header, nil
+}
+
+func readMbox(data []byte) ([]*mbox.Message, error) {
+	r := mbox.NewReader(bytes.NewReader(data), mbox.MboxRD)
+	var msgs []*mbox.Message
+	for {
+		msg, err := r.Next()
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+type applyStatus int
+
+const (
+	notAttempted applyStatus = iota
+	applied
+	appliedThreeWay
+	failed
+)
+
+func (s applyStatus) String() string {
+	switch s {
+	case applied:
+		return "applied"
+	case appliedThreeWay:
+		return "applied (3-way)"
+	case failed:
+		return "FAILED"
+	}
+	return "not applied"
+}
+
+type applyResult struct {
+	Subject string
+	Status  applyStatus
+	Output  string
+}
+
+// applyPatches runs git am for one patch at a time so the report can say
+// which one failed. After a failure the am session is aborted, leaving the
+// repository at the last patch that applied, and the remaining patches are
+// not attempted.
+func applyPatches(repo string, msgs []*mbox.Message, threeWay bool) []applyResult {
+	results := make([]applyResult, len(msgs))
+	for i, msg := range msgs {
+		results[i].Subject = patchSubject(msg.Data)
+	}
+
+	for i, msg := range msgs {
+		output, err := gitAm(repo, msg.Data, false)
+		results[i].Status = applied
+		if err != nil && threeWay {
+			gitAmAbort(repo)
+			output, err = gitAm(repo, msg.Data, true)
+			results[i].Status = appliedThreeWay
+		}
+		if err != nil {
+			gitAmAbort(repo)
+			results[i].Status = failed
+			results[i].Output = output
+			break
+		}
+	}
+	return results
+}
+
+func gitAm(repo string, patch []byte, threeWay bool) (string, error) {
+	var input bytes.Buffer
+	w := mbox.NewWriter(&input)
+	if err := w.WriteMessage(patch); err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	args := []string{"-C", repo, "am"}
+	if threeWay {
+		args = append(args, "--3way")
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Stdin = &input
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+func gitAmAbort(repo string) {
+	exec.Command("git", "-C", repo, "am", "--abort").Run()
+}
+
+func patchSubject(data []byte) string {
+	msg, err := email.Parse(data)
+	if err != nil {
+		return "(unparseable message)"
+	}
+	return msg.Subject
+}
+
+func countApplied(results []applyResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Status == applied || r.Status == appliedThreeWay {
+			n++
+		}
+	}
+	return n
+}
+
+func writeApplyReport(w io.Writer, results []applyResult) {
+	fmt.Fprintf(w, "\n%d of %d patches applied\n", countApplied(results), len(results))
+	for i, r := range results {
+		fmt.Fprintf(w, "  [%d/%d] %-16s %s\n", i+1, len(results), r.Status, r.Subject)
+		for _, line := range strings.Split(strings.TrimSpace(r.Output), "\n") {
+			if line != "" {
+				fmt.Fprintf(w, "        %s\n", line)
+			}
+		}
+	}
+}
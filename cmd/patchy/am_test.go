@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// amMbox holds a patch that applies, one that does not and one that is
+// therefore not attempted.
+const amMbox = `From 1 Mon Sep 17 00:00:00 2001
+From: Jane Doe <jane@example.com>
+Date: Mon, 1 Jan 2024 00:00:00 +0000
+Subject: [PATCH 1/3] hello: greet the world
+
+---
+ hello.txt | 2 +-
+ 1 file changed, 1 insertion(+), 1 deletion(-)
+
+diff --git a/hello.txt b/hello.txt
+--- a/hello.txt
++++ b/hello.txt
+@@ -1 +1 @@
+-hello
++hello world
+-- 
+2.43.0
+
+From 2 Mon Sep 17 00:00:00 2001
+From: Jane Doe <jane@example.com>
+Date: Mon, 1 Jan 2024 00:00:00 +0000
+Subject: [PATCH 2/3] hello: greet the moon
+
+---
+diff --git a/hello.txt b/hello.txt
+--- a/hello.txt
++++ b/hello.txt
+@@ -1 +1 @@
+-goodbye
++hello moon
+-- 
+2.43.0
+
+From 3 Mon Sep 17 00:00:00 2001
+From: Jane Doe <jane@example.com>
+Date: Mon, 1 Jan 2024 00:00:00 +0000
+Subject: [PATCH 3/3] hello: greet everyone
+
+---
+diff --git a/hello.txt b/hello.txt
+--- a/hello.txt
++++ b/hello.txt
+@@ -1 +1 @@
+-hello world
++hello everyone
+-- 
+2.43.0
+`
+
+func git(t *testing.T, repo string, args ...string) string {
+	t.Helper()
+	output, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return string(output)
+}
+
+func TestApplyPatches(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	repo := t.TempDir()
+	git(t, repo, "init", "-q")
+	git(t, repo, "config", "user.name", "Test")
+	git(t, repo, "config", "user.email", "test@example.com")
+	if err := os.WriteFile(filepath.Join(repo, "hello.txt"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, repo, "add", "hello.txt")
+	git(t, repo, "commit", "-q", "-m", "hello")
+
+	msgs, err := readMbox([]byte(amMbox))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("read %d messages, want 3", len(msgs))
+	}
+	results := applyPatches(repo, msgs, false)
+
+	want := []applyResult{
+		{Subject: "[PATCH 1/3] hello: greet the world", Status: applied},
+		{Subject: "[PATCH 2/3] hello: greet the moon", Status: failed},
+		{Subject: "[PATCH 3/3] hello: greet everyone", Status: notAttempted},
+	}
+	for i, r := range results {
+		if r.Subject != want[i].Subject || r.Status != want[i].Status {
+			t.Errorf("result %d = %q %v, want %q %v", i, r.Subject, r.Status, want[i].Subject, want[i].Status)
+		}
+	}
+	if results[1].Output == "" {
+		t.Error("failed patch has no git am output")
+	}
+	if got := git(t, repo, "log", "-1", "--format=%s"); got != "hello: greet the world\n" {
+		t.Errorf("HEAD is %q, want the first patch", got)
+	}
+	if _, err := os.Stat(filepath.Join(repo, ".git", "rebase-apply")); !os.IsNotExist(err) {
+		t.Error("git am session was not aborted")
+	}
+
+	var report bytes.Buffer
+	writeApplyReport(&report, results)
+	wantReport := `
+1 of 3 patches applied
+  [1/3] applied          [PATCH 1/3] hello: greet the world
+  [2/3] FAILED           [PATCH 2/3] hello: greet the moon
+`
+	if !strings.HasPrefix(report.String(), wantReport) {
+		t.Errorf("report = %q, want it to start with %q", report.String(), wantReport)
+	}
+	if !strings.HasSuffix(report.String(), "  [3/3] not applied      [PATCH 3/3] hello: greet everyone\n") {
+		t.Errorf("report = %q, want the last patch not applied", report.String())
+	}
+}
+
+func TestParseRef(t *testing.T) {
+	for ref, want := range map[string]struct {
+		id        int64
+		messageID string
+	}{
+		"42":                  {42, ""},
+		"<abc@example.com>":   {0, "abc@example.com"},
+		" abc@example.com \n": {0, "abc@example.com"},
+	} {
+		id, messageID := parseRef(ref)
+		if id != want.id || messageID != want.messageID {
+			t.Errorf("parseRef(%q) = %d, %q, want %d, %q", ref, id, messageID, want.id, want.messageID)
+		}
+	}
+}
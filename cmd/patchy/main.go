@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/alexmorten/patchy/ingest"
+	"github.com/alexmorten/patchy/search"
+	"github.com/alexmorten/patchy/series"
+	"github.com/alexmorten/patchy/threading"
+	"github.com/jackc/pgx/v5"
+	"github.com/meilisearch/meilisearch-go"
+)
+
+const usage = `Usage: patchy <command> [arguments]
+
+Commands:
+  ingest public-inbox <path>   Import a public-inbox v2 archive
+  threads                      Rebuild threads and patch series from all imported messages
+  am <message-id|result-id>    Write the series of a message as an mbox and optionally git am it
+  index                        Keep the search index current with the database
+  reindex                      Rebuild the search index and swap it in once it is complete
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "ingest":
+		err = runIngest(os.Args[2:])
+	case "threads":
+		err = runThreads(os.Args[2:])
+	case "am":
+		err = runAm(os.Args[2:])
+	case "index":
+		err = runIndex(os.Args[2:])
+	case "reindex":
+		err = runReindex(os.Args[2:])
+	default:
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runIngest(args []string) error {
+	if len(args) < 1 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "public-inbox":
+		return runIngestPublicInbox(args[1:])
+	}
+	return fmt.Errorf("unknown ingest source %q", args[0])
+}
+
+func runIngestPublicInbox(args []string) error {
+	flags := flag.NewFlagSet("ingest public-inbox", flag.ExitOnError)
+	batchSize := flags.Int("batch-size", 1000, "Number of messages written per transaction")
+	workers := flags.Int("workers", runtime.NumCPU(), "Number of parser workers")
+	threads := flags.Bool("threads", false, "Rebuild all threads and series after importing, which rewrites them for the whole archive")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: patchy ingest public-inbox [flags] <path>")
+	}
+
+	ctx := context.Background()
+	conn, err := connectToDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	start := time.Now()
+	var messages, written int64
+	err = ingest.IngestPublicInbox(ctx, conn, flags.Arg(0), ingest.Options{
+		BatchSize: *batchSize,
+		Workers:   *workers,
+		OnBatch: func(result ingest.BatchResult) {
+			messages += int64(result.Messages)
+			written += result.Written
+			fmt.Println(result)
+		},
+	})
+
+	elapsed := time.Since(start)
+	fmt.Printf("\nDone: %d messages, %d written in %v (%.0f msg/s)\n",
+		messages, written, elapsed.Round(time.Millisecond), float64(messages)/elapsed.Seconds())
+	if err != nil {
+		return err
+	}
+	if written == 0 {
+		return nil
+	}
+	if !*threads {
+		fmt.Println("Run patchy threads to thread the new messages")
+		return nil
+	}
+	return rebuildThreads(ctx, conn)
+}
+
+func runThreads(args []string) error {
+	flags := flag.NewFlagSet("threads", flag.ExitOnError)
+	flags.Parse(args)
+
+	ctx := context.Background()
+	conn, err := connectToDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	return rebuildThreads(ctx, conn)
+}
+
+func rebuildThreads(ctx context.Context, conn *pgx.Conn) error {
+	start := time.Now()
+	fmt.Println("Rebuilding threads...")
+	count, err := threading.Rebuild(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("rebuilding threads: %w", err)
+	}
+	fmt.Printf("%d threads in %v\n", count, time.Since(start).Round(time.Millisecond))
+
+	start = time.Now()
+	fmt.Println("Rebuilding series...")
+	count, err = series.Rebuild(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("rebuilding series: %w", err)
+	}
+	fmt.Printf("%d series in %v\n", count, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func runIndex(args []string) error {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	backendName := flags.String("backend", getEnvOrDefault("SEARCH_BACKEND", "meilisearch"), "Search backend: meilisearch, postgres or embedded")
+	meilisearchURL := flags.String("meilisearch", getEnvOrDefault("MEILISEARCH_URL", "http://localhost:7700"), "Meilisearch URL")
+	dir := flags.String("dir", getEnvOrDefault("SEARCH_DIR", "search-index"), "Index directory of the embedded backend, which cannot be used while the server has it open")
+	batchSize := flags.Int("batch-size", 1000, "Number of documents indexed at once")
+	pollInterval := flags.Duration("poll", time.Minute, "Look for new documents this often even without a notification")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	conn, err := connectToDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	backend, err := search.NewBackend(search.BackendConfig{Name: *backendName, MeilisearchURL: *meilisearchURL, DB: conn, Dir: *dir})
+	if err != nil {
+		return err
+	}
+	if closer, ok := backend.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	fmt.Println("Indexing documents, waiting for new ones...")
+	err = search.RunIndexer(ctx, conn, backend, search.IndexerOptions{
+		BatchSize:    *batchSize,
+		PollInterval: *pollInterval,
+		OnBatch: func(batch search.IndexerBatch) {
+			if batch.LastDocID > 0 {
+				fmt.Printf("indexed %d documents up to %d in %v\n",
+					batch.Documents, batch.LastDocID, batch.Duration.Round(time.Millisecond))
+			} else {
+				fmt.Printf("synced %d changed documents, %d deleted, in %v\n",
+					batch.Documents, batch.Deleted, batch.Duration.Round(time.Millisecond))
+			}
+		},
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func runReindex(args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	meilisearchURL := flags.String("meilisearch", getEnvOrDefault("MEILISEARCH_URL", "http://localhost:7700"), "Meilisearch URL")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	conn, err := connectToDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	start := time.Now()
+	client := meilisearch.New(*meilisearchURL)
+	err = search.Reindex(ctx, conn, client, func(p search.ReindexProgress) {
+		fmt.Printf("%s: %d/%d documents sent\n", p.Index, p.Indexed, p.Total)
+	})
+	if err != nil {
+		return fmt.Errorf("reindexing: %w", err)
+	}
+	fmt.Printf("Swapped in the new %s index in %v\n", search.IndexUID, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func connectToDatabase(ctx context.Context) (*pgx.Conn, error) {
+	return pgx.Connect(ctx, getEnvOrDefault("POSTGRES_CONNECTION_STRING", "postgresql://localhost:5432/patchy"))
+}
+
+func getEnvOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -8,7 +8,9 @@ import (
 	"os"
 
 	"github.com/alexmorten/patchy/db"
+	"github.com/alexmorten/patchy/search"
 	"github.com/alexmorten/patchy/server"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -31,12 +33,28 @@ func main() {
 	port := getEnvOrDefault("PORT", "7788")
 	
 	querier := db.New(dbPool)
+	backendName := getEnvOrDefault("SEARCH_BACKEND", "meilisearch")
+	searchBackend, err := search.NewBackend(search.BackendConfig{
+		Name:           backendName,
+		MeilisearchURL: meilisearchURL,
+		DB:             dbPool,
+		Dir:            getEnvOrDefault("SEARCH_DIR", "search-index"),
+	})
+	if err != nil {
+		log.Fatalf("Invalid search backend: %v", err)
+	}
+	if backendName == "embedded" {
+		// The index directory is locked while the server runs, so patchy index
+		// cannot fill it and the server keeps it current itself.
+		go runIndexer(searchBackend)
+	}
 	
 	config := server.ServerConfig{
 		Domain:         domain,
 		Querier:        querier,
 		FrontendDir:    frontendDir,
 		MeilisearchURL: meilisearchURL,
+		SearchBackend:  searchBackend,
 		CertCacheDir:   certCacheDir,
 		Host:           host,
 		Port:           port,
@@ -49,6 +67,18 @@ func main() {
 	}
 }
 
+func runIndexer(backend search.Backend) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, getConnectionString())
+	if err != nil {
+		log.Fatalf("Failed to connect to database for indexing: %v", err)
+	}
+	defer conn.Close(ctx)
+	
+	err = search.RunIndexer(ctx, conn, backend, search.IndexerOptions{})
+	log.Fatalf("Indexer error: %v", err)
+}
+
 func getDomain() string {
 	domain := flag.String("domain", "", "Domain name for HTTPS with Let's Encrypt (e.g., example.com)")
 	flag.Parse()
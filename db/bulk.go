@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Beginner starts transactions. *pgx.Conn and *pgxpool.Pool both satisfy it.
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// bulkDocumentColumns are the docs columns written by BulkUpsertDocuments, in
+// the order bulkDocumentRow returns them. message_id must stay first.
+var bulkDocumentColumns = []string{
+	"message_id", "text", "url", "raw",
+	"subject", "from_name", "from_email", "sent_at", "in_reply_to", "refs",
+	"clean_subject", "is_patch", "patch_prefixes", "patch_tree", "patch_version", "patch_index", "patch_total",
+	"change_id", "touched_files", "lines_added", "lines_removed", "to_addrs", "cc_addrs",
+}
+
+func bulkDocumentRow(d CreateDocumentParams) []any {
+	return []any{
+		d.MessageID, d.Text, d.Url, d.Raw,
+		d.Subject, d.FromName, d.FromEmail, d.SentAt, d.InReplyTo, d.Refs,
+		d.CleanSubject, d.IsPatch, d.PatchPrefixes, d.PatchTree, d.PatchVersion, d.PatchIndex, d.PatchTotal,
+		d.ChangeID, d.TouchedFiles, d.LinesAdded, d.LinesRemoved, d.ToAddrs, d.CcAddrs,
+	}
+}
+
+// BulkUpsertDocuments writes docs in a single transaction. Rows are staged
+// with COPY into a temporary table and then merged into docs with the same
+// ON CONFLICT (message_id) rules as CreateDocument. When a message ID occurs
+// more than once in docs, the last occurrence wins. It returns the number of
+// rows inserted or updated.
+func BulkUpsertDocuments(ctx context.Context, conn Beginner, docs []CreateDocumentParams) (int64, error) {
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `CREATE TEMP TABLE docs_staging ON COMMIT DROP AS
+		SELECT 0::bigint AS ord, `+strings.Join(bulkDocumentColumns, ", ")+` FROM docs WITH NO DATA`)
+	if err != nil {
+		return 0, fmt.Errorf("creating staging table: %w", err)
+	}
+
+	columns := append([]string{"ord"}, bulkDocumentColumns...)
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"docs_staging"}, columns,
+		pgx.CopyFromSlice(len(docs), func(i int) ([]any, error) {
+			return append([]any{int64(i)}, bulkDocumentRow(docs[i])...), nil
+		}))
+	if err != nil {
+		return 0, fmt.Errorf("copying documents: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx, mergeStagedDocumentsSQL())
+	if err != nil {
+		return 0, fmt.Errorf("merging documents: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func mergeStagedDocumentsSQL() string {
+	cols := strings.Join(bulkDocumentColumns, ", ")
+	updates := make([]string, 0, len(bulkDocumentColumns)-1)
+	for _, c := range bulkDocumentColumns[1:] {
+		updates = append(updates, c+" = EXCLUDED."+c)
+	}
+	values := make([]string, len(bulkDocumentColumns))
+	for i, c := range bulkDocumentColumns {
+		values[i] = c
+		if c == "raw" {
+			// A nil Raw is copied as NULL, which docs does not allow.
+			values[i] = "coalesce(raw, ''::bytea)"
+		}
+	}
+	return `INSERT INTO docs (` + cols + `)
+		SELECT DISTINCT ON (message_id) ` + strings.Join(values, ", ") + ` FROM docs_staging
+		ORDER BY message_id, ord DESC
+		ON CONFLICT (message_id)
+		DO UPDATE SET ` + strings.Join(updates, ", ")
+}
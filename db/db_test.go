@@ -3,11 +3,17 @@ package db
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 func setupTestDB(t *testing.T) *Queries {
+	return New(setupTestConn(t))
+}
+
+func setupTestConn(t *testing.T) *pgx.Conn {
 	conn, err := pgx.Connect(context.Background(), "postgresql://postgres@localhost:5432/patchy")
 	if err != nil {
 		t.Fatalf("Unable to connect to database: %v", err)
@@ -15,7 +21,7 @@ func setupTestDB(t *testing.T) *Queries {
 	t.Cleanup(func() {
 		conn.Close(context.Background())
 	})
-	return New(conn)
+	return conn
 }
 
 func TestCreateDocument(t *testing.T) {
@@ -53,3 +59,254 @@ func TestCreateDocument(t *testing.T) {
 		t.Errorf("Expected updated URL, got %s", doc2.Url)
 	}
 }
+
+func TestBulkUpsertDocuments(t *testing.T) {
+	conn := setupTestConn(t)
+	q := New(conn)
+	ctx := context.Background()
+
+	existing, err := q.CreateDocument(ctx, CreateDocumentParams{
+		Text:      "Original bulk text",
+		Url:       "bulk-1@example.com",
+		MessageID: "bulk-1@example.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	written, err := BulkUpsertDocuments(ctx, conn, []CreateDocumentParams{
+		{Text: "Updated bulk text", Url: "bulk-1@example.com", MessageID: "bulk-1@example.com"},
+		{Text: "First copy", Url: "bulk-2@example.com", MessageID: "bulk-2@example.com"},
+		{Text: "Second copy", Url: "bulk-2@example.com", MessageID: "bulk-2@example.com", Raw: []byte("raw")},
+	})
+	if err != nil {
+		t.Fatalf("Failed to bulk upsert documents: %v", err)
+	}
+	if written != 2 {
+		t.Errorf("Expected 2 rows written, got %d", written)
+	}
+
+	updated, err := q.GetDocumentByID(ctx, existing.ID)
+	if err != nil {
+		t.Fatalf("Failed to get document: %v", err)
+	}
+	if updated.Text != "Updated bulk text" {
+		t.Errorf("Expected updated text, got %s", updated.Text)
+	}
+
+	var text string
+	var raw []byte
+	err = conn.QueryRow(ctx, "SELECT text, raw FROM docs WHERE message_id = $1", "bulk-2@example.com").Scan(&text, &raw)
+	if err != nil {
+		t.Fatalf("Failed to get document: %v", err)
+	}
+	if text != "Second copy" || string(raw) != "raw" {
+		t.Errorf("Expected last duplicate to win, got %q / %q", text, raw)
+	}
+}
+
+func TestReplaceThreads(t *testing.T) {
+	conn := setupTestConn(t)
+	q := New(conn)
+	ctx := context.Background()
+
+	doc, err := q.CreateDocument(ctx, CreateDocumentParams{
+		Text:      "Reply",
+		Url:       "thread-reply@example.com",
+		MessageID: "thread-reply@example.com",
+		InReplyTo: "thread-root@example.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	threads := []ThreadParams{{RootMessageID: "thread-root@example.com", Subject: "Root", MessageCount: 1}}
+	members := []ThreadMemberParams{
+		{RootMessageID: "thread-root@example.com", MessageID: "thread-root@example.com"},
+		{
+			RootMessageID:   "thread-root@example.com",
+			MessageID:       "thread-reply@example.com",
+			DocID:           pgtype.Int8{Int64: doc.ID, Valid: true},
+			ParentMessageID: "thread-root@example.com",
+			Depth:           1,
+			Position:        1,
+		},
+	}
+	if err := ReplaceThreads(ctx, conn, threads, members); err != nil {
+		t.Fatalf("Failed to replace threads: %v", err)
+	}
+	first, err := q.GetThreadMemberByDocID(ctx, pgtype.Int8{Int64: doc.ID, Valid: true})
+	if err != nil {
+		t.Fatalf("Failed to get thread member: %v", err)
+	}
+
+	// Rebuilding keeps the thread ID of an unchanged root.
+	if err := ReplaceThreads(ctx, conn, threads, members); err != nil {
+		t.Fatalf("Failed to replace threads: %v", err)
+	}
+	rows, err := q.ListThreadMembers(ctx, first.ThreadID)
+	if err != nil {
+		t.Fatalf("Failed to list thread members: %v", err)
+	}
+	if len(rows) != 2 || rows[0].DocID.Valid || rows[1].Subject.String != "" || rows[1].ParentMessageID != "thread-root@example.com" {
+		t.Errorf("Unexpected thread members %+v", rows)
+	}
+}
+
+func TestAttributeTrailers(t *testing.T) {
+	conn := setupTestConn(t)
+	q := New(conn)
+	ctx := context.Background()
+
+	patch, err := q.CreateDocument(ctx, CreateDocumentParams{
+		Text:      "Patch",
+		Url:       "trailer-patch@example.com",
+		MessageID: "trailer-patch@example.com",
+		IsPatch:   true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	reply, err := q.CreateDocument(ctx, CreateDocumentParams{
+		Text:      "Reply",
+		Url:       "trailer-reply@example.com",
+		MessageID: "trailer-reply@example.com",
+		InReplyTo: "trailer-patch@example.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	err = ReplaceTrailers(ctx, conn,
+		[]string{"trailer-patch@example.com", "trailer-reply@example.com"},
+		[]TrailerParams{
+			{MessageID: "trailer-patch@example.com", Key: "Signed-off-by", Email: "a@example.com"},
+			{MessageID: "trailer-reply@example.com", Key: "Reviewed-by", Email: "r@example.com"},
+		})
+	if err != nil {
+		t.Fatalf("Failed to replace trailers: %v", err)
+	}
+
+	root := "trailer-patch@example.com"
+	err = ReplaceThreads(ctx, conn, []ThreadParams{{RootMessageID: root, Subject: "Patch", MessageCount: 2}},
+		[]ThreadMemberParams{
+			{RootMessageID: root, MessageID: root, DocID: pgtype.Int8{Int64: patch.ID, Valid: true}},
+			{
+				RootMessageID:   root,
+				MessageID:       "trailer-reply@example.com",
+				DocID:           pgtype.Int8{Int64: reply.ID, Valid: true},
+				ParentMessageID: root,
+				Depth:           1,
+				Position:        1,
+			},
+		})
+	if err != nil {
+		t.Fatalf("Failed to replace threads: %v", err)
+	}
+	if _, err := AttributeTrailers(ctx, conn); err != nil {
+		t.Fatalf("Failed to attribute trailers: %v", err)
+	}
+
+	trailers, err := q.ListPatchTrailers(ctx, pgtype.Int8{Int64: patch.ID, Valid: true})
+	if err != nil {
+		t.Fatalf("Failed to list trailers: %v", err)
+	}
+	if len(trailers) != 2 || trailers[0].Key != "Signed-off-by" || trailers[1].DocID != reply.ID {
+		t.Errorf("Unexpected trailers: %+v", trailers)
+	}
+}
+
+func TestDocsChangedNotification(t *testing.T) {
+	conn := setupTestConn(t)
+	q := New(conn)
+	ctx := context.Background()
+
+	listener := setupTestConn(t)
+	if _, err := listener.Exec(ctx, "LISTEN docs_changed"); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	doc, err := q.CreateDocument(ctx, CreateDocumentParams{
+		Text:      "Notified text",
+		Url:       "notify@example.com",
+		MessageID: "notify@example.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := listener.WaitForNotification(waitCtx); err != nil {
+		t.Fatalf("No docs_changed notification: %v", err)
+	}
+
+	err = q.UpsertIndexerState(ctx, UpsertIndexerStateParams{Name: "test", LastDocID: doc.ID})
+	if err != nil {
+		t.Fatalf("Failed to save indexer state: %v", err)
+	}
+	state, err := q.GetIndexerState(ctx, "test")
+	if err != nil {
+		t.Fatalf("Failed to read indexer state: %v", err)
+	}
+	if state.LastDocID != doc.ID {
+		t.Errorf("Expected cursor %d, got %d", doc.ID, state.LastDocID)
+	}
+}
+
+func TestSearchOutbox(t *testing.T) {
+	conn := setupTestConn(t)
+	q := New(conn)
+	ctx := context.Background()
+
+	// Changes are only recorded for registered indexers, once for each.
+	for _, name := range []string{"outbox-a", "outbox-b"} {
+		if _, err := q.RegisterIndexer(ctx, name); err != nil {
+			t.Fatalf("Failed to register indexer: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		conn.Exec(context.Background(), "DELETE FROM indexer_state WHERE name IN ('outbox-a', 'outbox-b')")
+	})
+
+	// New rows are not recorded, the indexers find them by ID.
+	params := CreateDocumentParams{Text: "Outbox text", Url: "outbox@example.com", MessageID: "outbox@example.com"}
+	doc, err := q.CreateDocument(ctx, params)
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	// Writing the same row again is not a change.
+	if _, err := q.CreateDocument(ctx, params); err != nil {
+		t.Fatalf("Failed to upsert document: %v", err)
+	}
+	params.Text = "Changed outbox text"
+	if _, err := q.CreateDocument(ctx, params); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	if _, err := conn.Exec(ctx, "DELETE FROM docs WHERE id = $1", doc.ID); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+
+	for _, name := range []string{"outbox-a", "outbox-b"} {
+		changes, err := q.ListSearchOutbox(ctx, ListSearchOutboxParams{Indexer: name, Limit: 1000000})
+		if err != nil {
+			t.Fatalf("Failed to list outbox: %v", err)
+		}
+		var ops []string
+		var ids []int64
+		for _, c := range changes {
+			if c.DocID == doc.ID {
+				ops = append(ops, c.Op)
+				ids = append(ids, c.ID)
+			}
+		}
+		if len(ops) != 2 || ops[0] != "upsert" || ops[1] != "delete" {
+			t.Errorf("Expected upsert, delete for %s, got %v", name, ops)
+		}
+
+		// One indexer consuming its changes leaves the other's alone.
+		if err := q.DeleteSearchOutbox(ctx, ids); err != nil {
+			t.Fatalf("Failed to delete outbox: %v", err)
+		}
+	}
+}
@@ -4,9 +4,139 @@
 
 package db
 
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
 type Doc struct {
+	ID            int64
+	Text          string
+	Url           string
+	MessageID     string
+	Raw           []byte
+	Subject       string
+	FromName      string
+	FromEmail     string
+	SentAt        pgtype.Timestamptz
+	InReplyTo     string
+	Refs          []string
+	CleanSubject  string
+	IsPatch       bool
+	PatchPrefixes []string
+	PatchTree     string
+	PatchVersion  int32
+	PatchIndex    int32
+	PatchTotal    int32
+	ChangeID      string
+	TouchedFiles  []string
+	LinesAdded    int32
+	LinesRemoved  int32
+	ToAddrs       []string
+	CcAddrs       []string
+}
+
+type IndexerState struct {
+	Name      string
+	LastDocID int64
+	UpdatedAt pgtype.Timestamptz
+}
+
+type IngestRun struct {
+	ID           int64
+	Source       string
+	SourceHash   string
+	ByteOffset   int64
+	MessageCount int64
+	StartedAt    pgtype.Timestamptz
+	UpdatedAt    pgtype.Timestamptz
+	FinishedAt   pgtype.Timestamptz
+}
+
+type PublicInboxEpoch struct {
+	GitDir       string
+	LastCommit   string
+	MessageCount int64
+	UpdatedAt    pgtype.Timestamptz
+}
+
+type SearchDocument struct {
+	ID            int64
+	Text          string
+	Url           string
+	MessageID     string
+	Subject       string
+	IsPatch       bool
+	PatchPrefixes []string
+	PatchTree     string
+	PatchVersion  int32
+	PatchIndex    int32
+	PatchTotal    int32
+	FromTerms     []string
+	ToTerms       []string
+	CcTerms       []string
+	SubjectWords  []string
+	DiffFiles     []string
+	HunkHeaders   []string
+	SentAt        pgtype.Int8
+	TrailerKeys   []string
+	Identifiers   []string
+	Tsv           interface{}
+}
+
+type SearchOutbox struct {
 	ID        int64
-	Text      string
-	Url       string
-	MessageID string
+	Indexer   string
+	DocID     int64
+	Op        string
+	CreatedAt pgtype.Timestamptz
+}
+
+type Series struct {
+	ID            int64
+	RootMessageID string
+	Subject       string
+	FromName      string
+	FromEmail     string
+	Version       int32
+	Total         int32
+	Received      int32
+	Complete      bool
+	SentAt        pgtype.Timestamptz
+	ChangeID      string
+	Lineage       string
+	UpdatedAt     pgtype.Timestamptz
+}
+
+type SeriesPatch struct {
+	SeriesID   int64
+	DocID      int64
+	PatchIndex int32
+}
+
+type Thread struct {
+	ID            int64
+	RootMessageID string
+	Subject       string
+	MessageCount  int32
+	LastActivity  pgtype.Timestamptz
+	UpdatedAt     pgtype.Timestamptz
+}
+
+type ThreadMember struct {
+	ThreadID        int64
+	MessageID       string
+	DocID           pgtype.Int8
+	ParentMessageID string
+	Depth           int32
+	Position        int32
+}
+
+type Trailer struct {
+	DocID      int64
+	Position   int32
+	Key        string
+	Name       string
+	Email      string
+	Value      string
+	PatchDocID pgtype.Int8
 }
@@ -2,19 +2,196 @@
 SELECT * FROM docs
 WHERE id = $1 LIMIT 1;
 
+-- name: GetDocumentByMessageID :one
+SELECT * FROM docs
+WHERE message_id = $1 LIMIT 1;
+
 -- name: ListDocuments :many
 SELECT * FROM docs
 ORDER BY id
 LIMIT $1 OFFSET $2;
 
+-- name: ListDocumentsAfter :many
+SELECT * FROM docs
+WHERE id > $1
+ORDER BY id
+LIMIT $2;
+
+-- name: ListDocumentsByIDs :many
+SELECT * FROM docs
+WHERE id = ANY(@ids::bigint[])
+ORDER BY id;
+
+-- name: CountDocuments :one
+SELECT count(*) FROM docs;
+
 -- name: CreateDocument :one
 INSERT INTO docs (
-  text, url, message_id
+  text, url, message_id, raw, subject, from_name, from_email, sent_at, in_reply_to, refs, clean_subject, is_patch, patch_prefixes, patch_tree, patch_version, patch_index, patch_total, change_id, touched_files, lines_added, lines_removed, to_addrs, cc_addrs
 ) VALUES (
-  $1, $2, $3
+  $1, $2, $3, coalesce($4::bytea, ''::bytea), $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23
 )
 ON CONFLICT (message_id) 
 DO UPDATE SET
   text = EXCLUDED.text,
-  url = EXCLUDED.url
+  url = EXCLUDED.url,
+  raw = EXCLUDED.raw,
+  subject = EXCLUDED.subject,
+  from_name = EXCLUDED.from_name,
+  from_email = EXCLUDED.from_email,
+  sent_at = EXCLUDED.sent_at,
+  in_reply_to = EXCLUDED.in_reply_to,
+  refs = EXCLUDED.refs,
+  clean_subject = EXCLUDED.clean_subject,
+  is_patch = EXCLUDED.is_patch,
+  patch_prefixes = EXCLUDED.patch_prefixes,
+  patch_tree = EXCLUDED.patch_tree,
+  patch_version = EXCLUDED.patch_version,
+  patch_index = EXCLUDED.patch_index,
+  patch_total = EXCLUDED.patch_total,
+  change_id = EXCLUDED.change_id,
+  touched_files = EXCLUDED.touched_files,
+  lines_added = EXCLUDED.lines_added,
+  lines_removed = EXCLUDED.lines_removed,
+  to_addrs = EXCLUDED.to_addrs,
+  cc_addrs = EXCLUDED.cc_addrs
 RETURNING *;
+
+-- name: CreateIngestRun :one
+INSERT INTO ingest_runs (
+  source, source_hash
+) VALUES (
+  $1, $2
+)
+RETURNING *;
+
+-- name: GetLatestIngestRun :one
+SELECT * FROM ingest_runs
+WHERE source_hash = $1 AND finished_at IS NULL
+ORDER BY id DESC LIMIT 1;
+
+-- name: UpdateIngestRunCheckpoint :exec
+UPDATE ingest_runs
+SET byte_offset = $2, message_count = $3, updated_at = now()
+WHERE id = $1;
+
+-- name: FinishIngestRun :exec
+UPDATE ingest_runs
+SET byte_offset = $2, message_count = $3, updated_at = now(), finished_at = now()
+WHERE id = $1;
+
+-- name: GetPublicInboxEpoch :one
+SELECT * FROM public_inbox_epochs
+WHERE git_dir = $1 LIMIT 1;
+
+-- name: UpsertPublicInboxEpoch :exec
+INSERT INTO public_inbox_epochs (
+  git_dir, last_commit, message_count
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT (git_dir)
+DO UPDATE SET
+  last_commit = EXCLUDED.last_commit,
+  message_count = public_inbox_epochs.message_count + EXCLUDED.message_count,
+  updated_at = now();
+
+-- name: ListThreadingMessages :many
+SELECT id, message_id, in_reply_to, refs, subject, sent_at FROM docs
+WHERE id > $1
+ORDER BY id
+LIMIT $2;
+
+-- name: GetThread :one
+SELECT * FROM threads
+WHERE id = $1 LIMIT 1;
+
+-- name: GetThreadMemberByDocID :one
+SELECT * FROM thread_members
+WHERE doc_id = $1 LIMIT 1;
+
+-- name: ListThreadMembers :many
+SELECT thread_members.message_id, thread_members.doc_id, thread_members.parent_message_id,
+  thread_members.depth, thread_members.position,
+  docs.subject, docs.from_name, docs.from_email, docs.sent_at
+FROM thread_members
+LEFT JOIN docs ON docs.id = thread_members.doc_id
+WHERE thread_members.thread_id = $1
+ORDER BY thread_members.position;
+
+-- name: ListSeriesCandidates :many
+SELECT docs.id, docs.message_id, docs.clean_subject, docs.from_name, docs.from_email, docs.sent_at,
+  docs.patch_version, docs.patch_index, docs.patch_total, docs.change_id, docs.touched_files,
+  thread_members.thread_id
+FROM docs
+LEFT JOIN thread_members ON thread_members.doc_id = docs.id
+WHERE docs.is_patch AND docs.id > $1
+ORDER BY docs.id
+LIMIT $2;
+
+-- name: GetSeries :one
+SELECT * FROM series
+WHERE id = $1 LIMIT 1;
+
+-- name: GetSeriesPatchByDocID :one
+SELECT * FROM series_patches
+WHERE doc_id = $1 LIMIT 1;
+
+-- name: ListSeriesPatches :many
+SELECT series_patches.doc_id, series_patches.patch_index,
+  docs.message_id, docs.subject, docs.from_name, docs.from_email, docs.sent_at
+FROM series_patches
+JOIN docs ON docs.id = series_patches.doc_id
+WHERE series_patches.series_id = $1
+ORDER BY series_patches.patch_index, docs.sent_at, docs.id;
+
+-- name: ListSeriesVersions :many
+SELECT * FROM series
+WHERE lineage = (SELECT s.lineage FROM series s WHERE s.id = $1)
+ORDER BY version, sent_at, id;
+
+-- name: ListPatchTrailers :many
+SELECT * FROM trailers
+WHERE patch_doc_id = $1
+ORDER BY doc_id, position;
+
+-- name: ListSeriesMessages :many
+SELECT series_patches.doc_id, series_patches.patch_index, docs.raw
+FROM series_patches
+JOIN docs ON docs.id = series_patches.doc_id
+WHERE series_patches.series_id = $1
+ORDER BY series_patches.patch_index, docs.sent_at, docs.id;
+
+-- name: ListTrailerKeys :many
+SELECT DISTINCT patch_doc_id, key FROM trailers
+WHERE patch_doc_id = ANY(@ids::bigint[])
+ORDER BY patch_doc_id, key;
+
+-- name: GetIndexerState :one
+SELECT * FROM indexer_state
+WHERE name = $1 LIMIT 1;
+
+-- name: RegisterIndexer :execrows
+INSERT INTO indexer_state (name) VALUES ($1)
+ON CONFLICT (name) DO NOTHING;
+
+-- name: UpsertIndexerState :exec
+INSERT INTO indexer_state (
+  name, last_doc_id
+) VALUES (
+  $1, $2
+)
+ON CONFLICT (name)
+DO UPDATE SET
+  last_doc_id = GREATEST(indexer_state.last_doc_id, EXCLUDED.last_doc_id),
+  updated_at = now();
+
+-- name: ListSearchOutbox :many
+SELECT * FROM search_outbox
+WHERE indexer = $1
+ORDER BY id
+LIMIT $2;
+
+-- name: DeleteSearchOutbox :exec
+DELETE FROM search_outbox
+WHERE id = ANY(@ids::bigint[]);
@@ -7,41 +7,195 @@ package db
 
 import (
 	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const countDocuments = `-- name: CountDocuments :one
+SELECT count(*) FROM docs
+`
+
+func (q *Queries) CountDocuments(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countDocuments)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createDocument = `-- name: CreateDocument :one
 INSERT INTO docs (
-  text, url, message_id
+  text, url, message_id, raw, subject, from_name, from_email, sent_at, in_reply_to, refs, clean_subject, is_patch, patch_prefixes, patch_tree, patch_version, patch_index, patch_total, change_id, touched_files, lines_added, lines_removed, to_addrs, cc_addrs
 ) VALUES (
-  $1, $2, $3
+  $1, $2, $3, coalesce($4::bytea, ''::bytea), $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23
 )
 ON CONFLICT (message_id) 
 DO UPDATE SET
   text = EXCLUDED.text,
-  url = EXCLUDED.url
-RETURNING id, text, url, message_id
+  url = EXCLUDED.url,
+  raw = EXCLUDED.raw,
+  subject = EXCLUDED.subject,
+  from_name = EXCLUDED.from_name,
+  from_email = EXCLUDED.from_email,
+  sent_at = EXCLUDED.sent_at,
+  in_reply_to = EXCLUDED.in_reply_to,
+  refs = EXCLUDED.refs,
+  clean_subject = EXCLUDED.clean_subject,
+  is_patch = EXCLUDED.is_patch,
+  patch_prefixes = EXCLUDED.patch_prefixes,
+  patch_tree = EXCLUDED.patch_tree,
+  patch_version = EXCLUDED.patch_version,
+  patch_index = EXCLUDED.patch_index,
+  patch_total = EXCLUDED.patch_total,
+  change_id = EXCLUDED.change_id,
+  touched_files = EXCLUDED.touched_files,
+  lines_added = EXCLUDED.lines_added,
+  lines_removed = EXCLUDED.lines_removed,
+  to_addrs = EXCLUDED.to_addrs,
+  cc_addrs = EXCLUDED.cc_addrs
+RETURNING id, text, url, message_id, raw, subject, from_name, from_email, sent_at, in_reply_to, refs, clean_subject, is_patch, patch_prefixes, patch_tree, patch_version, patch_index, patch_total, change_id, touched_files, lines_added, lines_removed, to_addrs, cc_addrs
 `
 
 type CreateDocumentParams struct {
-	Text      string
-	Url       string
-	MessageID string
+	Text          string
+	Url           string
+	MessageID     string
+	Raw           []byte
+	Subject       string
+	FromName      string
+	FromEmail     string
+	SentAt        pgtype.Timestamptz
+	InReplyTo     string
+	Refs          []string
+	CleanSubject  string
+	IsPatch       bool
+	PatchPrefixes []string
+	PatchTree     string
+	PatchVersion  int32
+	PatchIndex    int32
+	PatchTotal    int32
+	ChangeID      string
+	TouchedFiles  []string
+	LinesAdded    int32
+	LinesRemoved  int32
+	ToAddrs       []string
+	CcAddrs       []string
 }
 
 func (q *Queries) CreateDocument(ctx context.Context, arg CreateDocumentParams) (Doc, error) {
-	row := q.db.QueryRow(ctx, createDocument, arg.Text, arg.Url, arg.MessageID)
+	row := q.db.QueryRow(ctx, createDocument,
+		arg.Text,
+		arg.Url,
+		arg.MessageID,
+		arg.Raw,
+		arg.Subject,
+		arg.FromName,
+		arg.FromEmail,
+		arg.SentAt,
+		arg.InReplyTo,
+		arg.Refs,
+		arg.CleanSubject,
+		arg.IsPatch,
+		arg.PatchPrefixes,
+		arg.PatchTree,
+		arg.PatchVersion,
+		arg.PatchIndex,
+		arg.PatchTotal,
+		arg.ChangeID,
+		arg.TouchedFiles,
+		arg.LinesAdded,
+		arg.LinesRemoved,
+		arg.ToAddrs,
+		arg.CcAddrs,
+	)
 	var i Doc
 	err := row.Scan(
 		&i.ID,
 		&i.Text,
 		&i.Url,
 		&i.MessageID,
+		&i.Raw,
+		&i.Subject,
+		&i.FromName,
+		&i.FromEmail,
+		&i.SentAt,
+		&i.InReplyTo,
+		&i.Refs,
+		&i.CleanSubject,
+		&i.IsPatch,
+		&i.PatchPrefixes,
+		&i.PatchTree,
+		&i.PatchVersion,
+		&i.PatchIndex,
+		&i.PatchTotal,
+		&i.ChangeID,
+		&i.TouchedFiles,
+		&i.LinesAdded,
+		&i.LinesRemoved,
+		&i.ToAddrs,
+		&i.CcAddrs,
+	)
+	return i, err
+}
+
+const createIngestRun = `-- name: CreateIngestRun :one
+INSERT INTO ingest_runs (
+  source, source_hash
+) VALUES (
+  $1, $2
+)
+RETURNING id, source, source_hash, byte_offset, message_count, started_at, updated_at, finished_at
+`
+
+type CreateIngestRunParams struct {
+	Source     string
+	SourceHash string
+}
+
+func (q *Queries) CreateIngestRun(ctx context.Context, arg CreateIngestRunParams) (IngestRun, error) {
+	row := q.db.QueryRow(ctx, createIngestRun, arg.Source, arg.SourceHash)
+	var i IngestRun
+	err := row.Scan(
+		&i.ID,
+		&i.Source,
+		&i.SourceHash,
+		&i.ByteOffset,
+		&i.MessageCount,
+		&i.StartedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
 	)
 	return i, err
 }
 
+const deleteSearchOutbox = `-- name: DeleteSearchOutbox :exec
+DELETE FROM search_outbox
+WHERE id = ANY($1::bigint[])
+`
+
+func (q *Queries) DeleteSearchOutbox(ctx context.Context, ids []int64) error {
+	_, err := q.db.Exec(ctx, deleteSearchOutbox, ids)
+	return err
+}
+
+const finishIngestRun = `-- name: FinishIngestRun :exec
+UPDATE ingest_runs
+SET byte_offset = $2, message_count = $3, updated_at = now(), finished_at = now()
+WHERE id = $1
+`
+
+type FinishIngestRunParams struct {
+	ID           int64
+	ByteOffset   int64
+	MessageCount int64
+}
+
+func (q *Queries) FinishIngestRun(ctx context.Context, arg FinishIngestRunParams) error {
+	_, err := q.db.Exec(ctx, finishIngestRun, arg.ID, arg.ByteOffset, arg.MessageCount)
+	return err
+}
+
 const getDocumentByID = `-- name: GetDocumentByID :one
-SELECT id, text, url, message_id FROM docs
+SELECT id, text, url, message_id, raw, subject, from_name, from_email, sent_at, in_reply_to, refs, clean_subject, is_patch, patch_prefixes, patch_tree, patch_version, patch_index, patch_total, change_id, touched_files, lines_added, lines_removed, to_addrs, cc_addrs FROM docs
 WHERE id = $1 LIMIT 1
 `
 
@@ -53,12 +207,196 @@ func (q *Queries) GetDocumentByID(ctx context.Context, id int64) (Doc, error) {
 		&i.Text,
 		&i.Url,
 		&i.MessageID,
+		&i.Raw,
+		&i.Subject,
+		&i.FromName,
+		&i.FromEmail,
+		&i.SentAt,
+		&i.InReplyTo,
+		&i.Refs,
+		&i.CleanSubject,
+		&i.IsPatch,
+		&i.PatchPrefixes,
+		&i.PatchTree,
+		&i.PatchVersion,
+		&i.PatchIndex,
+		&i.PatchTotal,
+		&i.ChangeID,
+		&i.TouchedFiles,
+		&i.LinesAdded,
+		&i.LinesRemoved,
+		&i.ToAddrs,
+		&i.CcAddrs,
+	)
+	return i, err
+}
+
+const getDocumentByMessageID = `-- name: GetDocumentByMessageID :one
+SELECT id, text, url, message_id, raw, subject, from_name, from_email, sent_at, in_reply_to, refs, clean_subject, is_patch, patch_prefixes, patch_tree, patch_version, patch_index, patch_total, change_id, touched_files, lines_added, lines_removed, to_addrs, cc_addrs FROM docs
+WHERE message_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetDocumentByMessageID(ctx context.Context, messageID string) (Doc, error) {
+	row := q.db.QueryRow(ctx, getDocumentByMessageID, messageID)
+	var i Doc
+	err := row.Scan(
+		&i.ID,
+		&i.Text,
+		&i.Url,
+		&i.MessageID,
+		&i.Raw,
+		&i.Subject,
+		&i.FromName,
+		&i.FromEmail,
+		&i.SentAt,
+		&i.InReplyTo,
+		&i.Refs,
+		&i.CleanSubject,
+		&i.IsPatch,
+		&i.PatchPrefixes,
+		&i.PatchTree,
+		&i.PatchVersion,
+		&i.PatchIndex,
+		&i.PatchTotal,
+		&i.ChangeID,
+		&i.TouchedFiles,
+		&i.LinesAdded,
+		&i.LinesRemoved,
+		&i.ToAddrs,
+		&i.CcAddrs,
+	)
+	return i, err
+}
+
+const getIndexerState = `-- name: GetIndexerState :one
+SELECT name, last_doc_id, updated_at FROM indexer_state
+WHERE name = $1 LIMIT 1
+`
+
+func (q *Queries) GetIndexerState(ctx context.Context, name string) (IndexerState, error) {
+	row := q.db.QueryRow(ctx, getIndexerState, name)
+	var i IndexerState
+	err := row.Scan(&i.Name, &i.LastDocID, &i.UpdatedAt)
+	return i, err
+}
+
+const getLatestIngestRun = `-- name: GetLatestIngestRun :one
+SELECT id, source, source_hash, byte_offset, message_count, started_at, updated_at, finished_at FROM ingest_runs
+WHERE source_hash = $1 AND finished_at IS NULL
+ORDER BY id DESC LIMIT 1
+`
+
+func (q *Queries) GetLatestIngestRun(ctx context.Context, sourceHash string) (IngestRun, error) {
+	row := q.db.QueryRow(ctx, getLatestIngestRun, sourceHash)
+	var i IngestRun
+	err := row.Scan(
+		&i.ID,
+		&i.Source,
+		&i.SourceHash,
+		&i.ByteOffset,
+		&i.MessageCount,
+		&i.StartedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+	)
+	return i, err
+}
+
+const getPublicInboxEpoch = `-- name: GetPublicInboxEpoch :one
+SELECT git_dir, last_commit, message_count, updated_at FROM public_inbox_epochs
+WHERE git_dir = $1 LIMIT 1
+`
+
+func (q *Queries) GetPublicInboxEpoch(ctx context.Context, gitDir string) (PublicInboxEpoch, error) {
+	row := q.db.QueryRow(ctx, getPublicInboxEpoch, gitDir)
+	var i PublicInboxEpoch
+	err := row.Scan(
+		&i.GitDir,
+		&i.LastCommit,
+		&i.MessageCount,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getSeries = `-- name: GetSeries :one
+SELECT id, root_message_id, subject, from_name, from_email, version, total, received, complete, sent_at, change_id, lineage, updated_at FROM series
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetSeries(ctx context.Context, id int64) (Series, error) {
+	row := q.db.QueryRow(ctx, getSeries, id)
+	var i Series
+	err := row.Scan(
+		&i.ID,
+		&i.RootMessageID,
+		&i.Subject,
+		&i.FromName,
+		&i.FromEmail,
+		&i.Version,
+		&i.Total,
+		&i.Received,
+		&i.Complete,
+		&i.SentAt,
+		&i.ChangeID,
+		&i.Lineage,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getSeriesPatchByDocID = `-- name: GetSeriesPatchByDocID :one
+SELECT series_id, doc_id, patch_index FROM series_patches
+WHERE doc_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetSeriesPatchByDocID(ctx context.Context, docID int64) (SeriesPatch, error) {
+	row := q.db.QueryRow(ctx, getSeriesPatchByDocID, docID)
+	var i SeriesPatch
+	err := row.Scan(&i.SeriesID, &i.DocID, &i.PatchIndex)
+	return i, err
+}
+
+const getThread = `-- name: GetThread :one
+SELECT id, root_message_id, subject, message_count, last_activity, updated_at FROM threads
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetThread(ctx context.Context, id int64) (Thread, error) {
+	row := q.db.QueryRow(ctx, getThread, id)
+	var i Thread
+	err := row.Scan(
+		&i.ID,
+		&i.RootMessageID,
+		&i.Subject,
+		&i.MessageCount,
+		&i.LastActivity,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getThreadMemberByDocID = `-- name: GetThreadMemberByDocID :one
+SELECT thread_id, message_id, doc_id, parent_message_id, depth, position FROM thread_members
+WHERE doc_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetThreadMemberByDocID(ctx context.Context, docID pgtype.Int8) (ThreadMember, error) {
+	row := q.db.QueryRow(ctx, getThreadMemberByDocID, docID)
+	var i ThreadMember
+	err := row.Scan(
+		&i.ThreadID,
+		&i.MessageID,
+		&i.DocID,
+		&i.ParentMessageID,
+		&i.Depth,
+		&i.Position,
 	)
 	return i, err
 }
 
 const listDocuments = `-- name: ListDocuments :many
-SELECT id, text, url, message_id FROM docs
+SELECT id, text, url, message_id, raw, subject, from_name, from_email, sent_at, in_reply_to, refs, clean_subject, is_patch, patch_prefixes, patch_tree, patch_version, patch_index, patch_total, change_id, touched_files, lines_added, lines_removed, to_addrs, cc_addrs FROM docs
 ORDER BY id
 LIMIT $1 OFFSET $2
 `
@@ -82,6 +420,351 @@ func (q *Queries) ListDocuments(ctx context.Context, arg ListDocumentsParams) ([
 			&i.Text,
 			&i.Url,
 			&i.MessageID,
+			&i.Raw,
+			&i.Subject,
+			&i.FromName,
+			&i.FromEmail,
+			&i.SentAt,
+			&i.InReplyTo,
+			&i.Refs,
+			&i.CleanSubject,
+			&i.IsPatch,
+			&i.PatchPrefixes,
+			&i.PatchTree,
+			&i.PatchVersion,
+			&i.PatchIndex,
+			&i.PatchTotal,
+			&i.ChangeID,
+			&i.TouchedFiles,
+			&i.LinesAdded,
+			&i.LinesRemoved,
+			&i.ToAddrs,
+			&i.CcAddrs,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDocumentsAfter = `-- name: ListDocumentsAfter :many
+SELECT id, text, url, message_id, raw, subject, from_name, from_email, sent_at, in_reply_to, refs, clean_subject, is_patch, patch_prefixes, patch_tree, patch_version, patch_index, patch_total, change_id, touched_files, lines_added, lines_removed, to_addrs, cc_addrs FROM docs
+WHERE id > $1
+ORDER BY id
+LIMIT $2
+`
+
+type ListDocumentsAfterParams struct {
+	ID    int64
+	Limit int32
+}
+
+func (q *Queries) ListDocumentsAfter(ctx context.Context, arg ListDocumentsAfterParams) ([]Doc, error) {
+	rows, err := q.db.Query(ctx, listDocumentsAfter, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Doc
+	for rows.Next() {
+		var i Doc
+		if err := rows.Scan(
+			&i.ID,
+			&i.Text,
+			&i.Url,
+			&i.MessageID,
+			&i.Raw,
+			&i.Subject,
+			&i.FromName,
+			&i.FromEmail,
+			&i.SentAt,
+			&i.InReplyTo,
+			&i.Refs,
+			&i.CleanSubject,
+			&i.IsPatch,
+			&i.PatchPrefixes,
+			&i.PatchTree,
+			&i.PatchVersion,
+			&i.PatchIndex,
+			&i.PatchTotal,
+			&i.ChangeID,
+			&i.TouchedFiles,
+			&i.LinesAdded,
+			&i.LinesRemoved,
+			&i.ToAddrs,
+			&i.CcAddrs,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDocumentsByIDs = `-- name: ListDocumentsByIDs :many
+SELECT id, text, url, message_id, raw, subject, from_name, from_email, sent_at, in_reply_to, refs, clean_subject, is_patch, patch_prefixes, patch_tree, patch_version, patch_index, patch_total, change_id, touched_files, lines_added, lines_removed, to_addrs, cc_addrs FROM docs
+WHERE id = ANY($1::bigint[])
+ORDER BY id
+`
+
+func (q *Queries) ListDocumentsByIDs(ctx context.Context, ids []int64) ([]Doc, error) {
+	rows, err := q.db.Query(ctx, listDocumentsByIDs, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Doc
+	for rows.Next() {
+		var i Doc
+		if err := rows.Scan(
+			&i.ID,
+			&i.Text,
+			&i.Url,
+			&i.MessageID,
+			&i.Raw,
+			&i.Subject,
+			&i.FromName,
+			&i.FromEmail,
+			&i.SentAt,
+			&i.InReplyTo,
+			&i.Refs,
+			&i.CleanSubject,
+			&i.IsPatch,
+			&i.PatchPrefixes,
+			&i.PatchTree,
+			&i.PatchVersion,
+			&i.PatchIndex,
+			&i.PatchTotal,
+			&i.ChangeID,
+			&i.TouchedFiles,
+			&i.LinesAdded,
+			&i.LinesRemoved,
+			&i.ToAddrs,
+			&i.CcAddrs,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPatchTrailers = `-- name: ListPatchTrailers :many
+SELECT doc_id, position, key, name, email, value, patch_doc_id FROM trailers
+WHERE patch_doc_id = $1
+ORDER BY doc_id, position
+`
+
+func (q *Queries) ListPatchTrailers(ctx context.Context, patchDocID pgtype.Int8) ([]Trailer, error) {
+	rows, err := q.db.Query(ctx, listPatchTrailers, patchDocID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Trailer
+	for rows.Next() {
+		var i Trailer
+		if err := rows.Scan(
+			&i.DocID,
+			&i.Position,
+			&i.Key,
+			&i.Name,
+			&i.Email,
+			&i.Value,
+			&i.PatchDocID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSearchOutbox = `-- name: ListSearchOutbox :many
+SELECT id, indexer, doc_id, op, created_at FROM search_outbox
+WHERE indexer = $1
+ORDER BY id
+LIMIT $2
+`
+
+type ListSearchOutboxParams struct {
+	Indexer string
+	Limit   int32
+}
+
+func (q *Queries) ListSearchOutbox(ctx context.Context, arg ListSearchOutboxParams) ([]SearchOutbox, error) {
+	rows, err := q.db.Query(ctx, listSearchOutbox, arg.Indexer, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchOutbox
+	for rows.Next() {
+		var i SearchOutbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.Indexer,
+			&i.DocID,
+			&i.Op,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSeriesCandidates = `-- name: ListSeriesCandidates :many
+SELECT docs.id, docs.message_id, docs.clean_subject, docs.from_name, docs.from_email, docs.sent_at,
+  docs.patch_version, docs.patch_index, docs.patch_total, docs.change_id, docs.touched_files,
+  thread_members.thread_id
+FROM docs
+LEFT JOIN thread_members ON thread_members.doc_id = docs.id
+WHERE docs.is_patch AND docs.id > $1
+ORDER BY docs.id
+LIMIT $2
+`
+
+type ListSeriesCandidatesParams struct {
+	ID    int64
+	Limit int32
+}
+
+type ListSeriesCandidatesRow struct {
+	ID           int64
+	MessageID    string
+	CleanSubject string
+	FromName     string
+	FromEmail    string
+	SentAt       pgtype.Timestamptz
+	PatchVersion int32
+	PatchIndex   int32
+	PatchTotal   int32
+	ChangeID     string
+	TouchedFiles []string
+	ThreadID     pgtype.Int8
+}
+
+func (q *Queries) ListSeriesCandidates(ctx context.Context, arg ListSeriesCandidatesParams) ([]ListSeriesCandidatesRow, error) {
+	rows, err := q.db.Query(ctx, listSeriesCandidates, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSeriesCandidatesRow
+	for rows.Next() {
+		var i ListSeriesCandidatesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageID,
+			&i.CleanSubject,
+			&i.FromName,
+			&i.FromEmail,
+			&i.SentAt,
+			&i.PatchVersion,
+			&i.PatchIndex,
+			&i.PatchTotal,
+			&i.ChangeID,
+			&i.TouchedFiles,
+			&i.ThreadID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSeriesMessages = `-- name: ListSeriesMessages :many
+SELECT series_patches.doc_id, series_patches.patch_index, docs.raw
+FROM series_patches
+JOIN docs ON docs.id = series_patches.doc_id
+WHERE series_patches.series_id = $1
+ORDER BY series_patches.patch_index, docs.sent_at, docs.id
+`
+
+type ListSeriesMessagesRow struct {
+	DocID      int64
+	PatchIndex int32
+	Raw        []byte
+}
+
+func (q *Queries) ListSeriesMessages(ctx context.Context, seriesID int64) ([]ListSeriesMessagesRow, error) {
+	rows, err := q.db.Query(ctx, listSeriesMessages, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSeriesMessagesRow
+	for rows.Next() {
+		var i ListSeriesMessagesRow
+		if err := rows.Scan(&i.DocID, &i.PatchIndex, &i.Raw); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSeriesPatches = `-- name: ListSeriesPatches :many
+SELECT series_patches.doc_id, series_patches.patch_index,
+  docs.message_id, docs.subject, docs.from_name, docs.from_email, docs.sent_at
+FROM series_patches
+JOIN docs ON docs.id = series_patches.doc_id
+WHERE series_patches.series_id = $1
+ORDER BY series_patches.patch_index, docs.sent_at, docs.id
+`
+
+type ListSeriesPatchesRow struct {
+	DocID      int64
+	PatchIndex int32
+	MessageID  string
+	Subject    string
+	FromName   string
+	FromEmail  string
+	SentAt     pgtype.Timestamptz
+}
+
+func (q *Queries) ListSeriesPatches(ctx context.Context, seriesID int64) ([]ListSeriesPatchesRow, error) {
+	rows, err := q.db.Query(ctx, listSeriesPatches, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSeriesPatchesRow
+	for rows.Next() {
+		var i ListSeriesPatchesRow
+		if err := rows.Scan(
+			&i.DocID,
+			&i.PatchIndex,
+			&i.MessageID,
+			&i.Subject,
+			&i.FromName,
+			&i.FromEmail,
+			&i.SentAt,
 		); err != nil {
 			return nil, err
 		}
@@ -92,3 +775,250 @@ func (q *Queries) ListDocuments(ctx context.Context, arg ListDocumentsParams) ([
 	}
 	return items, nil
 }
+
+const listSeriesVersions = `-- name: ListSeriesVersions :many
+SELECT id, root_message_id, subject, from_name, from_email, version, total, received, complete, sent_at, change_id, lineage, updated_at FROM series
+WHERE lineage = (SELECT s.lineage FROM series s WHERE s.id = $1)
+ORDER BY version, sent_at, id
+`
+
+func (q *Queries) ListSeriesVersions(ctx context.Context, id int64) ([]Series, error) {
+	rows, err := q.db.Query(ctx, listSeriesVersions, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Series
+	for rows.Next() {
+		var i Series
+		if err := rows.Scan(
+			&i.ID,
+			&i.RootMessageID,
+			&i.Subject,
+			&i.FromName,
+			&i.FromEmail,
+			&i.Version,
+			&i.Total,
+			&i.Received,
+			&i.Complete,
+			&i.SentAt,
+			&i.ChangeID,
+			&i.Lineage,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listThreadMembers = `-- name: ListThreadMembers :many
+SELECT thread_members.message_id, thread_members.doc_id, thread_members.parent_message_id,
+  thread_members.depth, thread_members.position,
+  docs.subject, docs.from_name, docs.from_email, docs.sent_at
+FROM thread_members
+LEFT JOIN docs ON docs.id = thread_members.doc_id
+WHERE thread_members.thread_id = $1
+ORDER BY thread_members.position
+`
+
+type ListThreadMembersRow struct {
+	MessageID       string
+	DocID           pgtype.Int8
+	ParentMessageID string
+	Depth           int32
+	Position        int32
+	Subject         pgtype.Text
+	FromName        pgtype.Text
+	FromEmail       pgtype.Text
+	SentAt          pgtype.Timestamptz
+}
+
+func (q *Queries) ListThreadMembers(ctx context.Context, threadID int64) ([]ListThreadMembersRow, error) {
+	rows, err := q.db.Query(ctx, listThreadMembers, threadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListThreadMembersRow
+	for rows.Next() {
+		var i ListThreadMembersRow
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.DocID,
+			&i.ParentMessageID,
+			&i.Depth,
+			&i.Position,
+			&i.Subject,
+			&i.FromName,
+			&i.FromEmail,
+			&i.SentAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listThreadingMessages = `-- name: ListThreadingMessages :many
+SELECT id, message_id, in_reply_to, refs, subject, sent_at FROM docs
+WHERE id > $1
+ORDER BY id
+LIMIT $2
+`
+
+type ListThreadingMessagesParams struct {
+	ID    int64
+	Limit int32
+}
+
+type ListThreadingMessagesRow struct {
+	ID        int64
+	MessageID string
+	InReplyTo string
+	Refs      []string
+	Subject   string
+	SentAt    pgtype.Timestamptz
+}
+
+func (q *Queries) ListThreadingMessages(ctx context.Context, arg ListThreadingMessagesParams) ([]ListThreadingMessagesRow, error) {
+	rows, err := q.db.Query(ctx, listThreadingMessages, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListThreadingMessagesRow
+	for rows.Next() {
+		var i ListThreadingMessagesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageID,
+			&i.InReplyTo,
+			&i.Refs,
+			&i.Subject,
+			&i.SentAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTrailerKeys = `-- name: ListTrailerKeys :many
+SELECT DISTINCT patch_doc_id, key FROM trailers
+WHERE patch_doc_id = ANY($1::bigint[])
+ORDER BY patch_doc_id, key
+`
+
+type ListTrailerKeysRow struct {
+	PatchDocID pgtype.Int8
+	Key        string
+}
+
+func (q *Queries) ListTrailerKeys(ctx context.Context, ids []int64) ([]ListTrailerKeysRow, error) {
+	rows, err := q.db.Query(ctx, listTrailerKeys, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTrailerKeysRow
+	for rows.Next() {
+		var i ListTrailerKeysRow
+		if err := rows.Scan(&i.PatchDocID, &i.Key); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const registerIndexer = `-- name: RegisterIndexer :execrows
+INSERT INTO indexer_state (name) VALUES ($1)
+ON CONFLICT (name) DO NOTHING
+`
+
+func (q *Queries) RegisterIndexer(ctx context.Context, name string) (int64, error) {
+	result, err := q.db.Exec(ctx, registerIndexer, name)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateIngestRunCheckpoint = `-- name: UpdateIngestRunCheckpoint :exec
+UPDATE ingest_runs
+SET byte_offset = $2, message_count = $3, updated_at = now()
+WHERE id = $1
+`
+
+type UpdateIngestRunCheckpointParams struct {
+	ID           int64
+	ByteOffset   int64
+	MessageCount int64
+}
+
+func (q *Queries) UpdateIngestRunCheckpoint(ctx context.Context, arg UpdateIngestRunCheckpointParams) error {
+	_, err := q.db.Exec(ctx, updateIngestRunCheckpoint, arg.ID, arg.ByteOffset, arg.MessageCount)
+	return err
+}
+
+const upsertIndexerState = `-- name: UpsertIndexerState :exec
+INSERT INTO indexer_state (
+  name, last_doc_id
+) VALUES (
+  $1, $2
+)
+ON CONFLICT (name)
+DO UPDATE SET
+  last_doc_id = GREATEST(indexer_state.last_doc_id, EXCLUDED.last_doc_id),
+  updated_at = now()
+`
+
+type UpsertIndexerStateParams struct {
+	Name      string
+	LastDocID int64
+}
+
+func (q *Queries) UpsertIndexerState(ctx context.Context, arg UpsertIndexerStateParams) error {
+	_, err := q.db.Exec(ctx, upsertIndexerState, arg.Name, arg.LastDocID)
+	return err
+}
+
+const upsertPublicInboxEpoch = `-- name: UpsertPublicInboxEpoch :exec
+INSERT INTO public_inbox_epochs (
+  git_dir, last_commit, message_count
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT (git_dir)
+DO UPDATE SET
+  last_commit = EXCLUDED.last_commit,
+  message_count = public_inbox_epochs.message_count + EXCLUDED.message_count,
+  updated_at = now()
+`
+
+type UpsertPublicInboxEpochParams struct {
+	GitDir       string
+	LastCommit   string
+	MessageCount int64
+}
+
+func (q *Queries) UpsertPublicInboxEpoch(ctx context.Context, arg UpsertPublicInboxEpochParams) error {
+	_, err := q.db.Exec(ctx, upsertPublicInboxEpoch, arg.GitDir, arg.LastCommit, arg.MessageCount)
+	return err
+}
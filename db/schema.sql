@@ -2,8 +2,243 @@ CREATE TABLE docs (
 	id BIGSERIAL PRIMARY KEY,
 	text text NOT NULL,
 	url text NOT NULL,
-	message_id text NOT NULL UNIQUE
+	message_id text NOT NULL UNIQUE,
+	raw bytea NOT NULL DEFAULT '',
+	subject text NOT NULL DEFAULT '',
+	from_name text NOT NULL DEFAULT '',
+	from_email text NOT NULL DEFAULT '',
+	sent_at timestamptz,
+	in_reply_to text NOT NULL DEFAULT '',
+	refs text[],
+	clean_subject text NOT NULL DEFAULT '',
+	is_patch boolean NOT NULL DEFAULT false,
+	patch_prefixes text[],
+	patch_tree text NOT NULL DEFAULT '',
+	patch_version integer NOT NULL DEFAULT 0,
+	patch_index integer NOT NULL DEFAULT 0,
+	patch_total integer NOT NULL DEFAULT 0,
+	change_id text NOT NULL DEFAULT '',
+	touched_files text[],
+	lines_added integer NOT NULL DEFAULT 0,
+	lines_removed integer NOT NULL DEFAULT 0,
+	to_addrs text[],
+	cc_addrs text[]
 );
 
 CREATE INDEX idx_docs_url ON docs (url);
 CREATE INDEX idx_docs_message_id ON docs (message_id);
+
+CREATE TABLE ingest_runs (
+	id BIGSERIAL PRIMARY KEY,
+	source text NOT NULL,
+	source_hash text NOT NULL,
+	byte_offset bigint NOT NULL DEFAULT 0,
+	message_count bigint NOT NULL DEFAULT 0,
+	started_at timestamptz NOT NULL DEFAULT now(),
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	finished_at timestamptz
+);
+
+CREATE INDEX idx_ingest_runs_source_hash ON ingest_runs (source_hash);
+
+CREATE TABLE public_inbox_epochs (
+	git_dir text PRIMARY KEY,
+	last_commit text NOT NULL,
+	message_count bigint NOT NULL DEFAULT 0,
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE threads (
+	id BIGSERIAL PRIMARY KEY,
+	root_message_id text NOT NULL UNIQUE,
+	subject text NOT NULL,
+	message_count integer NOT NULL,
+	last_activity timestamptz,
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE thread_members (
+	thread_id bigint NOT NULL REFERENCES threads (id) ON DELETE CASCADE,
+	message_id text NOT NULL UNIQUE,
+	doc_id bigint REFERENCES docs (id) ON DELETE SET NULL,
+	parent_message_id text NOT NULL DEFAULT '',
+	depth integer NOT NULL,
+	position integer NOT NULL,
+	PRIMARY KEY (thread_id, message_id)
+);
+
+CREATE INDEX idx_thread_members_doc_id ON thread_members (doc_id);
+
+CREATE TABLE series (
+	id BIGSERIAL PRIMARY KEY,
+	root_message_id text NOT NULL UNIQUE,
+	subject text NOT NULL,
+	from_name text NOT NULL DEFAULT '',
+	from_email text NOT NULL DEFAULT '',
+	version integer NOT NULL,
+	total integer NOT NULL,
+	received integer NOT NULL,
+	complete boolean NOT NULL,
+	sent_at timestamptz,
+	change_id text NOT NULL DEFAULT '',
+	lineage text NOT NULL DEFAULT '',
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_series_lineage ON series (lineage);
+
+CREATE TABLE series_patches (
+	series_id bigint NOT NULL REFERENCES series (id) ON DELETE CASCADE,
+	doc_id bigint NOT NULL UNIQUE REFERENCES docs (id) ON DELETE CASCADE,
+	patch_index integer NOT NULL,
+	PRIMARY KEY (series_id, doc_id)
+);
+
+CREATE TABLE trailers (
+	doc_id bigint NOT NULL REFERENCES docs (id) ON DELETE CASCADE,
+	position integer NOT NULL,
+	key text NOT NULL,
+	name text NOT NULL DEFAULT '',
+	email text NOT NULL DEFAULT '',
+	value text NOT NULL,
+	patch_doc_id bigint REFERENCES docs (id) ON DELETE SET NULL,
+	PRIMARY KEY (doc_id, position)
+);
+
+CREATE INDEX idx_trailers_patch_doc_id ON trailers (patch_doc_id);
+
+CREATE TABLE indexer_state (
+	name text PRIMARY KEY,
+	last_doc_id bigint NOT NULL DEFAULT 0,
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- search_outbox records every change that affects a search document other
+-- than a new docs row, which the indexers find by its ID: docs rows being
+-- updated or deleted, and trailers moving between patches, since a patch's
+-- document lists the keys of its review trailers. A change is
+-- recorded once for every indexer in indexer_state, so indexers filling
+-- different backends each see all of them. Every indexer applies and deletes
+-- its own rows in id order. Deleting an indexer_state row drops its rows.
+CREATE TABLE search_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	indexer text NOT NULL REFERENCES indexer_state (name) ON DELETE CASCADE,
+	doc_id bigint NOT NULL,
+	op text NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_search_outbox_indexer ON search_outbox (indexer, id);
+
+CREATE FUNCTION record_docs_change() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		INSERT INTO search_outbox (indexer, doc_id, op) SELECT name, OLD.id, 'delete' FROM indexer_state;
+	ELSE
+		INSERT INTO search_outbox (indexer, doc_id, op) SELECT name, NEW.id, 'upsert' FROM indexer_state;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER docs_outbox AFTER DELETE ON docs
+	FOR EACH ROW EXECUTE FUNCTION record_docs_change();
+
+-- Re-ingesting a message that did not change is not an update.
+CREATE TRIGGER docs_outbox_update AFTER UPDATE ON docs
+	FOR EACH ROW WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE FUNCTION record_docs_change();
+
+-- A patch's own trailers are only written together with its docs row, which
+-- is recorded itself, so only trailers of replies are.
+CREATE FUNCTION record_trailers_change() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP <> 'INSERT' AND OLD.patch_doc_id <> OLD.doc_id THEN
+		INSERT INTO search_outbox (indexer, doc_id, op) SELECT name, OLD.patch_doc_id, 'upsert' FROM indexer_state;
+	END IF;
+	IF TG_OP <> 'DELETE' AND NEW.patch_doc_id <> NEW.doc_id THEN
+		INSERT INTO search_outbox (indexer, doc_id, op) SELECT name, NEW.patch_doc_id, 'upsert' FROM indexer_state;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trailers_outbox AFTER INSERT OR DELETE ON trailers
+	FOR EACH ROW EXECUTE FUNCTION record_trailers_change();
+
+CREATE TRIGGER trailers_outbox_update AFTER UPDATE OF patch_doc_id ON trailers
+	FOR EACH ROW WHEN (OLD.patch_doc_id IS DISTINCT FROM NEW.patch_doc_id)
+	EXECUTE FUNCTION record_trailers_change();
+
+-- docs_changed wakes up the search indexer. It fires once per statement and
+-- carries no payload, so a bulk upsert sends a single notification.
+CREATE FUNCTION notify_docs_changed() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('docs_changed', '');
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER search_outbox_notify AFTER INSERT ON search_outbox
+	FOR EACH STATEMENT EXECUTE FUNCTION notify_docs_changed();
+
+CREATE TRIGGER docs_notify AFTER INSERT ON docs
+	FOR EACH STATEMENT EXECUTE FUNCTION notify_docs_changed();
+
+-- Statements inserting into docs hold the advisory lock 0x646f6373 ("docs")
+-- shared until their transaction ends. Taking it exclusively waits for them,
+-- after which every ID up to the highest one in docs is committed or never
+-- will be, so the indexer's cursor can pass it without skipping a document.
+CREATE FUNCTION lock_docs_inserts() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_advisory_xact_lock_shared(1685021555);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER docs_insert_lock BEFORE INSERT ON docs
+	FOR EACH STATEMENT EXECUTE FUNCTION lock_docs_inserts();
+
+-- search_documents is the index of the Postgres search backend, one row per
+-- search.Document. Its columns mirror the Document fields.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE search_documents (
+	id bigint PRIMARY KEY,
+	text text NOT NULL,
+	url text NOT NULL,
+	message_id text NOT NULL,
+	subject text NOT NULL,
+	is_patch boolean NOT NULL,
+	patch_prefixes text[],
+	patch_tree text NOT NULL,
+	patch_version integer NOT NULL,
+	patch_index integer NOT NULL,
+	patch_total integer NOT NULL,
+	from_terms text[],
+	to_terms text[],
+	cc_terms text[],
+	subject_words text[],
+	diff_files text[],
+	hunk_headers text[],
+	sent_at bigint,
+	trailer_keys text[],
+	identifiers text[],
+	-- A tsvector is limited to 1MB, which the text of a huge patch could
+	-- exceed, so only its beginning is searchable.
+	tsv tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('english', subject), 'A') ||
+		setweight(to_tsvector('english', left(text, 500000)), 'B')
+	) STORED
+);
+
+CREATE INDEX idx_search_documents_tsv ON search_documents USING gin (tsv);
+CREATE INDEX idx_search_documents_subject ON search_documents USING gin (subject gin_trgm_ops);
+CREATE INDEX idx_search_documents_sent_at ON search_documents (sent_at);
+CREATE INDEX idx_search_documents_from_terms ON search_documents USING gin (from_terms);
+CREATE INDEX idx_search_documents_to_terms ON search_documents USING gin (to_terms);
+CREATE INDEX idx_search_documents_cc_terms ON search_documents USING gin (cc_terms);
+CREATE INDEX idx_search_documents_subject_words ON search_documents USING gin (subject_words);
+CREATE INDEX idx_search_documents_diff_files ON search_documents USING gin (diff_files);
+CREATE INDEX idx_search_documents_hunk_headers ON search_documents USING gin (hunk_headers);
+CREATE INDEX idx_search_documents_trailer_keys ON search_documents USING gin (trailer_keys);
+CREATE INDEX idx_search_documents_identifiers ON search_documents USING gin (identifiers);
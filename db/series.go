@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// SeriesParams is one row of series as written by ReplaceSeries. Lineage is
+// shared by all versions of a series: the root message ID of the first one.
+type SeriesParams struct {
+	RootMessageID string
+	Subject       string
+	FromName      string
+	FromEmail     string
+	Version       int32
+	Total         int32
+	Received      int32
+	Complete      bool
+	SentAt        pgtype.Timestamptz
+	ChangeID      string
+	Lineage       string
+}
+
+// SeriesPatchParams is one row of series_patches, identified by the root
+// message of its series like ThreadMemberParams.
+type SeriesPatchParams struct {
+	RootMessageID string
+	DocID         int64
+	PatchIndex    int32
+}
+
+// ReplaceSeries replaces the contents of series and series_patches in a
+// single transaction. Series keep their ID as long as their root message
+// stays the same.
+func ReplaceSeries(ctx context.Context, conn Beginner, series []SeriesParams, patches []SeriesPatchParams) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	columns := []string{
+		"root_message_id", "subject", "from_name", "from_email",
+		"version", "total", "received", "complete", "sent_at", "change_id", "lineage",
+	}
+	_, err = tx.Exec(ctx, `CREATE TEMP TABLE series_staging ON COMMIT DROP AS
+		SELECT root_message_id, subject, from_name, from_email, version, total, received, complete, sent_at, change_id, lineage
+		FROM series WITH NO DATA`)
+	if err != nil {
+		return fmt.Errorf("creating series staging table: %w", err)
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"series_staging"}, columns,
+		pgx.CopyFromSlice(len(series), func(i int) ([]any, error) {
+			s := series[i]
+			return []any{
+				s.RootMessageID, s.Subject, s.FromName, s.FromEmail,
+				s.Version, s.Total, s.Received, s.Complete, s.SentAt, s.ChangeID, s.Lineage,
+			}, nil
+		}))
+	if err != nil {
+		return fmt.Errorf("copying series: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM series
+		WHERE root_message_id NOT IN (SELECT root_message_id FROM series_staging)`)
+	if err != nil {
+		return fmt.Errorf("deleting series: %w", err)
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO series (root_message_id, subject, from_name, from_email, version, total, received, complete, sent_at, change_id, lineage)
+		SELECT root_message_id, subject, from_name, from_email, version, total, received, complete, sent_at, change_id, lineage
+		FROM series_staging
+		ON CONFLICT (root_message_id)
+		DO UPDATE SET
+			subject = EXCLUDED.subject,
+			from_name = EXCLUDED.from_name,
+			from_email = EXCLUDED.from_email,
+			version = EXCLUDED.version,
+			total = EXCLUDED.total,
+			received = EXCLUDED.received,
+			complete = EXCLUDED.complete,
+			sent_at = EXCLUDED.sent_at,
+			change_id = EXCLUDED.change_id,
+			lineage = EXCLUDED.lineage,
+			updated_at = now()`)
+	if err != nil {
+		return fmt.Errorf("merging series: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `CREATE TEMP TABLE series_patches_staging ON COMMIT DROP AS
+		SELECT ''::text AS root_message_id, doc_id, patch_index FROM series_patches WITH NO DATA`)
+	if err != nil {
+		return fmt.Errorf("creating patch staging table: %w", err)
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"series_patches_staging"},
+		[]string{"root_message_id", "doc_id", "patch_index"},
+		pgx.CopyFromSlice(len(patches), func(i int) ([]any, error) {
+			p := patches[i]
+			return []any{p.RootMessageID, p.DocID, p.PatchIndex}, nil
+		}))
+	if err != nil {
+		return fmt.Errorf("copying series patches: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM series_patches`); err != nil {
+		return fmt.Errorf("deleting series patches: %w", err)
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO series_patches (series_id, doc_id, patch_index)
+		SELECT series.id, s.doc_id, s.patch_index
+		FROM series_patches_staging s
+		JOIN series ON series.root_message_id = s.root_message_id`)
+	if err != nil {
+		return fmt.Errorf("inserting series patches: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ThreadParams is one row of threads as written by ReplaceThreads.
+type ThreadParams struct {
+	RootMessageID string
+	Subject       string
+	MessageCount  int32
+	LastActivity  pgtype.Timestamptz
+}
+
+// ThreadMemberParams is one row of thread_members. The thread is identified
+// by its root message ID because thread IDs are only known after the merge.
+type ThreadMemberParams struct {
+	RootMessageID   string
+	MessageID       string
+	DocID           pgtype.Int8
+	ParentMessageID string
+	Depth           int32
+	Position        int32
+}
+
+// ReplaceThreads replaces the contents of threads and thread_members in a
+// single transaction. Threads keep their ID as long as their root message
+// stays the same, so links to /api/thread/{id} survive a rebuild; threads
+// whose root is gone are deleted together with their members.
+func ReplaceThreads(ctx context.Context, conn Beginner, threads []ThreadParams, members []ThreadMemberParams) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `CREATE TEMP TABLE threads_staging ON COMMIT DROP AS
+		SELECT root_message_id, subject, message_count, last_activity FROM threads WITH NO DATA`)
+	if err != nil {
+		return fmt.Errorf("creating thread staging table: %w", err)
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"threads_staging"},
+		[]string{"root_message_id", "subject", "message_count", "last_activity"},
+		pgx.CopyFromSlice(len(threads), func(i int) ([]any, error) {
+			t := threads[i]
+			return []any{t.RootMessageID, t.Subject, t.MessageCount, t.LastActivity}, nil
+		}))
+	if err != nil {
+		return fmt.Errorf("copying threads: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM threads
+		WHERE root_message_id NOT IN (SELECT root_message_id FROM threads_staging)`)
+	if err != nil {
+		return fmt.Errorf("deleting threads: %w", err)
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO threads (root_message_id, subject, message_count, last_activity)
+		SELECT root_message_id, subject, message_count, last_activity FROM threads_staging
+		ON CONFLICT (root_message_id)
+		DO UPDATE SET
+			subject = EXCLUDED.subject,
+			message_count = EXCLUDED.message_count,
+			last_activity = EXCLUDED.last_activity,
+			updated_at = now()`)
+	if err != nil {
+		return fmt.Errorf("merging threads: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `CREATE TEMP TABLE thread_members_staging ON COMMIT DROP AS
+		SELECT ''::text AS root_message_id, message_id, doc_id, parent_message_id, depth, position
+		FROM thread_members WITH NO DATA`)
+	if err != nil {
+		return fmt.Errorf("creating member staging table: %w", err)
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"thread_members_staging"},
+		[]string{"root_message_id", "message_id", "doc_id", "parent_message_id", "depth", "position"},
+		pgx.CopyFromSlice(len(members), func(i int) ([]any, error) {
+			m := members[i]
+			return []any{m.RootMessageID, m.MessageID, m.DocID, m.ParentMessageID, m.Depth, m.Position}, nil
+		}))
+	if err != nil {
+		return fmt.Errorf("copying thread members: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM thread_members`); err != nil {
+		return fmt.Errorf("deleting thread members: %w", err)
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO thread_members (thread_id, message_id, doc_id, parent_message_id, depth, position)
+		SELECT threads.id, s.message_id, s.doc_id, s.parent_message_id, s.depth, s.position
+		FROM thread_members_staging s
+		JOIN threads ON threads.root_message_id = s.root_message_id`)
+	if err != nil {
+		return fmt.Errorf("inserting thread members: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
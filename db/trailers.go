@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TrailerParams is one row of trailers as written by ReplaceTrailers,
+// identified by the message it was found in.
+type TrailerParams struct {
+	MessageID string
+	Position  int32
+	Key       string
+	Name      string
+	Email     string
+	Value     string
+}
+
+// ReplaceTrailers replaces the trailers of the messages in messageIDs with
+// trailers in a single transaction. Messages must already be in docs, and
+// each message's trailers must come from a single copy of it.
+// Trailers of patches are attributed to the patch itself; those of replies
+// are attributed by AttributeTrailers once threads are known.
+func ReplaceTrailers(ctx context.Context, conn Beginner, messageIDs []string, trailers []TrailerParams) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM trailers
+		USING docs
+		WHERE docs.id = trailers.doc_id AND docs.message_id = ANY($1)`, messageIDs)
+	if err != nil {
+		return fmt.Errorf("deleting trailers: %w", err)
+	}
+	if len(trailers) == 0 {
+		return tx.Commit(ctx)
+	}
+
+	_, err = tx.Exec(ctx, `CREATE TEMP TABLE trailers_staging ON COMMIT DROP AS
+		SELECT ''::text AS message_id, position, key, name, email, value FROM trailers WITH NO DATA`)
+	if err != nil {
+		return fmt.Errorf("creating trailer staging table: %w", err)
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"trailers_staging"},
+		[]string{"message_id", "position", "key", "name", "email", "value"},
+		pgx.CopyFromSlice(len(trailers), func(i int) ([]any, error) {
+			t := trailers[i]
+			return []any{t.MessageID, t.Position, t.Key, t.Name, t.Email, t.Value}, nil
+		}))
+	if err != nil {
+		return fmt.Errorf("copying trailers: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO trailers (doc_id, position, key, name, email, value, patch_doc_id)
+		SELECT docs.id, s.position, s.key, s.name, s.email, s.value,
+			CASE WHEN docs.is_patch THEN docs.id END
+		FROM trailers_staging s
+		JOIN docs ON docs.message_id = s.message_id
+		ON CONFLICT (doc_id, position) DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("inserting trailers: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// attributeTrailersSQL walks up the thread from every reply carrying
+// trailers until it reaches a patch. The walk stops at the first patch, so a
+// review of a cover letter is attributed to the cover letter rather than to
+// the patches below it.
+const attributeTrailersSQL = `WITH RECURSIVE ancestors AS (
+	SELECT m.doc_id AS reply_id, m.parent_message_id AS message_id, 1 AS depth
+	FROM thread_members m
+	WHERE m.parent_message_id <> ''
+		AND m.doc_id IN (SELECT doc_id FROM trailers)
+	UNION ALL
+	SELECT a.reply_id, m.parent_message_id, a.depth + 1
+	FROM ancestors a
+	JOIN thread_members m ON m.message_id = a.message_id
+	LEFT JOIN docs ON docs.id = m.doc_id
+	WHERE m.parent_message_id <> '' AND docs.is_patch IS NOT TRUE
+),
+reviewed AS (
+	SELECT DISTINCT ON (a.reply_id) a.reply_id, docs.id AS patch_doc_id
+	FROM ancestors a
+	JOIN docs ON docs.message_id = a.message_id
+	WHERE docs.is_patch
+	ORDER BY a.reply_id, a.depth
+)
+UPDATE trailers
+SET patch_doc_id = reviewed.patch_doc_id
+FROM docs
+LEFT JOIN reviewed ON reviewed.reply_id = docs.id
+WHERE docs.id = trailers.doc_id
+	AND NOT docs.is_patch
+	AND trailers.patch_doc_id IS DISTINCT FROM reviewed.patch_doc_id`
+
+// AttributeTrailers points the trailers of replies at the patch they were
+// given on, the nearest patch above the reply in its thread. Threads must
+// be up to date. It returns the number of trailers whose patch changed.
+func AttributeTrailers(ctx context.Context, conn DBTX) (int64, error) {
+	tag, err := conn.Exec(ctx, attributeTrailersSQL)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -24,7 +24,9 @@ require (
 
 require (
 	github.com/jackc/pgx/v5 v5.7.2
+	github.com/klauspost/compress v1.17.11
 	github.com/meilisearch/meilisearch-go v0.30.0
+	github.com/ulikunitz/xz v0.5.12
 	golang.org/x/crypto v0.32.0
-	golang.org/x/text v0.21.0 // indirect
+	golang.org/x/text v0.21.0
 )
@@ -0,0 +1,150 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// hashedPrefix is how much of a file HashFile reads.
+const hashedPrefix = 1 << 20
+
+// HashFile identifies the file at path by the SHA-256 of its first MiB, its
+// size and its modification time, so a large archive is not read in full
+// before every import. Runs are keyed by it so a renamed or moved archive
+// still resumes.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, hashedPrefix); err != nil && err != io.EOF {
+		return "", err
+	}
+	fmt.Fprintf(h, "\x00%d\x00%d", info.Size(), info.ModTime().UnixNano())
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Run tracks the progress of importing one source in the ingest_runs table.
+// Progress is only checkpointed past batches that were written, and never
+// past a failed batch, so resuming can at worst re-import messages, which
+// the upsert makes harmless.
+type Run struct {
+	queries        *db.Queries
+	row            db.IngestRun
+	every          int64
+	uncheckpointed int64
+	failed         bool
+}
+
+// StartRun begins a run for source. With resume set, the most recent
+// unfinished run for the same hash is continued instead of starting from the
+// beginning.
+// A checkpoint is written every `every` messages.
+func StartRun(ctx context.Context, queries *db.Queries, source, hash string, resume bool, every int64) (*Run, error) {
+	if every <= 0 {
+		every = 10000
+	}
+	run := &Run{queries: queries, every: every}
+
+	if resume {
+		row, err := queries.GetLatestIngestRun(ctx, hash)
+		if err == nil {
+			run.row = row
+			return run, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	row, err := queries.CreateIngestRun(ctx, db.CreateIngestRunParams{
+		Source:     source,
+		SourceHash: hash,
+	})
+	if err != nil {
+		return nil, err
+	}
+	run.row = row
+	return run, nil
+}
+
+// ID returns the ingest_runs row ID.
+func (r *Run) ID() int64 {
+	return r.row.ID
+}
+
+// Offset returns the byte offset to continue reading from.
+func (r *Run) Offset() int64 {
+	return r.row.ByteOffset
+}
+
+// Messages returns the number of messages recorded so far.
+func (r *Run) Messages() int64 {
+	return r.row.MessageCount
+}
+
+// Resumed reports whether the run continues from an earlier checkpoint.
+func (r *Run) Resumed() bool {
+	return r.row.ByteOffset > 0
+}
+
+// Record notes the outcome of a batch and writes a checkpoint once enough
+// messages have been written since the last one.
+func (r *Run) Record(ctx context.Context, result BatchResult) error {
+	if result.Err != nil {
+		r.failed = true
+	}
+	if r.failed {
+		return nil
+	}
+
+	r.row.ByteOffset = result.Offset
+	r.row.MessageCount += int64(result.Messages)
+	r.uncheckpointed += int64(result.Messages)
+	if r.uncheckpointed < r.every {
+		return nil
+	}
+	return r.checkpoint(ctx)
+}
+
+// Fail marks the run as incomplete, for example after a read error.
+func (r *Run) Fail() {
+	r.failed = true
+}
+
+// Finish writes the final checkpoint. Runs with a failed batch are left
+// unfinished so that --resume picks them up again.
+func (r *Run) Finish(ctx context.Context) error {
+	if r.failed {
+		return r.checkpoint(ctx)
+	}
+	return r.queries.FinishIngestRun(ctx, db.FinishIngestRunParams{
+		ID:           r.row.ID,
+		ByteOffset:   r.row.ByteOffset,
+		MessageCount: r.row.MessageCount,
+	})
+}
+
+func (r *Run) checkpoint(ctx context.Context) error {
+	r.uncheckpointed = 0
+	return r.queries.UpdateIngestRunCheckpoint(ctx, db.UpdateIngestRunCheckpointParams{
+		ID:           r.row.ID,
+		ByteOffset:   r.row.ByteOffset,
+		MessageCount: r.row.MessageCount,
+	})
+}
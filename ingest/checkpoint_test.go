@@ -0,0 +1,144 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestRunCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, "postgresql://postgres@localhost:5432/patchy")
+	if err != nil {
+		t.Fatalf("Unable to connect to database: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Exec(context.Background(), "DELETE FROM ingest_runs WHERE source = 'checkpoint-test'")
+		conn.Close(context.Background())
+	})
+	queries := db.New(conn)
+	hash := fmt.Sprintf("checkpoint-test-%d", time.Now().UnixNano())
+
+	start := func(resume bool) *Run {
+		t.Helper()
+		run, err := StartRun(ctx, queries, "checkpoint-test", hash, resume, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return run
+	}
+	record := func(run *Run, result BatchResult) {
+		t.Helper()
+		if err := run.Record(ctx, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(run *Run, offset, messages int64) {
+		t.Helper()
+		if run.Offset() != offset || run.Messages() != messages {
+			t.Errorf("run continues from offset %d after %d messages, want %d after %d",
+				run.Offset(), run.Messages(), offset, messages)
+		}
+	}
+
+	// Nothing to resume yet.
+	run := start(true)
+	if run.Resumed() {
+		t.Error("first run is resumed")
+	}
+	first := run.ID()
+	record(run, BatchResult{Messages: 60, Offset: 1000})
+	record(run, BatchResult{Messages: 60, Offset: 2000})
+	// Not checkpointed, as fewer than every messages came after the last.
+	record(run, BatchResult{Messages: 30, Offset: 2500})
+
+	// The process dies here; resuming continues from the checkpoint.
+	run = start(true)
+	if run.ID() != first || !run.Resumed() {
+		t.Fatalf("resumed run %d (resumed %v), want %d", run.ID(), run.Resumed(), first)
+	}
+	check(run, 2000, 120)
+
+	// A failed batch keeps later ones from moving the checkpoint, and
+	// Finish leaves the run unfinished.
+	record(run, BatchResult{Messages: 50, Offset: 3000})
+	record(run, BatchResult{Messages: 50, Offset: 4000, Err: errors.New("write failed")})
+	record(run, BatchResult{Messages: 50, Offset: 5000})
+	if err := run.Finish(ctx); err != nil {
+		t.Fatal(err)
+	}
+	run = start(true)
+	check(run, 3000, 170)
+
+	record(run, BatchResult{Messages: 40, Offset: 4000})
+	if err := run.Finish(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var offset, messages int64
+	var finished bool
+	err = conn.QueryRow(ctx, "SELECT byte_offset, message_count, finished_at IS NOT NULL FROM ingest_runs WHERE id = $1",
+		first).Scan(&offset, &messages, &finished)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !finished || offset != 4000 || messages != 210 {
+		t.Errorf("finished run = offset %d, %d messages, finished %v, want 4000, 210, finished",
+			offset, messages, finished)
+	}
+
+	// A finished run is not resumed.
+	run = start(true)
+	if run.ID() == first || run.Resumed() {
+		t.Errorf("resuming continues finished run %d", run.ID())
+	}
+	check(run, 0, 0)
+
+	run = start(false)
+	if run.ID() == first || run.Resumed() {
+		t.Errorf("run started without resume continues run %d", run.ID())
+	}
+	check(run, 0, 0)
+}
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.mbox")
+	content := bytes.Repeat([]byte("From a@b Mon Jan  1 00:00:00 2024\n\nbody\n"), hashedPrefix/10)
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	hash := func(content []byte, mtime time.Time) string {
+		t.Helper()
+		writeFile(t, path, string(content))
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		h, err := HashFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	base := hash(content, mtime)
+	if got := hash(content, mtime); got != base {
+		t.Error("hash of the same file changed")
+	}
+	// Past the prefix only the size shows.
+	changed := bytes.Clone(content)
+	changed[len(changed)-2] = 'X'
+	if got := hash(changed, mtime); got != base {
+		t.Error("hash covers more than the prefix")
+	}
+	if got := hash(append(bytes.Clone(content), 'X'), mtime); got == base {
+		t.Error("hash ignores the size")
+	}
+	if got := hash(content, mtime.Add(time.Second)); got == base {
+		t.Error("hash ignores the modification time")
+	}
+}
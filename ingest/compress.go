@@ -0,0 +1,104 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression is the compression format of an archive.
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Xz
+	Zstd
+	Bzip2
+)
+
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Xz:
+		return "xz"
+	case Zstd:
+		return "zstd"
+	case Bzip2:
+		return "bzip2"
+	}
+	return "uncompressed"
+}
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+)
+
+// DetectCompression looks at the first bytes of an archive. File extensions
+// are not trusted, mirrors are full of .txt files that are really gzip.
+func DetectCompression(header []byte) Compression {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(header, xzMagic):
+		return Xz
+	case bytes.HasPrefix(header, zstdMagic):
+		return Zstd
+	case len(header) >= 4 && bytes.HasPrefix(header, bzip2Magic) && header[3] >= '1' && header[3] <= '9':
+		return Bzip2
+	}
+	return Uncompressed
+}
+
+// decompress wraps r in a streaming decoder for whatever compression it
+// uses. Uncompressed input is returned as is. The returned closer releases
+// the decoder but not r.
+func decompress(r io.Reader) (io.Reader, io.Closer, Compression, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	// Peek returns what it has on a short read, which is all we need to
+	// tell that a tiny file is not compressed.
+	header, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, nil, Uncompressed, err
+	}
+
+	compression := DetectCompression(header)
+	switch compression {
+	case Gzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, compression, err
+		}
+		return zr, zr, compression, nil
+	case Xz:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, nil, compression, err
+		}
+		return xr, noClose, compression, nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nil, compression, err
+		}
+		return zr, closerFunc(func() error { zr.Close(); return nil }), compression, nil
+	case Bzip2:
+		return bzip2.NewReader(br), noClose, compression, nil
+	}
+	return br, noClose, compression, nil
+}
+
+type closerFunc func() error
+
+var noClose = closerFunc(func() error { return nil })
+
+func (f closerFunc) Close() error { return f() }
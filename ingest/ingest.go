@@ -0,0 +1,420 @@
+// Package ingest turns raw email messages into docs rows. Messages are parsed
+// by a pool of workers and written to Postgres in batches, each batch while
+// the next one is parsed.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/alexmorten/patchy/internal/diff"
+	"github.com/alexmorten/patchy/internal/email"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ErrNoMessageID is reported for messages that cannot be stored because they
+// have no Message-ID header.
+var ErrNoMessageID = errors.New("no Message-ID header")
+
+// RawMessage is an unparsed message and where it was read from. Offset and
+// Length describe its position in the source, in whatever unit the source
+// uses to resume; Location is a human readable form for error reports.
+type RawMessage struct {
+	Data     []byte
+	Offset   int64
+	Length   int64
+	Location string
+}
+
+// Options configures an Ingester.
+type Options struct {
+	// BatchSize is the number of messages written per transaction.
+	BatchSize int
+	// Workers is the number of goroutines parsing messages.
+	Workers int
+	// OnBatch is called after every batch has been written or has failed.
+	OnBatch func(BatchResult)
+}
+
+// MessageError is a message that was skipped during parsing.
+type MessageError struct {
+	Offset   int64
+	Location string
+	Err      error
+}
+
+func (e MessageError) Error() string {
+	if e.Location != "" {
+		return fmt.Sprintf("%s: %v", e.Location, e.Err)
+	}
+	return fmt.Sprintf("offset %d: %v", e.Offset, e.Err)
+}
+
+// BatchResult describes the outcome of writing one batch. Offset is the
+// input position just past the last message of the batch.
+type BatchResult struct {
+	Number   int
+	Messages int
+	Written  int64
+	Skipped  []MessageError
+	Err      error
+	Duration time.Duration
+	Offset   int64
+}
+
+// String summarizes the batch on one line, followed by a line for every
+// skipped message and the error if it failed.
+func (r BatchResult) String() string {
+	var b strings.Builder
+	rate := float64(r.Messages) / r.Duration.Seconds()
+	fmt.Fprintf(&b, "batch %d: %d messages, %d written, %d skipped in %v (%.0f msg/s)",
+		r.Number, r.Messages, r.Written, len(r.Skipped), r.Duration.Round(time.Millisecond), rate)
+	for _, skipped := range r.Skipped {
+		fmt.Fprintf(&b, "\n  skipped %v", skipped)
+	}
+	if r.Err != nil {
+		fmt.Fprintf(&b, "\n  batch failed: %v", r.Err)
+	}
+	return b.String()
+}
+
+// Stats are running totals across all batches.
+type Stats struct {
+	Messages int64
+	Written  int64
+	Skipped  int64
+	Failed   int64
+	Batches  int
+	Elapsed  time.Duration
+}
+
+// Throughput returns messages processed per second.
+func (s Stats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Messages) / s.Elapsed.Seconds()
+}
+
+// Ingester writes raw messages in batches. It is not safe for concurrent
+// use; parallelism happens inside Ingest.
+type Ingester struct {
+	conn    db.Beginner
+	opts    Options
+	stats   Stats
+	started time.Time
+}
+
+// New returns an Ingester writing through conn.
+func New(conn db.Beginner, opts Options) *Ingester {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	return &Ingester{conn: conn, opts: opts}
+}
+
+// batch is the messages written in one transaction. The worker pool parses
+// message i into docs[i], trailers[i] and errs[i].
+type batch struct {
+	msgs     []RawMessage
+	docs     []*db.CreateDocumentParams
+	trailers [][]db.TrailerParams
+	errs     []error
+	parsed   sync.WaitGroup
+}
+
+type parseJob struct {
+	batch *batch
+	index int
+	data  []byte
+}
+
+func (in *Ingester) newBatch() *batch {
+	return &batch{
+		docs:     make([]*db.CreateDocumentParams, in.opts.BatchSize),
+		trailers: make([][]db.TrailerParams, in.opts.BatchSize),
+		errs:     make([]error, in.opts.BatchSize),
+	}
+}
+
+// Ingest reads src to the end and writes everything it yields. A pool of
+// workers parses the messages while a writer goroutine writes the batches
+// they fill, so the next batch is parsed while one is being written. OnBatch
+// is called from the writer goroutine. Ingest stops at the first read error
+// or failed batch; the batches read before a read error are still written.
+func (in *Ingester) Ingest(ctx context.Context, src Source) error {
+	if in.started.IsZero() {
+		in.started = time.Now()
+	}
+
+	jobs, stopParsers := in.startParsers()
+	defer stopParsers()
+
+	// One batch waits for the writer while the next one is read and parsed.
+	batches := make(chan *batch, 1)
+	failed := make(chan struct{})
+	written := make(chan error, 1)
+	go func() {
+		written <- in.writeBatches(ctx, batches, failed)
+	}()
+
+	readErr := in.read(src, jobs, batches, failed)
+	close(batches)
+	if err := <-written; err != nil {
+		return err
+	}
+	return readErr
+}
+
+// startParsers starts the worker pool. It parses the messages sent to jobs
+// until stop is called.
+func (in *Ingester) startParsers() (jobs chan<- parseJob, stop func()) {
+	ch := make(chan parseJob)
+	var workers sync.WaitGroup
+	for w := 0; w < in.opts.Workers; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range ch {
+				b := job.batch
+				b.docs[job.index], b.trailers[job.index], b.errs[job.index] = parseDocument(job.data)
+				b.parsed.Done()
+			}
+		}()
+	}
+	return ch, func() {
+		close(ch)
+		workers.Wait()
+	}
+}
+
+// read hands every message of src to the worker pool and every full batch
+// to the writer, until src ends or failed is closed.
+func (in *Ingester) read(src Source, jobs chan<- parseJob, batches chan<- *batch, failed <-chan struct{}) error {
+	b := in.newBatch()
+	for {
+		msg, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		b.msgs = append(b.msgs, *msg)
+		b.parsed.Add(1)
+		select {
+		case jobs <- parseJob{batch: b, index: len(b.msgs) - 1, data: msg.Data}:
+		case <-failed:
+			return nil
+		}
+		if len(b.msgs) < in.opts.BatchSize {
+			continue
+		}
+		select {
+		case batches <- b:
+		case <-failed:
+			return nil
+		}
+		b = in.newBatch()
+	}
+	if len(b.msgs) > 0 {
+		select {
+		case batches <- b:
+		case <-failed:
+		}
+	}
+	return nil
+}
+
+// writeBatches writes the batches in the order they are read and closes
+// failed when one of them fails.
+func (in *Ingester) writeBatches(ctx context.Context, batches <-chan *batch, failed chan<- struct{}) error {
+	for b := range batches {
+		if err := in.writeBatch(ctx, b); err != nil {
+			close(failed)
+			return err
+		}
+	}
+	return nil
+}
+
+// write upserts docs and replaces their trailers in one transaction, so a
+// failed batch leaves no documents stored without their trailers.
+func (in *Ingester) write(ctx context.Context, docs []db.CreateDocumentParams, trailers []db.TrailerParams) (int64, error) {
+	tx, err := in.conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	written, err := db.BulkUpsertDocuments(ctx, tx, docs)
+	if err != nil {
+		return 0, err
+	}
+	messageIDs := make([]string, len(docs))
+	for i, doc := range docs {
+		messageIDs[i] = doc.MessageID
+	}
+	if err := db.ReplaceTrailers(ctx, tx, messageIDs, trailers); err != nil {
+		return 0, err
+	}
+	return written, tx.Commit(ctx)
+}
+
+// writeBatch waits for b to be parsed and writes it.
+func (in *Ingester) writeBatch(ctx context.Context, b *batch) error {
+	start := time.Now()
+	in.stats.Batches++
+	last := b.msgs[len(b.msgs)-1]
+	result := BatchResult{
+		Number:   in.stats.Batches,
+		Messages: len(b.msgs),
+		Offset:   last.Offset + last.Length,
+	}
+
+	b.parsed.Wait()
+	docs, trailers, skipped := b.results()
+	result.Skipped = skipped
+	result.Written, result.Err = in.write(ctx, docs, trailers)
+	result.Duration = time.Since(start)
+
+	in.stats.Messages += int64(len(b.msgs))
+	in.stats.Skipped += int64(len(skipped))
+	if result.Err != nil {
+		in.stats.Failed += int64(len(docs))
+	} else {
+		in.stats.Written += result.Written
+	}
+	in.stats.Elapsed = time.Since(in.started)
+
+	if in.opts.OnBatch != nil {
+		in.opts.OnBatch(result)
+	}
+	return result.Err
+}
+
+// Stats returns the totals so far.
+func (in *Ingester) Stats() Stats {
+	return in.stats
+}
+
+// results returns the parsed documents of b in the order of its messages,
+// their trailers and the messages that could not be parsed.
+func (b *batch) results() ([]db.CreateDocumentParams, []db.TrailerParams, []MessageError) {
+	docs, trailers, errs := b.docs[:len(b.msgs)], b.trailers, b.errs
+
+	// A message read twice keeps the trailers of its last copy, just like
+	// BulkUpsertDocuments keeps the last row.
+	last := make(map[string]int, len(docs))
+	for i, doc := range docs {
+		if errs[i] == nil {
+			last[doc.MessageID] = i
+		}
+	}
+
+	out := make([]db.CreateDocumentParams, 0, len(docs))
+	var outTrailers []db.TrailerParams
+	var skipped []MessageError
+	for i, doc := range docs {
+		if errs[i] != nil {
+			skipped = append(skipped, MessageError{
+				Offset:   b.msgs[i].Offset,
+				Location: b.msgs[i].Location,
+				Err:      errs[i],
+			})
+			continue
+		}
+		out = append(out, *doc)
+		if last[doc.MessageID] == i {
+			outTrailers = append(outTrailers, trailers[i]...)
+		}
+	}
+	return out, outTrailers, skipped
+}
+
+func parseDocument(data []byte) (*db.CreateDocumentParams, []db.TrailerParams, error) {
+	msg, err := email.Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if msg.MessageID == "" {
+		return nil, nil, ErrNoMessageID
+	}
+	return DocumentFromMessage(msg), TrailersFromMessage(msg), nil
+}
+
+// DocumentFromMessage maps a parsed message onto a docs row.
+func DocumentFromMessage(msg *email.Message) *db.CreateDocumentParams {
+	doc := &db.CreateDocumentParams{
+		Text:      msg.Text(),
+		Url:       msg.MessageID,
+		MessageID: msg.MessageID,
+		Raw:       msg.Raw,
+		Subject:   msg.Subject,
+		FromName:  msg.From.Name,
+		FromEmail: msg.From.Email,
+		InReplyTo: msg.InReplyTo,
+		Refs:      msg.References,
+		ToAddrs:   addressStrings(msg.To),
+		CcAddrs:   addressStrings(msg.Cc),
+	}
+	if !msg.Date.IsZero() {
+		doc.SentAt = pgtype.Timestamptz{Time: msg.Date, Valid: true}
+	}
+
+	// Replies keep their clean subject for threading and series matching,
+	// but only the patch mails themselves count as patches.
+	subject := email.ParseSubject(msg.Subject)
+	doc.CleanSubject = subject.Subject
+	if subject.Patch && !subject.Reply {
+		doc.IsPatch = true
+		doc.PatchPrefixes = subject.Prefixes
+		doc.PatchTree = subject.Tree
+		doc.PatchVersion = int32(subject.Version)
+		doc.PatchIndex = int32(subject.Index)
+		doc.PatchTotal = int32(subject.Total)
+	}
+	doc.ChangeID = msg.ChangeID()
+
+	// Diffs are also posted inline in replies, so every message is checked.
+	d := diff.Parse(msg.Body)
+	doc.TouchedFiles = d.Paths()
+	doc.LinesAdded = int32(d.Added())
+	doc.LinesRemoved = int32(d.Removed())
+	return doc
+}
+
+func addressStrings(addrs []email.Address) []string {
+	var out []string
+	for _, a := range addrs {
+		out = append(out, a.String())
+	}
+	return out
+}
+
+// TrailersFromMessage maps the trailers of a parsed message onto trailers
+// rows.
+func TrailersFromMessage(msg *email.Message) []db.TrailerParams {
+	var rows []db.TrailerParams
+	for i, t := range msg.Trailers() {
+		rows = append(rows, db.TrailerParams{
+			MessageID: msg.MessageID,
+			Position:  int32(i),
+			Key:       t.Key,
+			Name:      t.Name,
+			Email:     t.Email,
+			Value:     t.Value,
+		})
+	}
+	return rows
+}
@@ -0,0 +1,205 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// parse runs msgs through the worker pool as one batch.
+func parse(in *Ingester, msgs []RawMessage) ([]db.CreateDocumentParams, []db.TrailerParams, []MessageError) {
+	jobs, stop := in.startParsers()
+	defer stop()
+	b := in.newBatch()
+	for i, msg := range msgs {
+		b.msgs = append(b.msgs, msg)
+		b.parsed.Add(1)
+		jobs <- parseJob{batch: b, index: i, data: msg.Data}
+	}
+	b.parsed.Wait()
+	return b.results()
+}
+
+func TestParseKeepsBatchOrder(t *testing.T) {
+	in := New(nil, Options{Workers: 4})
+
+	var batch []RawMessage
+	for i := 0; i < 50; i++ {
+		data := fmt.Sprintf("Message-ID: <%d@example.com>\nSubject: %d\n\nbody\n", i, i)
+		if i%10 == 5 {
+			data = "Subject: no id\n\nbody\n"
+		}
+		batch = append(batch, RawMessage{Data: []byte(data), Offset: int64(i * 100)})
+	}
+
+	docs, _, skipped := parse(in, batch)
+	if len(docs) != 45 {
+		t.Fatalf("got %d docs, want 45", len(docs))
+	}
+	if len(skipped) != 5 {
+		t.Fatalf("got %d skipped, want 5", len(skipped))
+	}
+	if skipped[0].Offset != 500 || !errors.Is(skipped[0].Err, ErrNoMessageID) {
+		t.Errorf("unexpected first skip: %v", skipped[0])
+	}
+
+	want := 0
+	for _, doc := range docs {
+		if want%10 == 5 {
+			want++
+		}
+		if doc.MessageID != fmt.Sprintf("%d@example.com", want) {
+			t.Fatalf("docs out of order: got %s, want %d@example.com", doc.MessageID, want)
+		}
+		want++
+	}
+}
+
+func TestParseTrailersOfLastCopy(t *testing.T) {
+	in := New(nil, Options{Workers: 2})
+	batch := []RawMessage{
+		{Data: []byte("Message-ID: <a@x>\n\nReviewed-by: Old <old@x>\n")},
+		{Data: []byte("Message-ID: <b@x>\n\nAcked-by: B <b@x>\nTested-by: B <b@x>\n")},
+		{Data: []byte("Message-ID: <a@x>\n\nNo trailers any more.\n")},
+	}
+
+	docs, trailers, _ := parse(in, batch)
+	if len(docs) != 3 {
+		t.Fatalf("got %d docs, want 3", len(docs))
+	}
+	var got []string
+	for _, tr := range trailers {
+		got = append(got, fmt.Sprintf("%s:%d:%s:%s", tr.MessageID, tr.Position, tr.Key, tr.Email))
+	}
+	if want := "[b@x:0:Acked-by:b@x b@x:1:Tested-by:b@x]"; fmt.Sprint(got) != want {
+		t.Errorf("trailers = %v, want %s", got, want)
+	}
+}
+
+func TestDocumentFromMessagePatchColumns(t *testing.T) {
+	tests := []struct {
+		subject     string
+		wantPatch   bool
+		wantVersion int32
+		wantIndex   int32
+		wantTotal   int32
+		wantTree    string
+		wantClean   string
+	}{
+		{"[PATCH net-next v3 2/7] tcp: fix foo", true, 3, 2, 7, "net-next", "tcp: fix foo"},
+		{"Re: [PATCH net-next v3 2/7] tcp: fix foo", false, 0, 0, 0, "", "tcp: fix foo"},
+		{"Weekly status", false, 0, 0, 0, "", "Weekly status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			doc, _, err := parseDocument([]byte("Message-ID: <p@x>\nSubject: " + tt.subject + "\n\nbody\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.IsPatch != tt.wantPatch || doc.PatchVersion != tt.wantVersion ||
+				doc.PatchIndex != tt.wantIndex || doc.PatchTotal != tt.wantTotal ||
+				doc.PatchTree != tt.wantTree || doc.CleanSubject != tt.wantClean {
+				t.Errorf("got patch=%v v%d %d/%d tree=%q clean=%q", doc.IsPatch, doc.PatchVersion,
+					doc.PatchIndex, doc.PatchTotal, doc.PatchTree, doc.CleanSubject)
+			}
+		})
+	}
+}
+
+func TestDocumentFromMessageLinkingColumns(t *testing.T) {
+	data := "Message-ID: <p@x>\nSubject: [PATCH v2 1/2] mm: fix\n\n" +
+		"Fix it.\n\nChange-Id: I0123\n---\n" +
+		"diff --git a/mm/slab.c b/mm/slab.c\n--- a/mm/slab.c\n+++ b/mm/slab.c\n" +
+		"@@ -1,2 +1,3 @@\n a\n-b\n+c\n+d\n" +
+		"diff --git a/mm/old.c b/mm/new.c\nsimilarity index 90%\n" +
+		"diff --git a/mm/slab.c b/mm/slab.c\n"
+	doc, _, err := parseDocument([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.ChangeID != "I0123" {
+		t.Errorf("ChangeID = %q", doc.ChangeID)
+	}
+	if got := fmt.Sprint(doc.TouchedFiles); got != "[mm/slab.c mm/new.c]" {
+		t.Errorf("TouchedFiles = %s", got)
+	}
+	if doc.LinesAdded != 2 || doc.LinesRemoved != 1 {
+		t.Errorf("lines = +%d -%d", doc.LinesAdded, doc.LinesRemoved)
+	}
+}
+
+func TestBatchResultString(t *testing.T) {
+	result := BatchResult{
+		Number:   3,
+		Messages: 200,
+		Written:  199,
+		Skipped:  []MessageError{{Offset: 512, Err: ErrNoMessageID}},
+		Err:      errors.New("connection reset"),
+		Duration: 2 * time.Second,
+	}
+	want := "batch 3: 200 messages, 199 written, 1 skipped in 2s (100 msg/s)\n" +
+		"  skipped offset 512: " + ErrNoMessageID.Error() + "\n" +
+		"  batch failed: connection reset"
+	if got := result.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+// sliceSource yields messages from a slice.
+type sliceSource []RawMessage
+
+func (s *sliceSource) Next() (*RawMessage, error) {
+	if len(*s) == 0 {
+		return nil, io.EOF
+	}
+	msg := (*s)[0]
+	*s = (*s)[1:]
+	return &msg, nil
+}
+
+func (s *sliceSource) Close() error {
+	return nil
+}
+
+// failingConn fails every transaction.
+type failingConn struct{}
+
+func (failingConn) Begin(ctx context.Context) (pgx.Tx, error) {
+	return nil, errors.New("connection reset")
+}
+
+func TestIngestStopsAtFailedBatch(t *testing.T) {
+	var src sliceSource
+	for i := 0; i < 100; i++ {
+		data := fmt.Sprintf("Message-ID: <%d@example.com>\n\nbody\n", i)
+		if i == 3 {
+			data = "Subject: no id\n\nbody\n"
+		}
+		src = append(src, RawMessage{Data: []byte(data), Offset: int64(i * 10), Length: 10})
+	}
+
+	var results []BatchResult
+	in := New(failingConn{}, Options{BatchSize: 10, Workers: 4, OnBatch: func(r BatchResult) {
+		results = append(results, r)
+	}})
+	if err := in.Ingest(context.Background(), &src); err == nil || err.Error() != "connection reset" {
+		t.Fatalf("Ingest() = %v, want the error of the first batch", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d batches, want to stop after the first", len(results))
+	}
+	r := results[0]
+	if r.Number != 1 || r.Messages != 10 || r.Offset != 100 || len(r.Skipped) != 1 || r.Skipped[0].Offset != 30 {
+		t.Errorf("result = %+v", r)
+	}
+	if stats := in.Stats(); stats.Batches != 1 || stats.Messages != 10 || stats.Skipped != 1 || stats.Failed != 9 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
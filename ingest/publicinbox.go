@@ -0,0 +1,243 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// Epoch is one git repository of a public-inbox v2 archive. Every commit in
+// an epoch adds a single message as the blob "m".
+type Epoch struct {
+	Number int
+	GitDir string
+}
+
+// PublicInboxEpochs lists the epochs of the inbox at path in order. path may
+// be an inbox directory containing git/N.git, or a single epoch repository.
+func PublicInboxEpochs(path string) ([]Epoch, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(path, "git", "*.git"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		if _, err := os.Stat(filepath.Join(path, "HEAD")); err != nil {
+			return nil, fmt.Errorf("%s is neither a public-inbox v2 directory nor a git repository", path)
+		}
+		return []Epoch{{Number: 0, GitDir: path}}, nil
+	}
+
+	var epochs []Epoch
+	for _, m := range matches {
+		n, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(m), ".git"))
+		if err != nil {
+			continue
+		}
+		epochs = append(epochs, Epoch{Number: n, GitDir: m})
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i].Number < epochs[j].Number })
+	return epochs, nil
+}
+
+// listCommits returns the commits of an epoch in the order they were made,
+// starting after since. If since is no longer part of the history, for
+// example after public-inbox purged a message, the whole epoch is listed.
+func listCommits(ctx context.Context, gitDir, since string) ([]string, error) {
+	revs := "HEAD"
+	if since != "" {
+		err := exec.CommandContext(ctx, "git", "--git-dir", gitDir, "merge-base", "--is-ancestor", since, "HEAD").Run()
+		if err == nil {
+			revs = since + "..HEAD"
+		}
+	}
+
+	out, err := exec.CommandContext(ctx, "git", "--git-dir", gitDir, "rev-list", "--reverse", "--first-parent", revs).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && bytes.Contains(exitErr.Stderr, []byte("unknown revision")) {
+			// An epoch without any commits yet.
+			return nil, nil
+		}
+		return nil, fmt.Errorf("git rev-list in %s: %w", gitDir, err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// blobReader reads blobs through a long-running `git cat-file --batch`.
+type blobReader struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func newBlobReader(ctx context.Context, gitDir string) (*blobReader, error) {
+	cmd := exec.CommandContext(ctx, "git", "--git-dir", gitDir, "cat-file", "--batch")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &blobReader{cmd: cmd, stdin: stdin, stdout: bufio.NewReaderSize(stdout, 64*1024)}, nil
+}
+
+// read returns the object named by rev, or false if it does not exist.
+func (b *blobReader) read(rev string) ([]byte, bool, error) {
+	if _, err := fmt.Fprintln(b.stdin, rev); err != nil {
+		return nil, false, err
+	}
+	header, err := b.stdout.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) == 2 && fields[1] == "missing" {
+		return nil, false, nil
+	}
+	if len(fields) != 3 {
+		return nil, false, fmt.Errorf("unexpected cat-file output %q", header)
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, false, fmt.Errorf("unexpected cat-file output %q", header)
+	}
+
+	data := make([]byte, size+1)
+	if _, err := io.ReadFull(b.stdout, data); err != nil {
+		return nil, false, err
+	}
+	return data[:size], true, nil
+}
+
+func (b *blobReader) Close() error {
+	b.stdin.Close()
+	return b.cmd.Wait()
+}
+
+// epochSource yields the message of every commit in an epoch. Offsets are
+// positions in commits, so the offset at the end of a batch identifies the
+// last commit it contains.
+type epochSource struct {
+	commits []string
+	next    int
+	blobs   *blobReader
+}
+
+// openEpoch returns a Source over the commits of an epoch made after since.
+func openEpoch(ctx context.Context, gitDir, since string) (*epochSource, error) {
+	commits, err := listCommits(ctx, gitDir, since)
+	if err != nil {
+		return nil, err
+	}
+	src := &epochSource{commits: commits}
+	if len(commits) == 0 {
+		return src, nil
+	}
+	src.blobs, err = newBlobReader(ctx, gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// Next skips commits without an "m" blob, such as deletions.
+func (s *epochSource) Next() (*RawMessage, error) {
+	for s.next < len(s.commits) {
+		i := s.next
+		s.next++
+
+		commit := s.commits[i]
+		data, ok, err := s.blobs.read(commit + ":m")
+		if err != nil {
+			return nil, fmt.Errorf("reading message of %s: %w", commit, err)
+		}
+		if ok {
+			return &RawMessage{Data: data, Offset: int64(i), Length: 1, Location: "commit " + commit}, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+func (s *epochSource) Close() error {
+	if s.blobs == nil {
+		return nil
+	}
+	return s.blobs.Close()
+}
+
+// IngestPublicInbox imports every epoch of the inbox at path. The last
+// processed commit of each epoch is stored in public_inbox_epochs, so later
+// runs only read new mail.
+func IngestPublicInbox(ctx context.Context, conn *pgx.Conn, path string, opts Options) error {
+	epochs, err := PublicInboxEpochs(path)
+	if err != nil {
+		return err
+	}
+	queries := db.New(conn)
+
+	for _, epoch := range epochs {
+		if err := ingestEpoch(ctx, conn, queries, epoch, opts); err != nil {
+			return fmt.Errorf("epoch %d: %w", epoch.Number, err)
+		}
+	}
+	return nil
+}
+
+func ingestEpoch(ctx context.Context, conn *pgx.Conn, queries *db.Queries, epoch Epoch, opts Options) error {
+	var since string
+	state, err := queries.GetPublicInboxEpoch(ctx, epoch.GitDir)
+	if err == nil {
+		since = state.LastCommit
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	src, err := openEpoch(ctx, epoch.GitDir, since)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var checkpointErr error
+	onBatch := opts.OnBatch
+	opts.OnBatch = func(result BatchResult) {
+		if onBatch != nil {
+			onBatch(result)
+		}
+		if result.Err != nil || checkpointErr != nil {
+			return
+		}
+		checkpointErr = queries.UpsertPublicInboxEpoch(ctx, db.UpsertPublicInboxEpochParams{
+			GitDir:       epoch.GitDir,
+			LastCommit:   src.commits[result.Offset-1],
+			MessageCount: int64(result.Messages),
+		})
+	}
+
+	if err := New(conn, opts).Ingest(ctx, src); err != nil {
+		return err
+	}
+	return checkpointErr
+}
@@ -0,0 +1,135 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=patchy", "GIT_AUTHOR_EMAIL=patchy@example.com",
+		"GIT_COMMITTER_NAME=patchy", "GIT_COMMITTER_EMAIL=patchy@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+// makeEpoch creates a bare public-inbox style epoch at inbox/git/N.git with
+// one commit per message.
+func makeEpoch(t *testing.T, inbox string, n int, messages []string) string {
+	t.Helper()
+	work := t.TempDir()
+	git(t, work, "init", "-q")
+	for i, msg := range messages {
+		if msg == "" {
+			git(t, work, "rm", "-q", "m")
+			git(t, work, "commit", "-q", "-m", "delete")
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(work, "m"), []byte(msg), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git(t, work, "add", "m")
+		git(t, work, "commit", "-q", "-m", fmt.Sprintf("message %d", i))
+	}
+
+	gitDir := filepath.Join(inbox, "git", fmt.Sprintf("%d.git", n))
+	git(t, inbox, "clone", "-q", "--bare", work, gitDir)
+	return gitDir
+}
+
+func message(id string) string {
+	return "Message-ID: <" + id + ">\nSubject: " + id + "\n\nbody\n"
+}
+
+func TestWalkPublicInbox(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	inbox := t.TempDir()
+	makeEpoch(t, inbox, 1, []string{message("c@x"), message("d@x")})
+	makeEpoch(t, inbox, 0, []string{message("a@x"), "", message("b@x")})
+
+	epochs, err := PublicInboxEpochs(inbox)
+	if err != nil {
+		t.Fatalf("PublicInboxEpochs() error = %v", err)
+	}
+	if len(epochs) != 2 || epochs[0].Number != 0 || epochs[1].Number != 1 {
+		t.Fatalf("unexpected epochs %+v", epochs)
+	}
+
+	ctx := context.Background()
+	var got []string
+	for _, epoch := range epochs {
+		got = append(got, epochMessageIDs(t, ctx, epoch.GitDir, "")...)
+	}
+	want := []string{"a@x", "b@x", "c@x", "d@x"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+
+	// Resuming after the first commit of epoch 1 only yields the second.
+	src, err := openEpoch(ctx, epochs[1].GitDir, "")
+	if err != nil {
+		t.Fatalf("openEpoch() error = %v", err)
+	}
+	src.Close()
+	got = epochMessageIDs(t, ctx, epochs[1].GitDir, src.commits[0])
+	if fmt.Sprint(got) != "[d@x]" {
+		t.Errorf("resumed messages = %v, want [d@x]", got)
+	}
+}
+
+func epochMessageIDs(t *testing.T, ctx context.Context, gitDir, since string) []string {
+	t.Helper()
+	src, err := openEpoch(ctx, gitDir, since)
+	if err != nil {
+		t.Fatalf("openEpoch() error = %v", err)
+	}
+	defer src.Close()
+
+	var ids []string
+	for {
+		msg, err := src.Next()
+		if err == io.EOF {
+			return ids
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		doc, _, err := parseDocument(msg.Data)
+		if err != nil {
+			t.Fatalf("parseDocument() error = %v", err)
+		}
+		ids = append(ids, doc.MessageID)
+	}
+}
+
+func TestPublicInboxEpochsSingleRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	inbox := t.TempDir()
+	gitDir := makeEpoch(t, inbox, 0, []string{message("a@x")})
+
+	epochs, err := PublicInboxEpochs(gitDir)
+	if err != nil {
+		t.Fatalf("PublicInboxEpochs() error = %v", err)
+	}
+	if len(epochs) != 1 || epochs[0].GitDir != gitDir {
+		t.Errorf("unexpected epochs %+v", epochs)
+	}
+}
@@ -0,0 +1,176 @@
+package ingest
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alexmorten/patchy/internal/mbox"
+)
+
+// Source yields raw messages one at a time. Next returns io.EOF once the
+// source is exhausted.
+type Source interface {
+	Next() (*RawMessage, error)
+	Close() error
+}
+
+// OpenSource opens path as whatever kind of mail store it looks like: a
+// Maildir if it has cur/ and new/ subdirectories, a directory of .eml files,
+// a single .eml file, or otherwise an mbox in the given format.
+func OpenSource(path string, format mbox.Format) (Source, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		if isMaildir(path) {
+			return OpenMaildir(path)
+		}
+		return OpenEMLDir(path)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".eml") {
+		return newFileSource([]string{path}), nil
+	}
+	return OpenMbox(path, format, 0)
+}
+
+// mboxSource reads messages from an mbox file, which may be compressed.
+type mboxSource struct {
+	file    *os.File
+	decoder io.Closer
+	reader  *mbox.Reader
+}
+
+// OpenMbox opens the mbox at path and starts reading at offset, which must be
+// the start of a message such as a checkpointed offset. Compressed archives
+// are decompressed while reading; their offsets count uncompressed bytes, so
+// resuming has to decompress and skip everything before offset.
+func OpenMbox(path string, format mbox.Format, offset int64) (Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, decoder, compression, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	src := &mboxSource{file: f, decoder: decoder}
+
+	if compression == Uncompressed {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			src.Close()
+			return nil, err
+		}
+		r = f
+	} else if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		src.Close()
+		return nil, fmt.Errorf("skipping to offset %d of %s: %w", offset, path, err)
+	}
+	src.reader = mbox.NewReaderAt(r, format, offset)
+	return src, nil
+}
+
+func (s *mboxSource) Next() (*RawMessage, error) {
+	msg, err := s.reader.Next()
+	if err != nil {
+		return nil, err
+	}
+	return &RawMessage{
+		Data:     msg.Data,
+		Offset:   msg.Offset,
+		Length:   msg.Length,
+		Location: fmt.Sprintf("offset %d", msg.Offset),
+	}, nil
+}
+
+func (s *mboxSource) Close() error {
+	s.decoder.Close()
+	return s.file.Close()
+}
+
+// fileSource yields one message per file. Offsets are positions in the file
+// list.
+type fileSource struct {
+	paths []string
+	next  int
+}
+
+func newFileSource(paths []string) *fileSource {
+	return &fileSource{paths: paths}
+}
+
+func (s *fileSource) Next() (*RawMessage, error) {
+	if s.next >= len(s.paths) {
+		return nil, io.EOF
+	}
+	i := s.next
+	s.next++
+
+	data, err := os.ReadFile(s.paths[i])
+	if err != nil {
+		return nil, err
+	}
+	return &RawMessage{Data: data, Offset: int64(i), Length: 1, Location: s.paths[i]}, nil
+}
+
+func (s *fileSource) Close() error {
+	return nil
+}
+
+func isMaildir(path string) bool {
+	for _, sub := range []string{"cur", "new"} {
+		info, err := os.Stat(filepath.Join(path, sub))
+		if err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// OpenMaildir reads every message in the cur/ and new/ folders of a Maildir.
+// Messages still being delivered to tmp/ are ignored. Maildir file names
+// start with the delivery time, so sorting them gives roughly arrival order.
+func OpenMaildir(path string) (Source, error) {
+	var paths []string
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(path, sub))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+				paths = append(paths, filepath.Join(path, sub, e.Name()))
+			}
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return filepath.Base(paths[i]) < filepath.Base(paths[j])
+	})
+	return newFileSource(paths), nil
+}
+
+// OpenEMLDir reads every .eml file below path.
+func OpenEMLDir(path string) (Source, error) {
+	var paths []string
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && strings.EqualFold(filepath.Ext(p), ".eml") {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return newFileSource(paths), nil
+}
@@ -0,0 +1,186 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexmorten/patchy/internal/mbox"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sourceMessageIDs(t *testing.T, src Source) []string {
+	t.Helper()
+	defer src.Close()
+
+	var ids []string
+	for {
+		msg, err := src.Next()
+		if err == io.EOF {
+			return ids
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		doc, _, err := parseDocument(msg.Data)
+		if err != nil {
+			t.Fatalf("%s: %v", msg.Location, err)
+		}
+		ids = append(ids, doc.MessageID)
+	}
+}
+
+func TestOpenSource(t *testing.T) {
+	dir := t.TempDir()
+
+	maildir := filepath.Join(dir, "maildir")
+	writeFile(t, filepath.Join(maildir, "cur", "1700000002.M1.host:2,S"), message("cur@x"))
+	writeFile(t, filepath.Join(maildir, "new", "1700000001.M1.host"), message("new@x"))
+	writeFile(t, filepath.Join(maildir, "tmp", "1700000003.M1.host"), message("tmp@x"))
+
+	emls := filepath.Join(dir, "emls")
+	writeFile(t, filepath.Join(emls, "b.eml"), message("b@x"))
+	writeFile(t, filepath.Join(emls, "nested", "a.eml"), message("a@x"))
+	writeFile(t, filepath.Join(emls, "notes.txt"), "not mail")
+
+	mboxFile := filepath.Join(dir, "archive.mbox")
+	writeFile(t, mboxFile, "From mboxrd@z Thu Jan  1 00:00:00 1970\n"+message("m1@x")+
+		"\nFrom mboxrd@z Thu Jan  1 00:00:00 1970\n"+message("m2@x"))
+
+	tests := []struct {
+		name string
+		path string
+		want []string
+	}{
+		{"maildir", maildir, []string{"new@x", "cur@x"}},
+		{"eml directory", emls, []string{"b@x", "a@x"}},
+		{"single eml", filepath.Join(emls, "b.eml"), []string{"b@x"}},
+		{"mbox", mboxFile, []string{"m1@x", "m2@x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := OpenSource(tt.path, mbox.MboxRD)
+			if err != nil {
+				t.Fatalf("OpenSource() error = %v", err)
+			}
+			got := sourceMessageIDs(t, src)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestOpenMboxCompressed(t *testing.T) {
+	first := "From mboxrd@z Thu Jan  1 00:00:00 1970\n" + message("m1@x")
+	archive := first + "\nFrom mboxrd@z Thu Jan  1 00:00:00 1970\n" + message("m2@x")
+
+	tests := []struct {
+		name     string
+		compress func(t *testing.T, data []byte) []byte
+		want     Compression
+	}{
+		{"plain", func(t *testing.T, data []byte) []byte { return data }, Uncompressed},
+		{"gzip", compressGzip, Gzip},
+		{"xz", compressXz, Xz},
+		{"zstd", compressZstd, Zstd},
+		{"bzip2", compressBzip2, Bzip2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.compress(t, []byte(archive))
+			if got := DetectCompression(data); got != tt.want {
+				t.Fatalf("DetectCompression() = %v, want %v", got, tt.want)
+			}
+			path := filepath.Join(t.TempDir(), "archive")
+			writeFile(t, path, string(data))
+
+			src, err := OpenMbox(path, mbox.MboxRD, 0)
+			if err != nil {
+				t.Fatalf("OpenMbox() error = %v", err)
+			}
+			if got := sourceMessageIDs(t, src); len(got) != 2 || got[0] != "m1@x" || got[1] != "m2@x" {
+				t.Errorf("got %v, want [m1@x m2@x]", got)
+			}
+
+			// Offsets count uncompressed bytes, so a checkpoint taken while
+			// reading the archive resumes at the second message.
+			src, err = OpenMbox(path, mbox.MboxRD, int64(len(first)+1))
+			if err != nil {
+				t.Fatalf("OpenMbox() error = %v", err)
+			}
+			if got := sourceMessageIDs(t, src); len(got) != 1 || got[0] != "m2@x" {
+				t.Errorf("resumed got %v, want [m2@x]", got)
+			}
+		})
+	}
+}
+
+func compressGzip(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func compressXz(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func compressZstd(t *testing.T, data []byte) []byte {
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	return w.EncodeAll(data, nil)
+}
+
+// The standard library only decompresses bzip2, so this shells out.
+func compressBzip2(t *testing.T, data []byte) []byte {
+	if _, err := exec.LookPath("bzip2"); err != nil {
+		t.Skip("bzip2 not installed")
+	}
+	cmd := exec.Command("bzip2", "-c")
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
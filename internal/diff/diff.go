@@ -0,0 +1,382 @@
+// Package diff extracts unified diffs from email bodies. It understands git
+// extended headers (renames, copies, modes, binary patches), plain
+// unified diffs and the diffstat block git format-patch puts above them.
+package diff
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diff is everything diff-like found in a message body.
+type Diff struct {
+	Files []*File
+	// Stat is the diffstat block, or nil if the body has none.
+	Stat *Diffstat
+}
+
+// File is the change to one file.
+type File struct {
+	// OldPath and NewPath are "/dev/null" for created and deleted files.
+	OldPath string
+	NewPath string
+
+	New        bool
+	Deleted    bool
+	Renamed    bool
+	Copied     bool
+	Binary     bool
+	Similarity int
+	OldMode    string
+	NewMode    string
+
+	Hunks   []*Hunk
+	Added   int
+	Removed int
+
+	// StartLine and EndLine are the lines of the body the file's diff spans,
+	// counting from 0, EndLine excluded.
+	StartLine int
+	EndLine   int
+}
+
+// Path is the path of the file after the change, or before it for deleted
+// files.
+func (f *File) Path() string {
+	if f.NewPath == "" || f.NewPath == devNull {
+		return f.OldPath
+	}
+	return f.NewPath
+}
+
+// Hunk is one @@ block.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	// Section is the function context git prints after the second @@.
+	Section string
+	// Lines are the hunk lines including their ' ', '+' or '-' prefix.
+	Lines   []string
+	Added   int
+	Removed int
+}
+
+// Diffstat is the summary git format-patch writes below the "---" line.
+type Diffstat struct {
+	Entries      []DiffstatEntry
+	FilesChanged int
+	Insertions   int
+	Deletions    int
+}
+
+// DiffstatEntry is one " path | 12 +++---" line.
+type DiffstatEntry struct {
+	Path    string
+	Changes int
+	Binary  bool
+}
+
+const devNull = "/dev/null"
+
+var (
+	hunkHeader    = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@ ?(.*)$`)
+	statEntry     = regexp.MustCompile(`^\s*(\S.*?)\s+\|\s+(?:(\d+)\s*[+-]*|(Bin)\b.*)$`)
+	statSummary   = regexp.MustCompile(`^\s*(\d+) files? changed(?:, (\d+) insertions?\(\+\))?(?:, (\d+) deletions?\(-\))?`)
+	similarityHdr = regexp.MustCompile(`^(?:similarity|dissimilarity) index (\d+)%`)
+)
+
+// Paths returns the path of every changed file in order, without duplicates.
+func (d *Diff) Paths() []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, f := range d.Files {
+		if p := f.Path(); p != "" && !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// Added is the number of added lines across all files.
+func (d *Diff) Added() int {
+	n := 0
+	for _, f := range d.Files {
+		n += f.Added
+	}
+	return n
+}
+
+// Removed is the number of removed lines across all files.
+func (d *Diff) Removed() int {
+	n := 0
+	for _, f := range d.Files {
+		n += f.Removed
+	}
+	return n
+}
+
+// Parse finds the diffs in body. Text around them, such as the commit
+// message or a signature, is ignored. Lines quoted with ">" in replies are
+// not diffs and are skipped as well.
+func Parse(body string) *Diff {
+	p := &parser{lines: strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")}
+	p.parse()
+	return &p.diff
+}
+
+type parser struct {
+	lines []string
+	pos   int
+	diff  Diff
+}
+
+func (p *parser) parse() {
+	var stat []DiffstatEntry
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			p.parseFile(p.parseGitFile)
+			continue
+		case strings.HasPrefix(line, "--- ") && p.pos+1 < len(p.lines) && strings.HasPrefix(p.lines[p.pos+1], "+++ "):
+			p.parseFile(p.parsePlainFile)
+			continue
+		}
+
+		if p.diff.Stat == nil {
+			if m := statEntry.FindStringSubmatch(line); m != nil {
+				entry := DiffstatEntry{Path: m[1], Binary: m[3] != ""}
+				entry.Changes, _ = strconv.Atoi(m[2])
+				stat = append(stat, entry)
+			} else if m := statSummary.FindStringSubmatch(line); m != nil {
+				s := &Diffstat{Entries: stat}
+				s.FilesChanged, _ = strconv.Atoi(m[1])
+				s.Insertions, _ = strconv.Atoi(m[2])
+				s.Deletions, _ = strconv.Atoi(m[3])
+				p.diff.Stat = s
+			} else {
+				stat = nil
+			}
+		}
+		p.pos++
+	}
+}
+
+// parseFile runs parse and records the lines of the file it added, if any.
+func (p *parser) parseFile(parse func()) {
+	start, files := p.pos, len(p.diff.Files)
+	parse()
+	if len(p.diff.Files) > files {
+		f := p.diff.Files[len(p.diff.Files)-1]
+		f.StartLine, f.EndLine = start, p.pos
+	}
+}
+
+// parseGitFile reads a "diff --git" header, its extended headers and hunks.
+func (p *parser) parseGitFile() {
+	f := &File{}
+	f.OldPath, f.NewPath = splitGitPaths(strings.TrimPrefix(p.lines[p.pos], "diff --git "))
+	p.diff.Files = append(p.diff.Files, f)
+	p.pos++
+
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		switch {
+		case strings.HasPrefix(line, "old mode "):
+			f.OldMode = strings.TrimPrefix(line, "old mode ")
+		case strings.HasPrefix(line, "new mode "):
+			f.NewMode = strings.TrimPrefix(line, "new mode ")
+		case strings.HasPrefix(line, "new file mode "):
+			f.New = true
+			f.NewMode = strings.TrimPrefix(line, "new file mode ")
+			f.OldPath = devNull
+		case strings.HasPrefix(line, "deleted file mode "):
+			f.Deleted = true
+			f.OldMode = strings.TrimPrefix(line, "deleted file mode ")
+			f.NewPath = devNull
+		case strings.HasPrefix(line, "rename from "):
+			f.Renamed = true
+			f.OldPath = unquote(strings.TrimPrefix(line, "rename from "))
+		case strings.HasPrefix(line, "rename to "):
+			f.Renamed = true
+			f.NewPath = unquote(strings.TrimPrefix(line, "rename to "))
+		case strings.HasPrefix(line, "copy from "):
+			f.Copied = true
+			f.OldPath = unquote(strings.TrimPrefix(line, "copy from "))
+		case strings.HasPrefix(line, "copy to "):
+			f.Copied = true
+			f.NewPath = unquote(strings.TrimPrefix(line, "copy to "))
+		case similarityHdr.MatchString(line):
+			f.Similarity, _ = strconv.Atoi(similarityHdr.FindStringSubmatch(line)[1])
+		case strings.HasPrefix(line, "index "):
+		case strings.HasPrefix(line, "Binary files "), line == "GIT binary patch":
+			f.Binary = true
+		case strings.HasPrefix(line, "--- "):
+			if path := diffPath(strings.TrimPrefix(line, "--- ")); path != "" {
+				f.OldPath = path
+			}
+		case strings.HasPrefix(line, "+++ "):
+			if path := diffPath(strings.TrimPrefix(line, "+++ ")); path != "" {
+				f.NewPath = path
+			}
+		case strings.HasPrefix(line, "@@ "):
+			p.parseHunks(f)
+			return
+		case strings.HasPrefix(line, "literal "), strings.HasPrefix(line, "delta "):
+			// Base85 data of a binary patch follows until a blank line.
+			p.pos++
+			for p.pos < len(p.lines) && p.lines[p.pos] != "" {
+				p.pos++
+			}
+			continue
+		default:
+			return
+		}
+		p.pos++
+	}
+}
+
+// parsePlainFile reads a diff that starts directly with "---" and "+++".
+func (p *parser) parsePlainFile() {
+	f := &File{
+		OldPath: diffPath(strings.TrimPrefix(p.lines[p.pos], "--- ")),
+		NewPath: diffPath(strings.TrimPrefix(p.lines[p.pos+1], "+++ ")),
+	}
+	f.New = f.OldPath == devNull
+	f.Deleted = f.NewPath == devNull
+	p.pos += 2
+	if p.pos < len(p.lines) && strings.HasPrefix(p.lines[p.pos], "@@ ") {
+		p.diff.Files = append(p.diff.Files, f)
+		p.parseHunks(f)
+	}
+}
+
+// parseHunks reads consecutive hunks. Each hunk is read for exactly as many
+// lines as its header announces, so a trailing signature or the "-- "
+// separator is not mistaken for a removed line.
+func (p *parser) parseHunks(f *File) {
+	for p.pos < len(p.lines) {
+		m := hunkHeader.FindStringSubmatch(p.lines[p.pos])
+		if m == nil {
+			return
+		}
+		h := &Hunk{Section: m[5]}
+		h.OldStart, _ = strconv.Atoi(m[1])
+		h.OldLines = 1
+		if m[2] != "" {
+			h.OldLines, _ = strconv.Atoi(m[2])
+		}
+		h.NewStart, _ = strconv.Atoi(m[3])
+		h.NewLines = 1
+		if m[4] != "" {
+			h.NewLines, _ = strconv.Atoi(m[4])
+		}
+		p.pos++
+
+		oldLeft, newLeft := h.OldLines, h.NewLines
+		for p.pos < len(p.lines) && (oldLeft > 0 || newLeft > 0) {
+			line := p.lines[p.pos]
+			switch {
+			case strings.HasPrefix(line, "+"):
+				h.Added++
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				h.Removed++
+				oldLeft--
+			case strings.HasPrefix(line, `\`):
+				// "\ No newline at end of file"
+			case strings.HasPrefix(line, " "), line == "":
+				// Mail clients like to strip the space of empty context
+				// lines.
+				oldLeft--
+				newLeft--
+			default:
+				oldLeft, newLeft = 0, 0
+				continue
+			}
+			h.Lines = append(h.Lines, line)
+			p.pos++
+		}
+		for p.pos < len(p.lines) && strings.HasPrefix(p.lines[p.pos], `\`) {
+			p.pos++
+		}
+
+		f.Hunks = append(f.Hunks, h)
+		f.Added += h.Added
+		f.Removed += h.Removed
+	}
+}
+
+// splitGitPaths splits the "a/old b/new" part of a diff --git line. Paths
+// with spaces are ambiguous there; the ---/+++ and rename lines that follow
+// take precedence.
+func splitGitPaths(s string) (string, string) {
+	if strings.HasPrefix(s, `"`) {
+		if old, rest, ok := cutQuoted(s); ok {
+			return stripPrefix(old), stripPrefix(unquote(strings.TrimSpace(rest)))
+		}
+	}
+	// Without a rename both halves are the same, so "a/X b/X" splits in
+	// the middle.
+	if len(s)%2 == 1 {
+		half := len(s) / 2
+		if s[half] == ' ' && s[2:half] == s[half+3:] {
+			return stripPrefix(s[:half]), stripPrefix(s[half+1:])
+		}
+	}
+	if i := strings.LastIndex(s, " b/"); i >= 0 {
+		return stripPrefix(s[:i]), stripPrefix(s[i+1:])
+	}
+	return s, s
+}
+
+// diffPath parses the path of a ---/+++ line, dropping a/ and b/ and any
+// timestamp diff appended after a tab.
+func diffPath(s string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = unquote(strings.TrimSpace(s))
+	if s == devNull {
+		return s
+	}
+	return stripPrefix(s)
+}
+
+func stripPrefix(path string) string {
+	if strings.HasPrefix(path, "a/") || strings.HasPrefix(path, "b/") {
+		return path[2:]
+	}
+	return path
+}
+
+// cutQuoted splits a leading C-quoted string off s.
+func cutQuoted(s string) (string, string, bool) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			unquoted, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", false
+			}
+			return unquoted, s[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// unquote decodes paths git quoted because of special characters.
+func unquote(s string) string {
+	if strings.HasPrefix(s, `"`) {
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			return unquoted
+		}
+	}
+	return s
+}
@@ -0,0 +1,255 @@
+package diff
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const formatPatch = `mm: fix the allocator
+
+It was broken.
+
+Signed-off-by: A U Thor <a@x>
+---
+ mm/slab.c          | 3 ++-
+ mm/{old.c => new.c} | 0
+ 2 files changed, 2 insertions(+), 1 deletion(-)
+
+diff --git a/mm/slab.c b/mm/slab.c
+index 1111111..2222222 100644
+--- a/mm/slab.c
++++ b/mm/slab.c
+@@ -10,4 +10,5 @@ static int slab_alloc(void)
+ 	int a;
+-	int b;
++	int b = 0;
++	int c;
+
+ 	return a;
+@@ -40 +41 @@ void slab_free(void)
+-	free(p);
++	kfree(p);
+diff --git a/mm/old.c b/mm/new.c
+similarity index 100%
+rename from mm/old.c
+rename to mm/new.c
+--
+2.43.0
+`
+
+func TestParseFormatPatch(t *testing.T) {
+	d := Parse(formatPatch)
+	if len(d.Files) != 2 {
+		t.Fatalf("got %d files, want 2", len(d.Files))
+	}
+
+	slab := d.Files[0]
+	if slab.OldPath != "mm/slab.c" || slab.NewPath != "mm/slab.c" || slab.Renamed {
+		t.Errorf("slab = %+v", slab)
+	}
+	if len(slab.Hunks) != 2 {
+		t.Fatalf("got %d hunks, want 2", len(slab.Hunks))
+	}
+	h := slab.Hunks[0]
+	if h.OldStart != 10 || h.OldLines != 4 || h.NewStart != 10 || h.NewLines != 5 || h.Section != "static int slab_alloc(void)" {
+		t.Errorf("hunk 0 = %+v", h)
+	}
+	if len(h.Lines) != 6 || h.Added != 2 || h.Removed != 1 {
+		t.Errorf("hunk 0 lines = %q", h.Lines)
+	}
+	if h := slab.Hunks[1]; h.OldLines != 1 || h.NewStart != 41 || h.Section != "void slab_free(void)" {
+		t.Errorf("hunk 1 = %+v", h)
+	}
+	if slab.Added != 3 || slab.Removed != 2 {
+		t.Errorf("slab counts = +%d -%d", slab.Added, slab.Removed)
+	}
+
+	rename := d.Files[1]
+	if !rename.Renamed || rename.OldPath != "mm/old.c" || rename.NewPath != "mm/new.c" || rename.Similarity != 100 || len(rename.Hunks) != 0 {
+		t.Errorf("rename = %+v", rename)
+	}
+
+	if slab.StartLine != 10 || slab.EndLine != 24 || rename.StartLine != 24 || rename.EndLine != 28 {
+		t.Errorf("file lines = %d-%d, %d-%d", slab.StartLine, slab.EndLine, rename.StartLine, rename.EndLine)
+	}
+
+	if got := fmt.Sprint(d.Paths()); got != "[mm/slab.c mm/new.c]" {
+		t.Errorf("Paths() = %s", got)
+	}
+	if d.Added() != 3 || d.Removed() != 2 {
+		t.Errorf("totals = +%d -%d", d.Added(), d.Removed())
+	}
+
+	want := &Diffstat{
+		Entries: []DiffstatEntry{
+			{Path: "mm/slab.c", Changes: 3},
+			{Path: "mm/{old.c => new.c}", Changes: 0},
+		},
+		FilesChanged: 2,
+		Insertions:   2,
+		Deletions:    1,
+	}
+	if !reflect.DeepEqual(d.Stat, want) {
+		t.Errorf("Stat = %+v, want %+v", d.Stat, want)
+	}
+}
+
+func TestParseFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// want renders each file as "old>new flags +added -removed".
+		want string
+	}{
+		{
+			name: "new file",
+			body: "diff --git a/foo.c b/foo.c\nnew file mode 100644\nindex 0000000..1111111\n" +
+				"--- /dev/null\n+++ b/foo.c\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+			want: "/dev/null>foo.c new +2 -0",
+		},
+		{
+			name: "deleted file",
+			body: "diff --git a/foo.c b/foo.c\ndeleted file mode 100644\n" +
+				"--- a/foo.c\n+++ /dev/null\n@@ -1 +0,0 @@\n-a\n",
+			want: "foo.c>/dev/null deleted +0 -1",
+		},
+		{
+			name: "binary file",
+			body: "diff --git a/logo.png b/logo.png\nindex 1111111..2222222 100644\n" +
+				"Binary files a/logo.png and b/logo.png differ\n",
+			want: "logo.png>logo.png binary +0 -0",
+		},
+		{
+			name: "git binary patch",
+			body: "diff --git a/logo.png b/logo.png\nnew file mode 100644\nGIT binary patch\n" +
+				"literal 5\nMcmZ?wbhEG+00\n\nliteral 0\nHcmV?d00001\n\n" +
+				"diff --git a/a.c b/a.c\n--- a/a.c\n+++ b/a.c\n@@ -1 +1 @@\n-x\n+y\n",
+			want: "/dev/null>logo.png new binary +0 -0, a.c>a.c +1 -1",
+		},
+		{
+			name: "mode change",
+			body: "diff --git a/run.sh b/run.sh\nold mode 100644\nnew mode 100755\n",
+			want: "run.sh>run.sh mode +0 -0",
+		},
+		{
+			name: "rename with changes",
+			body: "diff --git a/a.c b/b.c\nsimilarity index 90%\nrename from a.c\nrename to b.c\n" +
+				"--- a/a.c\n+++ b/b.c\n@@ -1,2 +1,2 @@\n x\n-y\n+z\n",
+			want: "a.c>b.c renamed +1 -1",
+		},
+		{
+			name: "copy",
+			body: "diff --git a/a.c b/b.c\nsimilarity index 100%\ncopy from a.c\ncopy to b.c\n",
+			want: "a.c>b.c copied +0 -0",
+		},
+		{
+			name: "paths with spaces",
+			body: "diff --git a/my file.txt b/my file.txt\n--- a/my file.txt\n+++ b/my file.txt\n@@ -1 +1 @@\n-a\n+b\n",
+			want: "my file.txt>my file.txt +1 -1",
+		},
+		{
+			name: "quoted paths",
+			body: "diff --git \"a/t\\303\\244st.c\" \"b/t\\303\\244st.c\"\nindex 1..2 100644\n",
+			want: "täst.c>täst.c +0 -0",
+		},
+		{
+			name: "plain unified diff with timestamps",
+			body: "Try this:\n\n--- foo.c.orig\t2024-01-01 00:00:00\n+++ foo.c\t2024-01-02 00:00:00\n" +
+				"@@ -1,3 +1,3 @@\n a\n-b\n+c\n d\n\nThanks\n",
+			want: "foo.c.orig>foo.c +1 -1",
+		},
+		{
+			name: "stripped empty context line and signature",
+			body: "diff --git a/a.c b/a.c\n--- a/a.c\n+++ b/a.c\n@@ -1,3 +1,3 @@\n-a\n+b\n\n c\n-- \n2.43.0\n",
+			want: "a.c>a.c +1 -1",
+		},
+		{
+			name: "no newline at end of file",
+			body: "diff --git a/a.c b/a.c\n--- a/a.c\n+++ b/a.c\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n",
+			want: "a.c>a.c +1 -1",
+		},
+		{
+			name: "quoted diff in a reply",
+			body: "> diff --git a/a.c b/a.c\n> --- a/a.c\n> +++ b/a.c\n> @@ -1 +1 @@\n> -a\n> +b\n\nLooks good.\n",
+			want: "",
+		},
+		{
+			name: "no diff",
+			body: "--- not a diff\nJust prose.\n",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range Parse(tt.body).Files {
+				got = append(got, renderFile(f))
+			}
+			if s := strings.Join(got, ", "); s != tt.want {
+				t.Errorf("Parse() = %q, want %q", s, tt.want)
+			}
+		})
+	}
+}
+
+func renderFile(f *File) string {
+	s := f.OldPath + ">" + f.NewPath
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{f.New, "new"},
+		{f.Deleted, "deleted"},
+		{f.Renamed, "renamed"},
+		{f.Copied, "copied"},
+		{f.Binary, "binary"},
+		{f.OldMode != "" && f.NewMode != "" && !f.New, "mode"},
+	} {
+		if flag.set {
+			s += " " + flag.name
+		}
+	}
+	return fmt.Sprintf("%s +%d -%d", s, f.Added, f.Removed)
+}
+
+func TestParseDiffstat(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *Diffstat
+	}{
+		{
+			name: "binary entry and insertions only",
+			body: "---\n logo.png | Bin 0 -> 1234 bytes\n README   | 2 ++\n 2 files changed, 2 insertions(+)\n",
+			want: &Diffstat{
+				Entries:      []DiffstatEntry{{Path: "logo.png", Binary: true}, {Path: "README", Changes: 2}},
+				FilesChanged: 2,
+				Insertions:   2,
+			},
+		},
+		{
+			name: "single file deletion",
+			body: "---\n a.c | 1 -\n 1 file changed, 1 deletion(-)\n",
+			want: &Diffstat{
+				Entries:      []DiffstatEntry{{Path: "a.c", Changes: 1}},
+				FilesChanged: 1,
+				Deletions:    1,
+			},
+		},
+		{
+			name: "no diffstat",
+			body: "Just prose | with a pipe 3\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.body).Stat; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Stat = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
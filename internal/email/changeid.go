@@ -0,0 +1,17 @@
+package email
+
+import "regexp"
+
+// changeIDLine matches the change-id b4 adds to cover letters and single
+// patches, and the Gerrit Change-Id trailer some subsystems keep in commit
+// messages. Both stay the same across revisions of a change. Quoted lines
+// in replies do not match since they start with ">".
+var changeIDLine = regexp.MustCompile(`(?im)^change-id:[ \t]*(\S+)[ \t]*$`)
+
+// ChangeID returns the change-id of the message body, or "" if it has none.
+func (m *Message) ChangeID() string {
+	if match := changeIDLine.FindStringSubmatch(m.Body); match != nil {
+		return match[1]
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package email
+
+import "testing"
+
+func TestChangeID(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "b4 cover letter",
+			body: "Cover text\n\n---\nbase-commit: 0123abcd\nchange-id: 20240301-frob-rework-a1b2c3d4e5f6\n\nBest regards,\n",
+			want: "20240301-frob-rework-a1b2c3d4e5f6",
+		},
+		{
+			name: "gerrit trailer",
+			body: "Fix it.\n\nChange-Id: I0123456789abcdef0123456789abcdef01234567\nSigned-off-by: A <a@x>\n",
+			want: "I0123456789abcdef0123456789abcdef01234567",
+		},
+		{
+			name: "quoted in a reply",
+			body: "> change-id: 20240301-frob-rework-a1b2c3d4e5f6\n\nLooks good.\n",
+			want: "",
+		},
+		{
+			name: "mentioned in prose",
+			body: "The change-id: trailer is added by b4.\n",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (&Message{Body: tt.body}).ChangeID(); got != tt.want {
+				t.Errorf("ChangeID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
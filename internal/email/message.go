@@ -0,0 +1,258 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Message is an email with the headers patchy works with parsed out.
+// Address and text headers are decoded to UTF-8; Header keeps the raw values.
+// Body is the decoded text/plain content, Raw the message as it was read.
+type Message struct {
+	MessageID  string
+	From       Address
+	To         []Address
+	Cc         []Address
+	Subject    string
+	Date       time.Time
+	InReplyTo  string
+	References []string
+	ListID     string
+	Header     Header
+	Body       string
+	Raw        []byte
+}
+
+// Address is a single mailbox such as "Jane Doe <jane@example.com>".
+type Address struct {
+	Name  string
+	Email string
+}
+
+func (a Address) String() string {
+	if a.Name == "" {
+		return a.Email
+	}
+	return a.Name + " <" + a.Email + ">"
+}
+
+// HeaderField is a single unfolded header line.
+type HeaderField struct {
+	Key   string
+	Value string
+}
+
+// Header holds header fields in the order they appear in the message.
+// Keys are stored in canonical MIME form.
+type Header []HeaderField
+
+// Get returns the first value for key, or "" if the header is missing.
+func (h Header) Get(key string) string {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	for _, f := range h {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Values returns every value for key in message order.
+func (h Header) Values(key string) []string {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	var values []string
+	for _, f := range h {
+		if f.Key == key {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
+
+// ErrNoHeaders is returned by Parse when the input has no header block.
+var ErrNoHeaders = errors.New("email: message has no headers")
+
+var (
+	messageIDListRegex = regexp.MustCompile(`<([^<>]+)>`)
+	bareAddressRegex   = regexp.MustCompile(`[^\s<>,;"()]+@[^\s<>,;"()]+`)
+)
+
+// dateLayouts are tried in order when net/mail cannot parse a Date header.
+// Mailing list archives are full of dates written by broken clients.
+var dateLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 06 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 2006",
+	"Mon Jan 2 15:04:05 -0700 2006",
+	time.RFC3339,
+}
+
+// Parse parses a raw RFC 5322 message. It is lenient by design: malformed
+// header lines are skipped and undecodable values are kept as-is, because a
+// single bad message must not stop an archive import.
+func Parse(raw []byte) (*Message, error) {
+	header, body := splitMessage(raw)
+	if len(header) == 0 {
+		return nil, ErrNoHeaders
+	}
+
+	m := &Message{
+		Header:     header,
+		Body:       decodeBody(header, body),
+		Raw:        raw,
+		MessageID:  firstMessageID(header.Get("Message-Id")),
+		Subject:    strings.TrimSpace(decodeHeader(header.Get("Subject"))),
+		InReplyTo:  firstMessageID(header.Get("In-Reply-To")),
+		References: parseMessageIDs(strings.Join(header.Values("References"), " ")),
+		ListID:     parseListID(header.Get("List-Id")),
+		Date:       parseDate(header.Get("Date")),
+	}
+
+	if from := parseAddressList(header.Get("From")); len(from) > 0 {
+		m.From = from[0]
+	}
+	for _, v := range header.Values("To") {
+		m.To = append(m.To, parseAddressList(v)...)
+	}
+	for _, v := range header.Values("Cc") {
+		m.Cc = append(m.Cc, parseAddressList(v)...)
+	}
+
+	return m, nil
+}
+
+// splitMessage separates the header block from the body and unfolds
+// continuation lines.
+func splitMessage(raw []byte) (Header, []byte) {
+	var header Header
+	rest := raw
+	for len(rest) > 0 {
+		line := rest
+		next := []byte(nil)
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, next = rest[:i], rest[i+1:]
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+
+		if len(line) == 0 {
+			return header, next
+		}
+		rest = next
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(header) > 0 {
+				header[len(header)-1].Value += " " + strings.TrimSpace(string(line))
+			}
+			continue
+		}
+
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(string(line[:colon]))
+		if key == "" || strings.ContainsAny(key, " \t") {
+			continue
+		}
+		header = append(header, HeaderField{
+			Key:   textproto.CanonicalMIMEHeaderKey(key),
+			Value: strings.TrimSpace(string(line[colon+1:])),
+		})
+	}
+	return header, nil
+}
+
+// parseMessageIDs returns all message IDs in a header value such as
+// References. Values without angle brackets are split on whitespace.
+func parseMessageIDs(value string) []string {
+	var ids []string
+	matches := messageIDListRegex.FindAllStringSubmatch(value, -1)
+	if len(matches) == 0 {
+		for _, field := range strings.Fields(value) {
+			if strings.Contains(field, "@") {
+				ids = append(ids, field)
+			}
+		}
+		return ids
+	}
+	for _, match := range matches {
+		if id := strings.TrimSpace(match[1]); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func firstMessageID(value string) string {
+	ids := parseMessageIDs(value)
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[0]
+}
+
+func parseListID(value string) string {
+	if match := messageIDListRegex.FindStringSubmatch(value); match != nil {
+		return strings.TrimSpace(match[1])
+	}
+	return strings.TrimSpace(value)
+}
+
+// parseAddressList decodes an address header. When the value is not valid
+// RFC 5322 it falls back to picking out anything that looks like an address.
+func parseAddressList(value string) []Address {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+
+	parser := mail.AddressParser{WordDecoder: wordDecoder}
+	if list, err := parser.ParseList(value); err == nil {
+		addrs := make([]Address, 0, len(list))
+		for _, a := range list {
+			addrs = append(addrs, Address{Name: a.Name, Email: a.Address})
+		}
+		return addrs
+	}
+
+	var addrs []Address
+	for _, e := range bareAddressRegex.FindAllString(value, -1) {
+		addrs = append(addrs, Address{Email: e})
+	}
+	if len(addrs) == 1 {
+		name := strings.TrimSpace(strings.Replace(value, addrs[0].Email, "", 1))
+		name = strings.Trim(name, ` "'<>()`)
+		addrs[0].Name = decodeHeader(name)
+	}
+	return addrs
+}
+
+func parseDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}
+	}
+	if t, err := mail.ParseDate(value); err == nil {
+		return t
+	}
+
+	// Drop trailing comments like "(PDT)" and collapse runs of spaces.
+	if i := strings.Index(value, "("); i > 0 {
+		value = value[:i]
+	}
+	value = strings.Join(strings.Fields(value), " ")
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
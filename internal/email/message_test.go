@@ -0,0 +1,151 @@
+package email
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const sampleMessage = "From: Jane Doe <jane@example.com>\n" +
+	"To: linux-kernel@vger.kernel.org,\n" +
+	" \"Bob\" <bob@example.com>\n" +
+	"To: carol@example.com\n" +
+	"Cc: =?UTF-8?Q?J=C3=B6rg_M=C3=BCller?= <joerg@example.de>\n" +
+	"Subject: [PATCH v2 1/3] mm: fix a very long subject\n" +
+	"\tthat was folded\n" +
+	"Date: Thu, 16 Jan 2025 15:23:34 -0800\n" +
+	"Message-ID: <20250116.1@example.com>\n" +
+	"In-Reply-To: <20250116.0@example.com>\n" +
+	"References: <20250115.9@example.com>\n" +
+	"\t<20250116.0@example.com>\n" +
+	"List-Id: <linux-kernel.vger.kernel.org>\n" +
+	"\n" +
+	"Hello world\n"
+
+func TestParse(t *testing.T) {
+	m, err := Parse([]byte(sampleMessage))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if m.MessageID != "20250116.1@example.com" {
+		t.Errorf("MessageID = %q", m.MessageID)
+	}
+	if want := (Address{Name: "Jane Doe", Email: "jane@example.com"}); m.From != want {
+		t.Errorf("From = %+v, want %+v", m.From, want)
+	}
+	wantTo := []Address{
+		{Email: "linux-kernel@vger.kernel.org"},
+		{Name: "Bob", Email: "bob@example.com"},
+		{Email: "carol@example.com"},
+	}
+	if !reflect.DeepEqual(m.To, wantTo) {
+		t.Errorf("To = %+v, want %+v", m.To, wantTo)
+	}
+	wantCc := []Address{{Name: "Jörg Müller", Email: "joerg@example.de"}}
+	if !reflect.DeepEqual(m.Cc, wantCc) {
+		t.Errorf("Cc = %+v, want %+v", m.Cc, wantCc)
+	}
+	if want := "[PATCH v2 1/3] mm: fix a very long subject that was folded"; m.Subject != want {
+		t.Errorf("Subject = %q, want %q", m.Subject, want)
+	}
+	wantDate := time.Date(2025, 1, 16, 23, 23, 34, 0, time.UTC)
+	if !m.Date.Equal(wantDate) {
+		t.Errorf("Date = %v, want %v", m.Date, wantDate)
+	}
+	if m.InReplyTo != "20250116.0@example.com" {
+		t.Errorf("InReplyTo = %q", m.InReplyTo)
+	}
+	wantRefs := []string{"20250115.9@example.com", "20250116.0@example.com"}
+	if !reflect.DeepEqual(m.References, wantRefs) {
+		t.Errorf("References = %v, want %v", m.References, wantRefs)
+	}
+	if m.ListID != "linux-kernel.vger.kernel.org" {
+		t.Errorf("ListID = %q", m.ListID)
+	}
+	if m.Body != "Hello world\n" {
+		t.Errorf("Body = %q", m.Body)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		check   func(m *Message) any
+		want    any
+		wantErr bool
+	}{
+		{
+			name:  "encoded-word subject split across lines",
+			raw:   "Subject: =?utf-8?q?Re=3A_caf=C3=A9?=\n =?utf-8?b?IGF1IGxhaXQ=?=\n\n",
+			check: func(m *Message) any { return m.Subject },
+			want:  "Re: café au lait",
+		},
+		{
+			name:  "latin1 encoded-word",
+			raw:   "Subject: =?iso-8859-15?q?Gr=FC=DFe?=\n\n",
+			check: func(m *Message) any { return m.Subject },
+			want:  "Grüße",
+		},
+		{
+			name:  "duplicate subject keeps first",
+			raw:   "Subject: first\nSubject: second\n\n",
+			check: func(m *Message) any { return m.Subject },
+			want:  "first",
+		},
+		{
+			name:  "CRLF line endings",
+			raw:   "Message-Id: <crlf@example.com>\r\nSubject: hi\r\n\r\nbody\r\n",
+			check: func(m *Message) any { return m.MessageID + "|" + m.Subject + "|" + m.Body },
+			want:  "crlf@example.com|hi|body\r\n",
+		},
+		{
+			name:  "in-reply-to with trailing comment",
+			raw:   "In-Reply-To: <parent@example.com> (Jane Doe's message of Thu)\n\n",
+			check: func(m *Message) any { return m.InReplyTo },
+			want:  "parent@example.com",
+		},
+		{
+			name:  "malformed from falls back to bare address",
+			raw:   "From: Jane Doe jane@example.com\n\n",
+			check: func(m *Message) any { return m.From },
+			want:  Address{Name: "Jane Doe", Email: "jane@example.com"},
+		},
+		{
+			name:  "date with trailing zone comment",
+			raw:   "Date: Tue, 7 Jan 2025 09:00:00 +0100 (CET)\n\n",
+			check: func(m *Message) any { return m.Date.UTC() },
+			want:  time.Date(2025, 1, 7, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "garbage header lines are skipped",
+			raw:   "From mboxrd@z Thu Jan  1 00:00:00 1970\nnot a header\nSubject: ok\n\n",
+			check: func(m *Message) any { return m.Subject },
+			want:  "ok",
+		},
+		{
+			name:    "no headers",
+			raw:     "\njust a body\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Parse([]byte(tt.raw))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Parse() expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := tt.check(m); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxMIMEDepth bounds recursion into nested multipart bodies.
+const maxMIMEDepth = 10
+
+// displayHeaders are the headers Text renders above the decoded body.
+var displayHeaders = []string{"From", "To", "Cc", "Subject", "Date", "Message-Id", "In-Reply-To", "References"}
+
+// Text renders the message as plain UTF-8 text: the display headers with
+// encoded-words decoded, a blank line and the decoded body. This is what gets
+// stored as a document's text and indexed for search.
+func (m *Message) Text() string {
+	var b strings.Builder
+	for _, key := range displayHeaders {
+		for _, value := range m.Header.Values(key) {
+			b.WriteString(key)
+			b.WriteString(": ")
+			b.WriteString(decodeHeader(value))
+			b.WriteByte('\n')
+		}
+	}
+	b.WriteByte('\n')
+	b.WriteString(m.Body)
+	return sanitizeText(b.String())
+}
+
+// decodeBody turns the raw body of a message into UTF-8 text. For multipart
+// messages the first text/plain part is used, followed by any patch
+// attachments, since that is where kernel mail keeps its diffs.
+func decodeBody(header Header, body []byte) string {
+	parts := collectTextParts(header, body, 0)
+	if len(parts) == 0 {
+		return sanitizeText(string(body))
+	}
+
+	var b strings.Builder
+	for i, p := range parts {
+		if i > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteByte('\n')
+		}
+		b.WriteString(p)
+	}
+	return sanitizeText(b.String())
+}
+
+// collectTextParts walks a MIME tree and returns the decoded parts that make
+// up the readable body.
+func collectTextParts(header Header, body []byte, depth int) []string {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType == "" {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maxMIMEDepth {
+		return collectMultipart(mediaType, params["boundary"], body, depth)
+	}
+	if mediaType == "message/rfc822" && depth < maxMIMEDepth {
+		inner, innerBody := splitMessage(body)
+		return collectTextParts(inner, innerBody, depth+1)
+	}
+	if !strings.HasPrefix(mediaType, "text/") {
+		return nil
+	}
+
+	decoded := decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+	return []string{decodeCharset(params["charset"], decoded)}
+}
+
+func collectMultipart(mediaType, boundary string, body []byte, depth int) []string {
+	if boundary == "" {
+		return nil
+	}
+
+	var plain, patches, other []string
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextRawPart()
+		if err != nil {
+			break
+		}
+		partBody, readErr := io.ReadAll(part)
+		partHeader := make(Header, 0, len(part.Header))
+		for key, values := range part.Header {
+			for _, v := range values {
+				partHeader = append(partHeader, HeaderField{Key: key, Value: v})
+			}
+		}
+
+		partType, _, _ := mime.ParseMediaType(partHeader.Get("Content-Type"))
+		texts := collectTextParts(partHeader, partBody, depth+1)
+		switch {
+		case partType == "" || partType == "text/plain":
+			if len(plain) > 0 && isAttachment(partHeader) {
+				patches = append(patches, texts...)
+			} else {
+				plain = append(plain, texts...)
+			}
+		case isPatchType(partType):
+			patches = append(patches, texts...)
+		case strings.HasPrefix(partType, "multipart/"), partType == "message/rfc822":
+			plain = append(plain, texts...)
+		default:
+			other = append(other, texts...)
+		}
+
+		// multipart/alternative offers the same content several times,
+		// the first readable variant is enough.
+		if readErr != nil || (mediaType == "multipart/alternative" && len(plain) > 0) {
+			break
+		}
+	}
+
+	if len(plain) == 0 && len(other) > 0 {
+		plain = other[:1]
+	}
+	return append(plain, patches...)
+}
+
+func isAttachment(header Header) bool {
+	disposition, _, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	return err == nil && disposition == "attachment"
+}
+
+func isPatchType(mediaType string) bool {
+	switch mediaType {
+	case "text/x-patch", "text/x-diff", "text/x-log":
+		return true
+	}
+	return false
+}
+
+// decodeTransferEncoding undoes quoted-printable and base64 encoding. Broken
+// encodings decode as far as possible rather than dropping the part.
+func decodeTransferEncoding(encoding string, body []byte) []byte {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+		if err != nil && len(decoded) == 0 {
+			return body
+		}
+		return decoded
+	case "base64":
+		compact := bytes.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, body)
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(compact)))
+		n, err := base64.StdEncoding.Decode(decoded, compact)
+		if err != nil {
+			n, err = base64.RawStdEncoding.Decode(decoded, bytes.TrimRight(compact, "="))
+			if err != nil && n == 0 {
+				return body
+			}
+		}
+		return decoded[:n]
+	default:
+		return body
+	}
+}
+
+// decodeCharset converts body from charset to UTF-8. Undeclared or unknown
+// charsets that aren't valid UTF-8 are read as Windows-1252, which is what
+// most old mail clients actually sent.
+func decodeCharset(charset string, body []byte) string {
+	if charset == "" || charsetIsUTF8(charset) {
+		if utf8.Valid(body) {
+			return string(body)
+		}
+		charset = "windows-1252"
+	}
+
+	r, err := charsetReader(charset, bytes.NewReader(body))
+	if err != nil {
+		if utf8.Valid(body) {
+			return string(body)
+		}
+		r, _ = charsetReader("windows-1252", bytes.NewReader(body))
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return strings.ToValidUTF8(string(body), "�")
+	}
+	return string(decoded)
+}
+
+func charsetIsUTF8(charset string) bool {
+	switch strings.ToLower(strings.Trim(charset, `"' `)) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return true
+	}
+	return false
+}
+
+// sanitizeText makes text safe to store in a Postgres text column, which
+// rejects NUL bytes and invalid UTF-8.
+func sanitizeText(s string) string {
+	s = strings.ToValidUTF8(s, "�")
+	return strings.ReplaceAll(s, "\x00", "")
+}
@@ -0,0 +1,120 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBody(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			name: "plain 7bit",
+			raw:  "Subject: x\n\nplain body\n",
+			want: "plain body\n",
+		},
+		{
+			name: "quoted-printable utf-8",
+			raw: "Content-Type: text/plain; charset=utf-8\n" +
+				"Content-Transfer-Encoding: quoted-printable\n\n" +
+				"caf=C3=A9 and a soft=\n line break\n",
+			want: "café and a soft line break\n",
+		},
+		{
+			name: "base64 patch",
+			raw: "Content-Type: text/plain; charset=\"us-ascii\"\n" +
+				"Content-Transfer-Encoding: base64\n\n" +
+				"ZGlmZiAtLWdpdCBhL2lvX3VyaW5nLmMgYi9pb191cmluZy5jCg==\n",
+			want: "diff --git a/io_uring.c b/io_uring.c\n",
+		},
+		{
+			name: "latin1 8bit",
+			raw: "Content-Type: text/plain; charset=iso-8859-1\n" +
+				"Content-Transfer-Encoding: 8bit\n\n" +
+				"Gr\xfc\xdfe\n",
+			want: "Grüße\n",
+		},
+		{
+			name: "undeclared non-utf8 falls back to windows-1252",
+			raw:  "Subject: x\n\nna\xefve\n",
+			want: "naïve\n",
+		},
+		{
+			name: "multipart with patch attachment",
+			raw: "Content-Type: multipart/mixed; boundary=\"b1\"\n\n" +
+				"--b1\n" +
+				"Content-Type: text/plain; charset=utf-8\n\n" +
+				"See attached.\n" +
+				"--b1\n" +
+				"Content-Type: text/x-patch; name=\"fix.patch\"\n" +
+				"Content-Disposition: attachment; filename=\"fix.patch\"\n" +
+				"Content-Transfer-Encoding: base64\n\n" +
+				"LS0tIGEvZm9vLmMKKysrIGIvZm9vLmMK\n" +
+				"--b1\n" +
+				"Content-Type: application/octet-stream\n\n" +
+				"binary\n" +
+				"--b1--\n",
+			want: "See attached.\n--- a/foo.c\n+++ b/foo.c\n",
+		},
+		{
+			name: "multipart alternative prefers text/plain",
+			raw: "Content-Type: multipart/alternative; boundary=alt\n\n" +
+				"--alt\n" +
+				"Content-Type: text/plain\n\n" +
+				"plain version\n" +
+				"--alt\n" +
+				"Content-Type: text/html\n\n" +
+				"<p>html version</p>\n" +
+				"--alt--\n",
+			want: "plain version",
+		},
+		{
+			name: "html only",
+			raw: "Content-Type: multipart/alternative; boundary=alt\n\n" +
+				"--alt\n" +
+				"Content-Type: text/html\n\n" +
+				"<p>html version</p>\n" +
+				"--alt--\n",
+			want: "<p>html version</p>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Parse([]byte(tt.raw))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if m.Body != tt.want {
+				t.Errorf("Body = %q, want %q", m.Body, tt.want)
+			}
+			if string(m.Raw) != tt.raw {
+				t.Errorf("Raw was not preserved")
+			}
+		})
+	}
+}
+
+func TestMessageText(t *testing.T) {
+	raw := "From: =?utf-8?q?J=C3=B6rg?= <j@example.com>\n" +
+		"Subject: hello\n" +
+		"X-Mailer: ignored\n" +
+		"Content-Transfer-Encoding: quoted-printable\n\n" +
+		"a=3Db\n"
+	m, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	got := m.Text()
+	want := "From: Jörg <j@example.com>\nSubject: hello\n\na=b\n"
+	if got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+	if strings.Contains(got, "X-Mailer") {
+		t.Errorf("Text() should only render display headers")
+	}
+}
@@ -0,0 +1,121 @@
+package email
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PatchSubject is the structured data in a subject such as
+// "[PATCH RFC net-next v3 2/7] tcp: fix foo".
+type PatchSubject struct {
+	// Prefixes are the bracketed tags other than the version and numbering,
+	// in the order they appear, e.g. PATCH, RFC, net-next.
+	Prefixes []string
+	// Reply is set when the subject starts with Re: or a similar prefix.
+	Reply  bool
+	Patch  bool
+	RFC    bool
+	Resend bool
+	// Tree is the target tree, e.g. net-next or bpf, if one was given.
+	Tree string
+	// Version is 1 for patches without a vN tag and 0 for other mail.
+	Version int
+	// Index and Total are the M/T numbering; a cover letter has Index 0.
+	Index int
+	Total int
+	// Subject is what remains after the reply prefixes and tags.
+	Subject string
+}
+
+// IsCoverLetter reports whether the subject is the 0/N mail of a series.
+func (s PatchSubject) IsCoverLetter() bool {
+	return s.Patch && !s.Reply && s.Index == 0 && s.Total > 0
+}
+
+var (
+	subjectReplyPrefix = regexp.MustCompile(`(?i)^(re|fwd?|aw|sv|antw)(\[\d+\])?\s*:\s*`)
+	subjectVersion     = regexp.MustCompile(`(?i)^v(\d+)$`)
+	subjectNumbering   = regexp.MustCompile(`^(\d+)/(\d+)$`)
+	subjectPatchTag    = regexp.MustCompile(`(?i)^patch-?v?(\d*)$`)
+)
+
+// ParseSubject extracts the patch tags from a subject. Tags are read from
+// leading bracket groups only, so "[PATCH] [v2] foo" is understood but
+// brackets later in the subject are left alone.
+func ParseSubject(subject string) PatchSubject {
+	var s PatchSubject
+	rest := strings.TrimSpace(subject)
+
+	for {
+		if loc := subjectReplyPrefix.FindStringIndex(rest); loc != nil {
+			s.Reply = true
+			rest = strings.TrimSpace(rest[loc[1]:])
+			continue
+		}
+		if !strings.HasPrefix(rest, "[") {
+			break
+		}
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			break
+		}
+		s.parseTags(rest[1:end])
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+
+	if s.Patch && s.Version == 0 {
+		s.Version = 1
+	}
+	s.Subject = rest
+	return s
+}
+
+func (s *PatchSubject) parseTags(group string) {
+	fields := strings.FieldsFunc(group, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ','
+	})
+	for _, field := range fields {
+		if m := subjectVersion.FindStringSubmatch(field); m != nil {
+			s.Version, _ = strconv.Atoi(m[1])
+			continue
+		}
+		if m := subjectNumbering.FindStringSubmatch(field); m != nil {
+			s.Index, _ = strconv.Atoi(m[1])
+			s.Total, _ = strconv.Atoi(m[2])
+			continue
+		}
+		if m := subjectPatchTag.FindStringSubmatch(field); m != nil {
+			// PATCHv2 and PATCH-v2 are common spellings of PATCH v2.
+			s.Patch = true
+			if m[1] != "" {
+				s.Version, _ = strconv.Atoi(m[1])
+			}
+			s.Prefixes = append(s.Prefixes, "PATCH")
+			continue
+		}
+
+		s.Prefixes = append(s.Prefixes, field)
+		switch strings.ToUpper(field) {
+		case "RFC":
+			s.RFC = true
+		case "RESEND":
+			s.Resend = true
+		default:
+			// Tree names are lower case (net-next, bpf, 6.1), while other
+			// tags such as "GIT PULL" or "ANNOUNCE" are shouted.
+			if s.Tree == "" && (strings.ToUpper(field) != field || isVersionNumber(field)) {
+				s.Tree = field
+			}
+		}
+	}
+}
+
+func isVersionNumber(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if _, err := strconv.Atoi(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package email
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    PatchSubject
+	}{
+		{
+			subject: "[PATCH RFC net-next v3 2/7] tcp: fix foo",
+			want: PatchSubject{
+				Prefixes: []string{"PATCH", "RFC", "net-next"},
+				Patch:    true, RFC: true, Tree: "net-next",
+				Version: 3, Index: 2, Total: 7,
+				Subject: "tcp: fix foo",
+			},
+		},
+		{
+			subject: "[PATCH] mm: fix leak",
+			want:    PatchSubject{Prefixes: []string{"PATCH"}, Patch: true, Version: 1, Subject: "mm: fix leak"},
+		},
+		{
+			subject: "[PATCH v2 00/12] Rework the frobnicator",
+			want: PatchSubject{
+				Prefixes: []string{"PATCH"}, Patch: true,
+				Version: 2, Index: 0, Total: 12,
+				Subject: "Rework the frobnicator",
+			},
+		},
+		{
+			subject: "[PATCHv4 bpf-next 1/2] bpf: add helper",
+			want: PatchSubject{
+				Prefixes: []string{"PATCH", "bpf-next"}, Patch: true, Tree: "bpf-next",
+				Version: 4, Index: 1, Total: 2,
+				Subject: "bpf: add helper",
+			},
+		},
+		{
+			subject: "[RESEND][PATCH 6.1 3/5] usb: fix",
+			want: PatchSubject{
+				Prefixes: []string{"RESEND", "PATCH", "6.1"}, Patch: true, Resend: true, Tree: "6.1",
+				Version: 1, Index: 3, Total: 5,
+				Subject: "usb: fix",
+			},
+		},
+		{
+			subject: "Re: [PATCH v5 3/4] drm: fix",
+			want: PatchSubject{
+				Prefixes: []string{"PATCH"}, Reply: true, Patch: true,
+				Version: 5, Index: 3, Total: 4,
+				Subject: "drm: fix",
+			},
+		},
+		{
+			subject: "[GIT PULL] Networking for 6.8",
+			want:    PatchSubject{Prefixes: []string{"GIT", "PULL"}, Subject: "Networking for 6.8"},
+		},
+		{
+			subject: "Question about [PATCH] handling",
+			want:    PatchSubject{Subject: "Question about [PATCH] handling"},
+		},
+		{
+			subject: "[PATCH unterminated",
+			want:    PatchSubject{Subject: "[PATCH unterminated"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			if got := ParseSubject(tt.subject); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSubject() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCoverLetter(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    bool
+	}{
+		{"[PATCH v2 0/3] series", true},
+		{"[PATCH v2 1/3] first", false},
+		{"Re: [PATCH v2 0/3] series", false},
+		{"[PATCH] single", false},
+	}
+	for _, tt := range tests {
+		if got := ParseSubject(tt.subject).IsCoverLetter(); got != tt.want {
+			t.Errorf("ParseSubject(%q).IsCoverLetter() = %v, want %v", tt.subject, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package email
+
+import (
+	"bytes"
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// Trailer is a "Key: value" line such as "Reviewed-by: Jane Doe <jane@x>".
+// Name and Email are set for trailers naming a person; Value is always the
+// full text after the colon.
+type Trailer struct {
+	Key   string
+	Name  string
+	Email string
+	Value string
+}
+
+// trailerKeys maps the lower-cased trailer keys patchy records to their
+// canonical spelling. The bool tells whether the value is a person.
+var trailerKeys = map[string]struct {
+	key    string
+	person bool
+}{
+	"signed-off-by": {"Signed-off-by", true},
+	"reviewed-by":   {"Reviewed-by", true},
+	"acked-by":      {"Acked-by", true},
+	"tested-by":     {"Tested-by", true},
+	"reported-by":   {"Reported-by", true},
+	"fixes":         {"Fixes", false},
+	"link":          {"Link", false},
+	"closes":        {"Closes", false},
+	"cc":            {"Cc", true},
+}
+
+var trailerLine = regexp.MustCompile(`^([A-Za-z][A-Za-z-]*):[ \t]*(\S.*?)[ \t]*$`)
+
+// Trailers returns the trailers in the message body.
+func (m *Message) Trailers() []Trailer {
+	return ParseTrailers(m.Body)
+}
+
+// ParseTrailers finds trailers in body. Patches keep them in the commit
+// message above the "---" line, while reviewers put them anywhere in their
+// reply, so the whole text up to the diff or signature is searched. Quoted
+// lines are skipped; they belong to the message being replied to. Of the Cc
+// trailers only those adding the stable list are kept.
+func ParseTrailers(body string) []Trailer {
+	var trailers []Trailer
+	continued := false
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "---" || line == "-- " || strings.HasPrefix(line, "diff --git ") {
+			break
+		}
+		if strings.HasPrefix(strings.TrimLeft(line, " \t"), ">") {
+			continued = false
+			continue
+		}
+
+		// Long values such as a Fixes subject are sometimes wrapped onto
+		// indented lines.
+		if continued && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && strings.TrimSpace(line) != "" {
+			t := &trailers[len(trailers)-1]
+			t.Value += " " + strings.TrimSpace(line)
+			continue
+		}
+
+		continued = false
+		match := trailerLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		known, ok := trailerKeys[strings.ToLower(match[1])]
+		if !ok {
+			continue
+		}
+		t := Trailer{Key: known.key, Value: match[2]}
+		if known.person {
+			t.Name, t.Email = trailerPerson(match[2])
+		}
+		if t.Key == "Cc" && !strings.HasPrefix(strings.ToLower(t.Email), "stable@") {
+			continue
+		}
+		trailers = append(trailers, t)
+		continued = true
+	}
+	return trailers
+}
+
+// trailerPerson parses "Name <email>" with an optional "# comment" after it,
+// as in "Cc: stable@vger.kernel.org # 6.1+".
+func trailerPerson(value string) (string, string) {
+	if i := strings.Index(value, "#"); i >= 0 {
+		value = value[:i]
+	}
+	addrs := parseAddressList(value)
+	if len(addrs) == 0 {
+		return strings.TrimSpace(value), ""
+	}
+	return addrs[0].Name, addrs[0].Email
+}
+
+// String formats t as a trailer line without line ending.
+func (t Trailer) String() string {
+	return t.Key + ": " + t.Value
+}
+
+// AddTrailers returns raw with trailers added to the end of the trailer
+// block of the commit message, the way b4 am folds in review tags. Trailers
+// the message already carries are skipped. Only single-part text messages in
+// 7bit or 8bit encoding are edited; anything else is returned unchanged with
+// ok false.
+func AddTrailers(raw []byte, trailers []Trailer) ([]byte, bool) {
+	header, body := splitMessage(raw)
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err == nil && mediaType != "text/plain" {
+		return raw, false
+	}
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "", "7bit", "8bit":
+	default:
+		return raw, false
+	}
+
+	existing := ParseTrailers(string(body))
+	var add []Trailer
+	for _, t := range trailers {
+		if !hasTrailer(existing, t) {
+			existing = append(existing, t)
+			add = append(add, t)
+		}
+	}
+	if len(add) == 0 {
+		return raw, true
+	}
+
+	eol := "\n"
+	if bytes.Contains(body, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+
+	// Insert above the "---" line git format-patch writes, or above the
+	// diff when it is missing.
+	pos := len(body)
+	prev := ""
+	for rest := body; len(rest) > 0; {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		text := strings.TrimRight(string(line), "\r\n")
+		if text == "---" || strings.HasPrefix(text, "diff --git ") {
+			pos = len(body) - len(rest)
+			break
+		}
+		prev = text
+		rest = rest[len(line):]
+	}
+
+	var b bytes.Buffer
+	b.Write(raw[:len(raw)-len(body)+pos])
+	if pos == len(body) && pos > 0 && body[pos-1] != '\n' {
+		b.WriteString(eol)
+	}
+	if strings.TrimSpace(prev) != "" && !trailerLine.MatchString(prev) {
+		b.WriteString(eol)
+	}
+	for _, t := range add {
+		b.WriteString(t.String())
+		b.WriteString(eol)
+	}
+	b.Write(body[pos:])
+	return b.Bytes(), true
+}
+
+// hasTrailer reports whether t is already in trailers. People are compared
+// by address, everything else by value.
+func hasTrailer(trailers []Trailer, t Trailer) bool {
+	for _, e := range trailers {
+		if !strings.EqualFold(e.Key, t.Key) {
+			continue
+		}
+		if t.Email != "" && strings.EqualFold(e.Email, t.Email) {
+			return true
+		}
+		if strings.EqualFold(strings.TrimSpace(e.Value), strings.TrimSpace(t.Value)) {
+			return true
+		}
+	}
+	return false
+}
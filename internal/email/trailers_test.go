@@ -0,0 +1,111 @@
+package email
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTrailers(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Trailer
+	}{
+		{
+			name: "patch",
+			body: "mm: fix it\n\nReported-by: R <r@x>\n" +
+				"Fixes: 0123456789ab (\"mm: break it\")\n" +
+				"Link: https://lore.kernel.org/r/123@x\n" +
+				"Closes: https://bugzilla.kernel.org/show_bug.cgi?id=1\n" +
+				"Cc: stable@vger.kernel.org # 6.1+\n" +
+				"Cc: Other <o@x>\n" +
+				"signed-off-by: A U Thor <a@x>\n" +
+				"---\n mm/slab.c | 1 +\n\nReviewed-by: not a trailer <n@x>\n",
+			want: []Trailer{
+				{Key: "Reported-by", Name: "R", Email: "r@x", Value: "R <r@x>"},
+				{Key: "Fixes", Value: `0123456789ab ("mm: break it")`},
+				{Key: "Link", Value: "https://lore.kernel.org/r/123@x"},
+				{Key: "Closes", Value: "https://bugzilla.kernel.org/show_bug.cgi?id=1"},
+				{Key: "Cc", Email: "stable@vger.kernel.org", Value: "stable@vger.kernel.org # 6.1+"},
+				{Key: "Signed-off-by", Name: "A U Thor", Email: "a@x", Value: "A U Thor <a@x>"},
+			},
+		},
+		{
+			name: "review reply",
+			body: "On Mon, A wrote:\n> Signed-off-by: A <a@x>\n>  mm/slab.c | 1 +\n\n" +
+				"Looks good to me.\n\nReviewed-by: Jane Q. Public <jane@x>\nTested-by: T <t@x>\n\n-- \nJane\nAcked-by: sig <s@x>\n",
+			want: []Trailer{
+				{Key: "Reviewed-by", Name: "Jane Q. Public", Email: "jane@x", Value: "Jane Q. Public <jane@x>"},
+				{Key: "Tested-by", Name: "T", Email: "t@x", Value: "T <t@x>"},
+			},
+		},
+		{
+			name: "wrapped fixes subject",
+			body: "Fixes: 0123456789ab (\"mm: a very long\n  subject\")\nSigned-off-by: A <a@x>\n",
+			want: []Trailer{
+				{Key: "Fixes", Value: `0123456789ab ("mm: a very long subject")`},
+				{Key: "Signed-off-by", Name: "A", Email: "a@x", Value: "A <a@x>"},
+			},
+		},
+		{
+			name: "none",
+			body: "Just prose: nothing here.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseTrailers(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTrailers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddTrailers(t *testing.T) {
+	reviewed := Trailer{Key: "Reviewed-by", Name: "R", Email: "r@x", Value: "R <r@x>"}
+	acked := Trailer{Key: "Acked-by", Name: "A", Email: "a@x", Value: "A <a@x>"}
+
+	tests := []struct {
+		name     string
+		raw      string
+		trailers []Trailer
+		want     string
+		wantOK   bool
+	}{
+		{
+			name:     "after existing trailers",
+			raw:      "Subject: x\n\nFix.\n\nSigned-off-by: S <s@x>\n---\n a | 1 +\n",
+			trailers: []Trailer{reviewed, acked},
+			want:     "Subject: x\n\nFix.\n\nSigned-off-by: S <s@x>\nReviewed-by: R <r@x>\nAcked-by: A <a@x>\n---\n a | 1 +\n",
+			wantOK:   true,
+		},
+		{
+			name:     "duplicates are skipped",
+			raw:      "Subject: x\n\nFix.\n\nReviewed-by: Other Name <R@X>\n---\n",
+			trailers: []Trailer{reviewed, reviewed},
+			want:     "Subject: x\n\nFix.\n\nReviewed-by: Other Name <R@X>\n---\n",
+			wantOK:   true,
+		},
+		{
+			name:     "new trailer block above the diff",
+			raw:      "Subject: x\r\n\r\nFix.\r\ndiff --git a/a b/a\r\n",
+			trailers: []Trailer{acked},
+			want:     "Subject: x\r\n\r\nFix.\r\n\r\nAcked-by: A <a@x>\r\ndiff --git a/a b/a\r\n",
+			wantOK:   true,
+		},
+		{
+			name:     "base64 body is left alone",
+			raw:      "Subject: x\nContent-Transfer-Encoding: base64\n\nRml4Lg==\n",
+			trailers: []Trailer{acked},
+			want:     "Subject: x\nContent-Transfer-Encoding: base64\n\nRml4Lg==\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := AddTrailers([]byte(tt.raw), tt.trailers)
+			if string(got) != tt.want || ok != tt.wantOK {
+				t.Errorf("AddTrailers() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+// Package mbox reads and writes mailbox files in the mboxo, mboxrd and
+// mboxcl2 variants.
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format selects how message boundaries and quoted "From " lines are handled.
+type Format int
+
+const (
+	// MboxRD quotes every ">*From " line with one more '>'. This is what
+	// lore.kernel.org and public-inbox export.
+	MboxRD Format = iota
+	// MboxO only quotes "From " lines, so ">From " is ambiguous and left as is
+	// apart from the single level of quoting.
+	MboxO
+	// MboxCL2 uses a Content-Length header instead of quoting.
+	MboxCL2
+)
+
+// ParseFormat maps a format name as used on the command line to a Format.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", "mboxrd":
+		return MboxRD, nil
+	case "mboxo", "mbox":
+		return MboxO, nil
+	case "mboxcl2":
+		return MboxCL2, nil
+	}
+	return 0, fmt.Errorf("unknown mbox format %q", name)
+}
+
+func (f Format) String() string {
+	switch f {
+	case MboxRD:
+		return "mboxrd"
+	case MboxO:
+		return "mboxo"
+	case MboxCL2:
+		return "mboxcl2"
+	}
+	return "unknown"
+}
+
+// Message is a single message read from an mbox.
+type Message struct {
+	// Envelope is the "From " separator line without its line ending.
+	Envelope string
+	// Data is the message with mbox quoting undone.
+	Data []byte
+	// Offset is the byte offset of the envelope line in the input.
+	Offset int64
+	// Length is the number of input bytes the message spans, including the
+	// envelope line.
+	Length int64
+}
+
+// Reader streams messages out of an mbox one at a time. Lines of any length
+// are supported.
+type Reader struct {
+	r      *bufio.Reader
+	format Format
+	offset int64
+
+	// envelope holds a separator line that was read while looking for the
+	// end of the previous message.
+	envelope       []byte
+	envelopeOffset int64
+}
+
+// NewReader returns a Reader for the given format.
+func NewReader(r io.Reader, format Format) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 64*1024), format: format}
+}
+
+// NewReaderAt is like NewReader but for input that starts at offset in the
+// underlying file, so reported offsets stay absolute after a seek.
+func NewReaderAt(r io.Reader, format Format, offset int64) *Reader {
+	reader := NewReader(r, format)
+	reader.offset = offset
+	return reader
+}
+
+// Offset returns the number of bytes consumed so far. After Next returns a
+// message, Offset is the position at which reading the following message
+// starts, which makes it a safe place to resume from.
+func (r *Reader) Offset() int64 {
+	if r.envelope != nil {
+		return r.envelopeOffset
+	}
+	return r.offset
+}
+
+// Next returns the next message or io.EOF when the input is exhausted.
+func (r *Reader) Next() (*Message, error) {
+	envelope, start, err := r.nextEnvelope()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		Envelope: string(bytes.TrimRight(envelope, "\r\n")),
+		Offset:   start,
+	}
+
+	if r.format == MboxCL2 {
+		msg.Data, err = r.readContentLength()
+	} else {
+		msg.Data, err = r.readUntilEnvelope()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mbox: reading message at offset %d: %w", start, err)
+	}
+
+	msg.Length = r.Offset() - start
+	return msg, nil
+}
+
+// nextEnvelope returns the next separator line, skipping anything before it.
+func (r *Reader) nextEnvelope() ([]byte, int64, error) {
+	if r.envelope != nil {
+		envelope, offset := r.envelope, r.envelopeOffset
+		r.envelope = nil
+		return envelope, offset, nil
+	}
+
+	for {
+		start := r.offset
+		line, err := r.readLine()
+		if len(line) > 0 && isEnvelope(line) {
+			return line, start, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// readUntilEnvelope reads lines up to the next separator and undoes quoting.
+func (r *Reader) readUntilEnvelope() ([]byte, error) {
+	var data []byte
+	for {
+		start := r.offset
+		line, err := r.readLine()
+		if len(line) > 0 && isEnvelope(line) {
+			r.envelope, r.envelopeOffset = line, start
+			break
+		}
+		data = append(data, r.unquote(line)...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return trimSeparator(data), nil
+}
+
+// readContentLength reads a mboxcl2 message whose body length is given by
+// its Content-Length header. Messages without a usable header fall back to
+// separator scanning.
+func (r *Reader) readContentLength() ([]byte, error) {
+	var data []byte
+	length := int64(-1)
+	for {
+		start := r.offset
+		line, err := r.readLine()
+		if len(line) > 0 && isEnvelope(line) {
+			r.envelope, r.envelopeOffset = line, start
+			return trimSeparator(data), nil
+		}
+		data = append(data, line...)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		trimmed := bytes.TrimRight(line, "\r\n")
+		if len(trimmed) == 0 {
+			break
+		}
+		if key, value, ok := bytes.Cut(trimmed, []byte(":")); ok && strings.EqualFold(string(key), "Content-Length") {
+			if n, err := strconv.ParseInt(strings.TrimSpace(string(value)), 10, 64); err == nil && n >= 0 {
+				length = n
+			}
+		}
+	}
+
+	if length < 0 {
+		rest, err := r.readUntilEnvelope()
+		return append(data, rest...), err
+	}
+
+	body := make([]byte, length)
+	n, err := io.ReadFull(r.r, body)
+	r.offset += int64(n)
+	data = append(data, body[:n]...)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the blank line(s) between the body and the next separator.
+	for {
+		b, err := r.r.Peek(1)
+		if err != nil || (b[0] != '\n' && b[0] != '\r') {
+			return data, nil
+		}
+		r.r.Discard(1)
+		r.offset++
+	}
+}
+
+// readLine reads one line including its terminator, without any length limit.
+func (r *Reader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		line = append(line, chunk...)
+		r.offset += int64(len(chunk))
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == nil {
+			return line, nil
+		}
+		if err == io.EOF && len(line) > 0 {
+			return line, io.EOF
+		}
+		return line, err
+	}
+}
+
+// unquote removes one level of ">From " quoting.
+func (r *Reader) unquote(line []byte) []byte {
+	switch r.format {
+	case MboxRD:
+		i := 0
+		for i < len(line) && line[i] == '>' {
+			i++
+		}
+		if i > 0 && bytes.HasPrefix(line[i:], []byte("From ")) {
+			return line[1:]
+		}
+	case MboxO:
+		if bytes.HasPrefix(line, []byte(">From ")) {
+			return line[1:]
+		}
+	}
+	return line
+}
+
+func isEnvelope(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("From "))
+}
+
+// trimSeparator drops the empty line that mbox writers put between messages.
+func trimSeparator(data []byte) []byte {
+	if bytes.HasSuffix(data, []byte("\r\n\r\n")) {
+		return data[:len(data)-2]
+	}
+	if bytes.HasSuffix(data, []byte("\n\n")) {
+		return data[:len(data)-1]
+	}
+	return data
+}
@@ -0,0 +1,123 @@
+package mbox
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, input string, format Format) []*Message {
+	t.Helper()
+	r := NewReader(strings.NewReader(input), format)
+	var msgs []*Message
+	for {
+		msg, err := r.Next()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestReaderMboxRD(t *testing.T) {
+	input := "From mboxrd@z Thu Jan  1 00:00:00 1970\n" +
+		"Subject: one\n" +
+		"\n" +
+		">From the start\n" +
+		">>From nested\n" +
+		"> From not quoted\n" +
+		"\n" +
+		"From mboxrd@z Thu Jan  1 00:00:00 1970\n" +
+		"Subject: two\n" +
+		"\n" +
+		"last message without trailing separator\n"
+
+	msgs := readAll(t, input, MboxRD)
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+
+	wantFirst := "Subject: one\n\nFrom the start\n>From nested\n> From not quoted\n"
+	if got := string(msgs[0].Data); got != wantFirst {
+		t.Errorf("first message = %q, want %q", got, wantFirst)
+	}
+	if msgs[0].Envelope != "From mboxrd@z Thu Jan  1 00:00:00 1970" {
+		t.Errorf("Envelope = %q", msgs[0].Envelope)
+	}
+	if msgs[0].Offset != 0 {
+		t.Errorf("first Offset = %d, want 0", msgs[0].Offset)
+	}
+
+	secondOffset := int64(strings.LastIndex(input, "From mboxrd"))
+	if msgs[1].Offset != secondOffset {
+		t.Errorf("second Offset = %d, want %d", msgs[1].Offset, secondOffset)
+	}
+	if msgs[0].Offset+msgs[0].Length != secondOffset {
+		t.Errorf("first Length = %d does not reach second message", msgs[0].Length)
+	}
+	if got := string(msgs[1].Data); got != "Subject: two\n\nlast message without trailing separator\n" {
+		t.Errorf("second message = %q", got)
+	}
+}
+
+func TestReaderMboxO(t *testing.T) {
+	input := "From a@b Mon Jan  1 00:00:00 2024\n\n>From here\n>>From there\n"
+	msgs := readAll(t, input, MboxO)
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	if got := string(msgs[0].Data); got != "\nFrom here\n>>From there\n" {
+		t.Errorf("message = %q", got)
+	}
+}
+
+func TestReaderMboxCL2(t *testing.T) {
+	body := "From inside the body is fine\n"
+	input := "From a@b Mon Jan  1 00:00:00 2024\n" +
+		"Subject: cl2\n" +
+		"Content-Length: 29\n" +
+		"\n" +
+		body +
+		"\n" +
+		"From a@b Mon Jan  1 00:00:00 2024\n" +
+		"Subject: no length\n" +
+		"\n" +
+		"body\n"
+
+	msgs := readAll(t, input, MboxCL2)
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+	if got := string(msgs[0].Data); got != "Subject: cl2\nContent-Length: 29\n\n"+body {
+		t.Errorf("first message = %q", got)
+	}
+	if got := string(msgs[1].Data); got != "Subject: no length\n\nbody\n" {
+		t.Errorf("second message = %q", got)
+	}
+}
+
+func TestReaderLongLines(t *testing.T) {
+	long := strings.Repeat("x", 1<<20)
+	input := "From a@b Mon Jan  1 00:00:00 2024\nSubject: long\n\n" + long + "\n"
+	msgs := readAll(t, input, MboxRD)
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	if len(msgs[0].Data) != len("Subject: long\n\n")+len(long)+1 {
+		t.Errorf("long line was truncated: %d bytes", len(msgs[0].Data))
+	}
+}
+
+func TestReaderSkipsLeadingGarbage(t *testing.T) {
+	input := "garbage\nmore garbage\nFrom a@b Mon Jan  1 00:00:00 2024\nSubject: x\n\nbody\n"
+	msgs := readAll(t, input, MboxRD)
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	if msgs[0].Offset != int64(len("garbage\nmore garbage\n")) {
+		t.Errorf("Offset = %d", msgs[0].Offset)
+	}
+}
@@ -0,0 +1,63 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// Envelope is the separator line Writer puts before every message. It is
+// the one public-inbox and b4 use, since the real envelope sender and date
+// are not known for mail read from an archive.
+const Envelope = "From mboxrd@z Thu Jan  1 00:00:00 1970"
+
+// Writer writes messages in mboxrd format.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a Writer writing to w. Call Flush when done.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// WriteMessage writes data as one message. Every ">*From " line gets one
+// more '>' so Reader in MboxRD mode returns data unchanged, apart from a
+// final newline that is added when missing.
+func (w *Writer) WriteMessage(data []byte) error {
+	if _, err := w.w.WriteString(Envelope + "\n"); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		data = data[len(line):]
+
+		if isQuotedFrom(line) {
+			if err := w.w.WriteByte('>'); err != nil {
+				return err
+			}
+		}
+		if _, err := w.w.Write(line); err != nil {
+			return err
+		}
+		if line[len(line)-1] != '\n' {
+			if err := w.w.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+	}
+	// A blank line separates messages.
+	return w.w.WriteByte('\n')
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func isQuotedFrom(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
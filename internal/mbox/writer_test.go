@@ -0,0 +1,44 @@
+package mbox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	messages := []string{
+		"Subject: one\n\nFrom the start\n>From quoted\n>>From nested\n> From not quoted\n",
+		"Subject: two\n\nno final newline",
+		"Subject: three\r\n\r\nFrom crlf\r\n",
+	}
+
+	var b strings.Builder
+	w := NewWriter(&b)
+	for _, m := range messages {
+		if err := w.WriteMessage([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := b.String()
+	if !strings.Contains(out, "\n>From the start\n>>From quoted\n>>>From nested\n> From not quoted\n\n") {
+		t.Errorf("quoting missing in:\n%s", out)
+	}
+
+	msgs := readAll(t, out, MboxRD)
+	if len(msgs) != len(messages) {
+		t.Fatalf("got %d messages, want %d", len(msgs), len(messages))
+	}
+	want := []string{messages[0], messages[1] + "\n", messages[2]}
+	for i, msg := range msgs {
+		if msg.Envelope != Envelope {
+			t.Errorf("message %d envelope = %q", i, msg.Envelope)
+		}
+		if got := string(msg.Data); got != want[i] {
+			t.Errorf("message %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
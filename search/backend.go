@@ -0,0 +1,115 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/meilisearch/meilisearch-go"
+)
+
+// Backend stores Documents and searches them.
+type Backend interface {
+	// Setup prepares the backend for use, for example by configuring the
+	// index. It is safe to call every time the program starts.
+	Setup(ctx context.Context) error
+	// Index adds docs, replacing documents with the same ID.
+	Index(ctx context.Context, docs []Document) error
+	// Delete removes the documents with the given IDs.
+	Delete(ctx context.Context, ids []int64) error
+	Search(ctx context.Context, req *Request) (*Result, error)
+}
+
+// Sort is the order of search results.
+type Sort int
+
+const (
+	SortRelevance Sort = iota
+	SortDateDesc
+	SortDateAsc
+)
+
+// Request is a search. Filters are ANDed. Page counts from 1. Facets are
+// the Document fields to count the values of among all matches.
+type Request struct {
+	Text    string
+	Filters []Filter
+	Sort    Sort
+	Page    int64
+	PerPage int64
+	Facets  []string
+}
+
+// Result is a page of search results.
+type Result struct {
+	Hits           []Hit
+	Total          int64
+	TotalPages     int64
+	ProcessingTime time.Duration
+	Facets         map[string]map[string]int64
+}
+
+// Hit is a matching document. Highlighted is its Text with the matches
+// wrapped in <em> tags.
+type Hit struct {
+	Document
+	Highlighted string
+}
+
+// BackendConfig selects and configures a Backend. Name is meilisearch,
+// postgres or embedded. DB is only used by the postgres backend, Dir only by
+// the embedded one.
+type BackendConfig struct {
+	Name           string
+	MeilisearchURL string
+	DB             db.DBTX
+	Dir            string
+}
+
+// NewBackend returns the backend config asks for. Backends that hold files
+// open, like the embedded one, implement io.Closer.
+func NewBackend(config BackendConfig) (Backend, error) {
+	switch config.Name {
+	case "", "meilisearch":
+		return NewMeilisearch(meilisearch.New(config.MeilisearchURL)), nil
+	case "postgres":
+		if config.DB == nil {
+			return nil, fmt.Errorf("the postgres search backend needs a database")
+		}
+		return NewPostgres(config.DB), nil
+	case "embedded":
+		if config.Dir == "" {
+			return nil, fmt.Errorf("the embedded search backend needs a directory")
+		}
+		return OpenEmbedded(config.Dir)
+	}
+	return nil, fmt.Errorf("unknown search backend %q, want meilisearch, postgres or embedded", config.Name)
+}
+
+// MaxHits is how many hits of a search can be paged through. Meilisearch
+// returns none past its maxTotalHits, which Settings sets to it, so callers
+// should not ask any backend for pages beyond it.
+const MaxHits = 1000
+
+// totalPages returns the number of pages of perPage hits total hits fill.
+func totalPages(total, perPage int64) int64 {
+	if perPage <= 0 {
+		return 0
+	}
+	return (total + perPage - 1) / perPage
+}
+
+// pageBounds returns the range of the hits of page when there are total,
+// with pages before the first treated as the first and those past the end
+// as empty, without overflowing for any page.
+func pageBounds(page, perPage, total int64) (from, to int64) {
+	if page < 1 {
+		page = 1
+	}
+	if perPage <= 0 || total <= 0 || page-1 > (total-1)/perPage {
+		return total, total
+	}
+	from = (page - 1) * perPage
+	return from, from + min(perPage, total-from)
+}
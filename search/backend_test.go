@@ -0,0 +1,30 @@
+package search
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPageBounds(t *testing.T) {
+	for _, tt := range []struct {
+		page, perPage, total int64
+		from, to             int64
+	}{
+		{1, 10, 25, 0, 10},
+		{3, 10, 25, 20, 25},
+		{4, 10, 25, 25, 25},
+		{0, 10, 25, 0, 10},
+		{-5, 10, 25, 0, 10},
+		{1, 10, 0, 0, 0},
+		{1, 0, 25, 25, 25},
+		{math.MaxInt64, 20, 25, 25, 25},
+		{math.MaxInt64/20 + 2, 20, math.MaxInt64, math.MaxInt64, math.MaxInt64},
+		{2, math.MaxInt64, 25, 25, 25},
+		{1, math.MaxInt64, 25, 0, 25},
+	} {
+		from, to := pageBounds(tt.page, tt.perPage, tt.total)
+		if from != tt.from || to != tt.to {
+			t.Errorf("pageBounds(%d, %d, %d) = %d, %d, want %d, %d", tt.page, tt.perPage, tt.total, from, to, tt.from, tt.to)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package search
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/alexmorten/patchy/internal/diff"
+)
+
+// Search engines tokenize text for prose: io_uring_submit_sqe becomes four
+// words and struct sk_buff *skb loses what is code. Message bodies are
+// therefore also indexed split by kind, with the identifiers in them as
+// whole terms, so kernel symbols can be searched for exactly.
+
+// content is a message body split by kind. Diff lines that are neither added
+// nor removed, and hunk headers, only contribute identifiers.
+type content struct {
+	Prose       string
+	Quoted      string
+	Added       string
+	Removed     string
+	Identifiers []string
+}
+
+// callPattern finds identifiers followed by a parenthesis, as in "calls
+// kfree()", which are code even in prose.
+var callPattern = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\(`)
+
+// splitContent splits body into prose, lines quoted with '>', and the lines
+// its diffs add and remove.
+func splitContent(body string) content {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	inDiff := make([]bool, len(lines))
+	var prose, quoted, added, removed, code []string
+	for _, f := range diff.Parse(body).Files {
+		for i := f.StartLine; i < f.EndLine; i++ {
+			inDiff[i] = true
+		}
+		for _, h := range f.Hunks {
+			code = append(code, h.Section)
+			for _, line := range h.Lines {
+				switch {
+				case strings.HasPrefix(line, "+"):
+					added = append(added, line[1:])
+				case strings.HasPrefix(line, "-"):
+					removed = append(removed, line[1:])
+				default:
+					code = append(code, line)
+				}
+			}
+		}
+	}
+	for i, line := range lines {
+		switch {
+		case inDiff[i]:
+		case strings.HasPrefix(line, ">"):
+			quoted = append(quoted, strings.TrimLeft(line, "> "))
+		default:
+			prose = append(prose, line)
+		}
+	}
+
+	code = append(code, added...)
+	code = append(code, removed...)
+	return content{
+		Prose:       strings.Join(prose, "\n"),
+		Quoted:      strings.Join(quoted, "\n"),
+		Added:       strings.Join(added, "\n"),
+		Removed:     strings.Join(removed, "\n"),
+		Identifiers: unique(append(codeIdentifiers(code), proseIdentifiers(append(prose, quoted...))...)),
+	}
+}
+
+// codeIdentifiers returns every identifier in lines of code with its parts.
+func codeIdentifiers(lines []string) []string {
+	var terms []string
+	for _, line := range lines {
+		for _, id := range identifierPattern.FindAllString(line, -1) {
+			terms = append(terms, identifierTerms(id)...)
+		}
+	}
+	return terms
+}
+
+// proseIdentifiers returns the words of prose that can only be identifiers:
+// those with an underscore or in camelCase, and function calls.
+func proseIdentifiers(lines []string) []string {
+	var terms []string
+	for _, line := range lines {
+		for _, id := range identifierPattern.FindAllString(line, -1) {
+			if strings.Contains(id, "_") || len(identifierParts(id)) > 1 {
+				terms = append(terms, identifierTerms(id)...)
+			}
+		}
+		for _, m := range callPattern.FindAllStringSubmatch(line, -1) {
+			terms = append(terms, identifierTerms(m[1])...)
+		}
+	}
+	return terms
+}
+
+// identifierTerms returns id and its parts. Single letters are dropped.
+func identifierTerms(id string) []string {
+	var terms []string
+	if len(id) > 1 {
+		terms = append(terms, id)
+	}
+	for _, p := range identifierParts(id) {
+		if len(p) > 1 && p != id {
+			terms = append(terms, p)
+		}
+	}
+	return terms
+}
+
+// identifierParts splits id at underscores and camelCase humps:
+// io_uring_submit_sqe into io, uring, submit and sqe, and parseHTTPHeader
+// into parse, HTTP and Header.
+func identifierParts(id string) []string {
+	var parts []string
+	for _, piece := range strings.Split(id, "_") {
+		start := 0
+		for i := 1; i < len(piece); i++ {
+			if !isUpper(piece[i]) {
+				continue
+			}
+			prev := piece[i-1]
+			if !isUpper(prev) || (i+1 < len(piece) && isLower(piece[i+1])) {
+				parts = append(parts, piece[start:i])
+				start = i
+			}
+		}
+		if start < len(piece) {
+			parts = append(parts, piece[start:])
+		}
+	}
+	return parts
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+func isLower(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
@@ -0,0 +1,55 @@
+package search
+
+import (
+	"fmt"
+	"testing"
+)
+
+const contentPatch = `On Mon, Jane wrote:
+> Does io_uring_submit_sqe() leak?
+
+Yes, the struct sk_buff *skb is never freed. Fix it in parseHTTPHeader.
+---
+diff --git a/io_uring/submit.c b/io_uring/submit.c
+--- a/io_uring/submit.c
++++ b/io_uring/submit.c
+@@ -1,2 +1,2 @@ int io_submit_sqes(struct io_ring_ctx *ctx)
+ 	struct sk_buff *skb;
+-	skb_leak(skb);
++	kfree_skb(skb);
+--
+2.43.0
+`
+
+func TestSplitContent(t *testing.T) {
+	c := splitContent(contentPatch)
+	for _, tt := range []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"prose", c.Prose, "On Mon, Jane wrote:\n\nYes, the struct sk_buff *skb is never freed. Fix it in parseHTTPHeader.\n---\n--\n2.43.0\n"},
+		{"quoted", c.Quoted, "Does io_uring_submit_sqe() leak?"},
+		{"added", c.Added, "\tkfree_skb(skb);"},
+		{"removed", c.Removed, "\tskb_leak(skb);"},
+		{"identifiers", fmt.Sprint(c.Identifiers), "[int io_submit_sqes io submit sqes struct io_ring_ctx ring ctx sk_buff sk buff skb kfree_skb kfree skb_leak leak parseHTTPHeader parse HTTP Header io_uring_submit_sqe uring sqe]"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestIdentifierParts(t *testing.T) {
+	for id, want := range map[string]string{
+		"io_uring_submit_sqe": "[io uring submit sqe]",
+		"parseHTTPHeader":     "[parse HTTP Header]",
+		"__init":              "[init]",
+		"sha256Sum":           "[sha256 Sum]",
+		"IOMMU":               "[IOMMU]",
+	} {
+		if got := fmt.Sprint(identifierParts(id)); got != want {
+			t.Errorf("identifierParts(%q) = %s, want %s", id, got, want)
+		}
+	}
+}
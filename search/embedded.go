@@ -0,0 +1,407 @@
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Embedded is a Backend that needs no other service. Its directory holds
+// documents.log, an append-only log of JSON records with the document texts,
+// and a snapshot of the inverted index from which it is loaded on open. The
+// whole inverted index is kept in memory, which takes several times the size
+// of the indexed text, so it suits a single list archive rather than all of
+// lore.kernel.org; larger deployments should use Meilisearch or Postgres.
+// Only one process may open a directory at a time: OpenEmbedded fails while
+// another holds the lock file in it.
+type Embedded struct {
+	mu   sync.RWMutex
+	dir  string
+	lock *os.File
+	id   string
+	log  *os.File
+	size int64
+	dead int64
+	// snapshotSize is the size of the log the snapshot on disk was saved at.
+	snapshotSize int64
+	docs         map[int64]*embeddedDoc
+	postings     map[string]map[int64][]int32
+	length       int64
+	// terms is the sorted keys of postings, nil when they changed. Searches
+	// only hold mu for reading, so termsMu guards it.
+	termsMu sync.Mutex
+	terms   []string
+}
+
+// embeddedDoc is an indexed document. Its Text is only on disk, in the
+// record at offset.
+type embeddedDoc struct {
+	Document
+	offset int64
+	size   int64
+	// tokens is the number of positions, subjectTokens the number of them
+	// in the subject. terms are the distinct terms, to remove the postings.
+	tokens        int
+	subjectTokens int
+	terms         []string
+}
+
+// embeddedRecord is a line of documents.log: a document added or replaced,
+// or the ID of one deleted.
+type embeddedRecord struct {
+	Document *Document `json:",omitempty"`
+	Delete   int64     `json:",omitempty"`
+}
+
+const (
+	embeddedLogName = "documents.log"
+	embeddedIDName  = "id"
+	embeddedLock    = "lock"
+	// subjectGap keeps phrases from matching across subject and text.
+	subjectGap = 10
+	// A log is compacted once this many bytes, and half of it, are records
+	// that were replaced or deleted.
+	compactThreshold = 64 << 20
+)
+
+// OpenEmbedded opens the index in dir, creating it if needed.
+func OpenEmbedded(dir string) (*Embedded, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := lockFile(filepath.Join(dir, embeddedLock))
+	if err != nil {
+		return nil, err
+	}
+	e, err := openEmbedded(dir, lock)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+func openEmbedded(dir string, lock *os.File) (*Embedded, error) {
+	id, err := embeddedID(dir)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, embeddedLogName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	e := &Embedded{
+		dir:      dir,
+		lock:     lock,
+		id:       id,
+		log:      f,
+		docs:     make(map[int64]*embeddedDoc),
+		postings: make(map[string]map[int64][]int32),
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	offset, err := e.loadSnapshot(info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := e.load(offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := e.maybeCompact(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := e.maybeSnapshot(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// embeddedID returns the random ID of the index in dir, so the indexer keeps
+// a separate cursor for every index it fills.
+func embeddedID(dir string) (string, error) {
+	path := filepath.Join(dir, embeddedIDName)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	return id, os.WriteFile(path, []byte(id+"\n"), 0o644)
+}
+
+// load replays the log from offset. A last record that was only partly
+// written before a crash is cut off.
+func (e *Embedded) load(offset int64) error {
+	if _, err := e.log.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(e.log)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				if err := e.log.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var record embeddedRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("%s at offset %d: %w", embeddedLogName, offset, err)
+		}
+		e.apply(record, offset, int64(len(line)))
+		offset += int64(len(line))
+	}
+	e.size = offset
+	_, err := e.log.Seek(offset, io.SeekStart)
+	return err
+}
+
+// IndexerName makes the indexer keep a cursor per index directory.
+func (e *Embedded) IndexerName() string {
+	return "embedded:" + e.id
+}
+
+func (e *Embedded) Setup(ctx context.Context) error {
+	return nil
+}
+
+// Index stores docs without the parts of Text they repeat, which the
+// embedded index does not search separately.
+func (e *Embedded) Index(ctx context.Context, docs []Document) error {
+	records := make([]embeddedRecord, len(docs))
+	for i := range docs {
+		d := docs[i]
+		d.Prose, d.Quoted, d.DiffAdded, d.DiffRemoved = "", "", "", ""
+		records[i] = embeddedRecord{Document: &d}
+	}
+	return e.write(records)
+}
+
+func (e *Embedded) Delete(ctx context.Context, ids []int64) error {
+	records := make([]embeddedRecord, len(ids))
+	for i, id := range ids {
+		records[i] = embeddedRecord{Delete: id}
+	}
+	return e.write(records)
+}
+
+// Close saves a snapshot if the log changed since the last and closes it.
+func (e *Embedded) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var err error
+	if e.size != e.snapshotSize {
+		err = e.saveSnapshot()
+	}
+	if logErr := e.log.Close(); err == nil {
+		err = logErr
+	}
+	if lockErr := e.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+// write appends records to the log, syncs it and then applies them.
+func (e *Embedded) write(records []embeddedRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	sizes := make([]int64, len(records))
+	for i, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+		sizes[i] = int64(len(data) + 1)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.log.Write(buf.Bytes()); err != nil {
+		// Drop what might have been written, so the log stays whole.
+		e.log.Truncate(e.size)
+		e.log.Seek(e.size, io.SeekStart)
+		return err
+	}
+	if err := e.log.Sync(); err != nil {
+		return err
+	}
+	offset := e.size
+	for i, record := range records {
+		e.apply(record, offset, sizes[i])
+		offset += sizes[i]
+	}
+	e.size = offset
+	if err := e.maybeCompact(); err != nil {
+		return err
+	}
+	return e.maybeSnapshot()
+}
+
+// apply adds a record read from or written to the log at offset.
+func (e *Embedded) apply(record embeddedRecord, offset, size int64) {
+	if record.Document == nil {
+		e.remove(record.Delete)
+		e.dead += size
+		return
+	}
+	e.remove(record.Document.ID)
+	e.add(*record.Document, offset, size)
+}
+
+func (e *Embedded) add(d Document, offset, size int64) {
+	doc := &embeddedDoc{offset: offset, size: size}
+	positions := make(map[string][]int32)
+	subject := tokenize(d.Subject)
+	for _, t := range subject {
+		positions[t.Term] = append(positions[t.Term], int32(t.Position))
+	}
+	doc.subjectTokens = len(subject)
+	text := tokenize(d.Text)
+	for _, t := range text {
+		positions[t.Term] = append(positions[t.Term], int32(len(subject)+subjectGap+t.Position))
+	}
+	doc.tokens = len(subject) + len(text)
+
+	for term, p := range positions {
+		docs, ok := e.postings[term]
+		if !ok {
+			docs = make(map[int64][]int32)
+			e.postings[term] = docs
+			e.terms = nil
+		}
+		docs[d.ID] = p
+		doc.terms = append(doc.terms, term)
+	}
+
+	d.Text = ""
+	doc.Document = d
+	e.docs[d.ID] = doc
+	e.length += int64(doc.tokens)
+}
+
+func (e *Embedded) remove(id int64) {
+	doc, ok := e.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		docs := e.postings[term]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(e.postings, term)
+			e.terms = nil
+		}
+	}
+	delete(e.docs, id)
+	e.length -= int64(doc.tokens)
+	e.dead += doc.size
+}
+
+// text reads the Text of doc from the log.
+func (e *Embedded) text(doc *embeddedDoc) (string, error) {
+	data := make([]byte, doc.size)
+	if _, err := e.log.ReadAt(data, doc.offset); err != nil {
+		return "", err
+	}
+	var record embeddedRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return "", err
+	}
+	if record.Document == nil {
+		return "", fmt.Errorf("%s at offset %d is not document %d", embeddedLogName, doc.offset, doc.ID)
+	}
+	return record.Document.Text, nil
+}
+
+// maybeCompact rewrites the log with only the current documents once enough
+// of it is dead.
+func (e *Embedded) maybeCompact() error {
+	if e.dead < compactThreshold || e.dead < e.size/2 {
+		return nil
+	}
+	path := filepath.Join(e.dir, embeddedLogName)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	offsets := make(map[int64]int64, len(e.docs))
+	var offset int64
+	for id, doc := range e.docs {
+		data := make([]byte, doc.size)
+		if _, err := e.log.ReadAt(data, doc.offset); err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			tmp.Close()
+			return err
+		}
+		offsets[id] = offset
+		offset += doc.size
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := e.removeSnapshot(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	e.log.Close()
+	e.log = tmp
+	for id, doc := range e.docs {
+		doc.offset = offsets[id]
+	}
+	e.size = offset
+	e.dead = 0
+	if _, err := e.log.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	return e.saveSnapshot()
+}
@@ -0,0 +1,480 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BM25 parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// subjectWeight counts a word in the subject as this many in the text.
+	subjectWeight = 2
+	// maxExpansions limits the terms a prefix matches.
+	maxExpansions = 100
+	// expansionWeight scales the score of a term a prefix expanded to.
+	expansionWeight = 0.5
+)
+
+// embeddedPart is a word, a "quoted phrase" or an identifier like io_uring,
+// which matches as a phrase of its parts. The last term is a prefix if the
+// word ends in * or is the last one of the query. Exclude is set for
+// -word.
+type embeddedPart struct {
+	Terms   []string
+	Prefix  bool
+	Exclude bool
+}
+
+// parseEmbeddedQuery splits text into parts. Words with no letters or digits
+// are dropped.
+func parseEmbeddedQuery(text string) []embeddedPart {
+	var parts []embeddedPart
+	words := splitQuery(text)
+	for i, word := range words {
+		var part embeddedPart
+		if len(word) > 1 && strings.HasPrefix(word, "-") {
+			part.Exclude = true
+			word = word[1:]
+		}
+		quoted := len(word) >= 2 && strings.HasPrefix(word, `"`) && strings.HasSuffix(word, `"`)
+		if !quoted {
+			part.Prefix = strings.HasSuffix(word, "*") || (i == len(words)-1 && !part.Exclude)
+		}
+		for _, t := range tokenize(word) {
+			part.Terms = append(part.Terms, t.Term)
+		}
+		if len(part.Terms) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// embeddedMatch is a document matching a part, with its score and the terms
+// to highlight in it.
+type embeddedMatch struct {
+	Score float64
+	Terms []string
+}
+
+func (e *Embedded) Search(ctx context.Context, req *Request) (*Result, error) {
+	start := time.Now()
+	for _, f := range req.Filters {
+		for _, c := range f.All {
+			if _, ok := documentField(&Document{}, c.Field); !ok {
+				return nil, fmt.Errorf("unknown filter field %s", c.Field)
+			}
+		}
+	}
+	for _, field := range req.Facets {
+		if _, ok := documentField(&Document{}, field); !ok {
+			return nil, fmt.Errorf("unknown facet %s", field)
+		}
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	matches := e.match(parseEmbeddedQuery(req.Text))
+	var ids []int64
+	for id, doc := range e.docs {
+		if matches != nil && matches[id] == nil {
+			continue
+		}
+		if matchesFilters(&doc.Document, req.Filters) {
+			ids = append(ids, id)
+		}
+	}
+
+	result := &Result{
+		Total:      int64(len(ids)),
+		TotalPages: totalPages(int64(len(ids)), req.PerPage),
+		Facets:     make(map[string]map[string]int64),
+	}
+	for _, field := range req.Facets {
+		counts := make(map[string]int64)
+		for _, id := range ids {
+			value, _ := documentField(&e.docs[id].Document, field)
+			for _, v := range facetValues(value) {
+				counts[v]++
+			}
+		}
+		result.Facets[field] = counts
+	}
+
+	e.sort(ids, matches, req.Sort)
+	from, to := pageBounds(req.Page, req.PerPage, int64(len(ids)))
+	result.Hits = make([]Hit, 0, to-from)
+	for _, id := range ids[from:to] {
+		doc := e.docs[id]
+		text, err := e.text(doc)
+		if err != nil {
+			return nil, err
+		}
+		h := Hit{Document: doc.Document}
+		h.Text = text
+		h.Highlighted = text
+		if m := matches[id]; m != nil {
+			terms := make(map[string]bool, len(m.Terms))
+			for _, term := range m.Terms {
+				terms[term] = true
+			}
+			h.Highlighted = highlight(text, terms)
+		}
+		result.Hits = append(result.Hits, h)
+	}
+	result.ProcessingTime = time.Since(start)
+	return result, nil
+}
+
+// match returns the documents matching all parts that are not excluded and
+// none that are. Without parts it returns nil, which matches everything.
+func (e *Embedded) match(parts []embeddedPart) map[int64]*embeddedMatch {
+	if len(parts) == 0 {
+		return nil
+	}
+	var matches map[int64]*embeddedMatch
+	positive := false
+	for _, part := range parts {
+		if part.Exclude {
+			continue
+		}
+		found := e.matchPart(part)
+		if !positive {
+			positive = true
+			matches = found
+			if matches == nil {
+				matches = make(map[int64]*embeddedMatch)
+			}
+			continue
+		}
+		for id, m := range matches {
+			f, ok := found[id]
+			if !ok {
+				delete(matches, id)
+				continue
+			}
+			m.Score += f.Score
+			m.Terms = append(m.Terms, f.Terms...)
+		}
+	}
+	if !positive {
+		// Only exclusions: start from every document.
+		matches = make(map[int64]*embeddedMatch, len(e.docs))
+		for id := range e.docs {
+			matches[id] = &embeddedMatch{}
+		}
+	}
+	for _, part := range parts {
+		if !part.Exclude {
+			continue
+		}
+		for id := range e.matchPart(part) {
+			delete(matches, id)
+		}
+	}
+	return matches
+}
+
+// matchPart returns the documents containing part, scored with BM25. A
+// prefix scores as the best of the terms it expands to.
+func (e *Embedded) matchPart(part embeddedPart) map[int64]*embeddedMatch {
+	// candidates[i] are the terms the i-th term of part matches.
+	candidates := make([][]string, len(part.Terms))
+	for i, term := range part.Terms {
+		if part.Prefix && i == len(part.Terms)-1 {
+			candidates[i] = e.expand(term)
+		} else if _, ok := e.postings[term]; ok {
+			candidates[i] = []string{term}
+		}
+		if len(candidates[i]) == 0 {
+			return nil
+		}
+	}
+
+	matches := make(map[int64]*embeddedMatch)
+	for _, term := range candidates[0] {
+		for id := range e.postings[term] {
+			if _, ok := matches[id]; !ok {
+				e.matchDocument(id, part.Terms, candidates, matches)
+			}
+		}
+	}
+	return matches
+}
+
+// matchDocument adds id to matches if it contains the terms of a part in a
+// row, one of candidates[i] for each.
+func (e *Embedded) matchDocument(id int64, terms []string, candidates [][]string, matches map[int64]*embeddedMatch) {
+	m := &embeddedMatch{}
+	var positions [][]int32
+	for i, termCandidates := range candidates {
+		var best float64
+		var found []int32
+		for _, term := range termCandidates {
+			p, ok := e.postings[term][id]
+			if !ok {
+				continue
+			}
+			score := e.bm25(term, id, p)
+			if term != terms[i] {
+				score *= expansionWeight
+			}
+			best = max(best, score)
+			found = append(found, p...)
+			m.Terms = append(m.Terms, term)
+		}
+		if found == nil {
+			return
+		}
+		m.Score += best
+		positions = append(positions, found)
+	}
+	if len(positions) > 1 && !inARow(positions) {
+		return
+	}
+	matches[id] = m
+}
+
+// inARow reports whether there is a position in positions[0] that is followed
+// by one in each of the other lists.
+func inARow(positions [][]int32) bool {
+	next := make(map[int32]bool)
+	for _, p := range positions[0] {
+		next[p+1] = true
+	}
+	for _, list := range positions[1:] {
+		found := make(map[int32]bool)
+		for _, p := range list {
+			if next[p] {
+				found[p+1] = true
+			}
+		}
+		if len(found) == 0 {
+			return false
+		}
+		next = found
+	}
+	return true
+}
+
+// expand returns the terms starting with prefix, the shortest first. The
+// sorted list of terms is rebuilt after writes added or removed any.
+func (e *Embedded) expand(prefix string) []string {
+	e.termsMu.Lock()
+	if e.terms == nil {
+		e.terms = make([]string, 0, len(e.postings))
+		for term := range e.postings {
+			e.terms = append(e.terms, term)
+		}
+		sort.Strings(e.terms)
+	}
+	terms := e.terms
+	e.termsMu.Unlock()
+
+	var expanded []string
+	for i := sort.SearchStrings(terms, prefix); i < len(terms) && strings.HasPrefix(terms[i], prefix); i++ {
+		expanded = append(expanded, terms[i])
+	}
+	sort.SliceStable(expanded, func(i, j int) bool { return len(expanded[i]) < len(expanded[j]) })
+	if len(expanded) > maxExpansions {
+		expanded = expanded[:maxExpansions]
+	}
+	return expanded
+}
+
+// bm25 scores term, found at positions in document id.
+func (e *Embedded) bm25(term string, id int64, positions []int32) float64 {
+	n := float64(len(e.docs))
+	df := float64(len(e.postings[term]))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+	doc := e.docs[id]
+	var tf float64
+	for _, p := range positions {
+		if int(p) < doc.subjectTokens {
+			tf += subjectWeight
+		} else {
+			tf++
+		}
+	}
+	avg := float64(e.length) / n
+	if avg == 0 {
+		avg = 1
+	}
+	return idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(doc.tokens)/avg))
+}
+
+// sort orders ids by score, or by date for SortDate* and searches without
+// text. Documents without a date come last, ties go to the newer document.
+func (e *Embedded) sort(ids []int64, matches map[int64]*embeddedMatch, order Sort) {
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := e.docs[ids[i]], e.docs[ids[j]]
+		if order == SortRelevance && matches != nil {
+			if sa, sb := matches[a.ID].Score, matches[b.ID].Score; sa != sb {
+				return sa > sb
+			}
+		}
+		switch {
+		case a.SentAt == nil && b.SentAt == nil:
+		case a.SentAt == nil:
+			return false
+		case b.SentAt == nil:
+			return true
+		case *a.SentAt != *b.SentAt:
+			return (*a.SentAt < *b.SentAt) == (order == SortDateAsc)
+		}
+		if order == SortDateAsc {
+			return a.ID < b.ID
+		}
+		return a.ID > b.ID
+	})
+}
+
+// matchesFilters reports whether d passes all filters. Like in Meilisearch, a
+// negated filter also passes documents that lack the field.
+func matchesFilters(d *Document, filters []Filter) bool {
+	for _, f := range filters {
+		all := true
+		for _, c := range f.All {
+			value, _ := documentField(d, c.Field)
+			if !compareValue(value, c.Op, c.Value) {
+				all = false
+				break
+			}
+		}
+		if all == f.Not {
+			return false
+		}
+	}
+	return true
+}
+
+// documentField returns the value of field in d, nil if d lacks it, and
+// whether field exists.
+func documentField(d *Document, field string) (any, bool) {
+	switch field {
+	case "ID":
+		return d.ID, true
+	case "Url":
+		return d.Url, true
+	case "MessageID":
+		return d.MessageID, true
+	case "Subject":
+		return d.Subject, true
+	case "IsPatch":
+		return d.IsPatch, true
+	case "PatchPrefixes":
+		return d.PatchPrefixes, true
+	case "PatchTree":
+		return d.PatchTree, true
+	case "PatchVersion":
+		return int64(d.PatchVersion), true
+	case "PatchIndex":
+		return int64(d.PatchIndex), true
+	case "PatchTotal":
+		return int64(d.PatchTotal), true
+	case "FromTerms":
+		return d.FromTerms, true
+	case "ToTerms":
+		return d.ToTerms, true
+	case "CcTerms":
+		return d.CcTerms, true
+	case "SubjectWords":
+		return d.SubjectWords, true
+	case "DiffFiles":
+		return d.DiffFiles, true
+	case "HunkHeaders":
+		return d.HunkHeaders, true
+	case "SentAt":
+		if d.SentAt == nil {
+			return nil, true
+		}
+		return *d.SentAt, true
+	case "TrailerKeys":
+		return d.TrailerKeys, true
+	case "Identifiers":
+		return d.Identifiers, true
+	}
+	return nil, false
+}
+
+// compareValue applies a Condition to a value returned by documentField.
+func compareValue(value any, op string, want any) bool {
+	switch v := value.(type) {
+	case []string:
+		s, ok := want.(string)
+		if !ok || op != "=" {
+			return false
+		}
+		for _, item := range v {
+			if item == s {
+				return true
+			}
+		}
+		return false
+	case string:
+		s, ok := want.(string)
+		return ok && compareOrdered(strings.Compare(v, s), op)
+	case int64:
+		n, ok := want.(int64)
+		if !ok {
+			i, isInt := want.(int)
+			n, ok = int64(i), isInt
+		}
+		return ok && compareOrdered(compareInt(v, n), op)
+	case bool:
+		b, ok := want.(bool)
+		return ok && op == "=" && v == b
+	}
+	return false
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareOrdered turns the result of a comparison into that of op.
+func compareOrdered(cmp int, op string) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// facetValues returns the values of a field as Meilisearch counts them.
+func facetValues(value any) []string {
+	switch v := value.(type) {
+	case []string:
+		return v
+	case string:
+		return []string{v}
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package search
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Tokenizing the whole log again on every start takes long for a large
+// archive, so the postings are also saved to a snapshot of the index as it
+// was at some size of the log. Opening loads it and replays only the records
+// after that.
+
+const (
+	embeddedSnapshotName = "postings"
+	// The snapshot is rewritten once the log grew by this many bytes.
+	snapshotThreshold = 64 << 20
+)
+
+// embeddedSnapshot is the index as of the first LogSize bytes of the log.
+type embeddedSnapshot struct {
+	LogSize int64
+	Dead    int64
+	Docs    []embeddedSnapshotDoc
+}
+
+// embeddedSnapshotDoc is an embeddedDoc with the positions of each of its
+// terms, from which the postings are rebuilt.
+type embeddedSnapshotDoc struct {
+	Document      Document
+	Offset        int64
+	Size          int64
+	Tokens        int
+	SubjectTokens int
+	Terms         []string
+	Positions     [][]int32
+}
+
+// loadSnapshot loads the snapshot if there is one that fits the log and
+// returns the offset to replay the log from.
+func (e *Embedded) loadSnapshot(logSize int64) (int64, error) {
+	f, err := os.Open(filepath.Join(e.dir, embeddedSnapshotName))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var snapshot embeddedSnapshot
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&snapshot); err != nil || snapshot.LogSize > logSize {
+		// It is only a cache, so replay the whole log instead.
+		return 0, nil
+	}
+	for _, s := range snapshot.Docs {
+		doc := &embeddedDoc{
+			Document:      s.Document,
+			offset:        s.Offset,
+			size:          s.Size,
+			tokens:        s.Tokens,
+			subjectTokens: s.SubjectTokens,
+			terms:         s.Terms,
+		}
+		for i, term := range s.Terms {
+			docs, ok := e.postings[term]
+			if !ok {
+				docs = make(map[int64][]int32)
+				e.postings[term] = docs
+			}
+			docs[doc.ID] = s.Positions[i]
+		}
+		e.docs[doc.ID] = doc
+		e.length += int64(doc.tokens)
+	}
+	e.dead = snapshot.Dead
+	e.snapshotSize = snapshot.LogSize
+	return snapshot.LogSize, nil
+}
+
+// maybeSnapshot saves a snapshot once the log grew enough since the last.
+func (e *Embedded) maybeSnapshot() error {
+	if e.size-e.snapshotSize < snapshotThreshold {
+		return nil
+	}
+	return e.saveSnapshot()
+}
+
+// saveSnapshot writes the snapshot to a temporary file and renames it, so a
+// crash leaves the previous one.
+func (e *Embedded) saveSnapshot() error {
+	snapshot := embeddedSnapshot{
+		LogSize: e.size,
+		Dead:    e.dead,
+		Docs:    make([]embeddedSnapshotDoc, 0, len(e.docs)),
+	}
+	for id, doc := range e.docs {
+		positions := make([][]int32, len(doc.terms))
+		for i, term := range doc.terms {
+			positions[i] = e.postings[term][id]
+		}
+		snapshot.Docs = append(snapshot.Docs, embeddedSnapshotDoc{
+			Document:      doc.Document,
+			Offset:        doc.offset,
+			Size:          doc.size,
+			Tokens:        doc.tokens,
+			SubjectTokens: doc.subjectTokens,
+			Terms:         doc.terms,
+			Positions:     positions,
+		})
+	}
+
+	path := filepath.Join(e.dir, embeddedSnapshotName)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	if err := gob.NewEncoder(w).Encode(&snapshot); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	e.snapshotSize = e.size
+	return nil
+}
+
+// removeSnapshot deletes the snapshot before the log is rewritten, as its
+// offsets would not fit the new one.
+func (e *Embedded) removeSnapshot() error {
+	err := os.Remove(filepath.Join(e.dir, embeddedSnapshotName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	e.snapshotSize = 0
+	return nil
+}
@@ -0,0 +1,283 @@
+package search
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func int64p(v int64) *int64 {
+	return &v
+}
+
+var embeddedDocs = []Document{
+	{ID: 1, Subject: "[PATCH v2 1/3] io_uring: add submit helper", Text: "Add io_uring_submit_sqe to submit a single sqe.",
+		IsPatch: true, PatchVersion: 2, PatchIndex: 1, PatchTotal: 3, PatchPrefixes: []string{"PATCH"}, FromTerms: []string{"axboe@kernel.dk"}, SentAt: int64p(300)},
+	{ID: 2, Subject: "Re: net: fix skb leak", Text: "The struct sk_buff *skb is leaked when uring is not used. io_uring io_uring io_uring.",
+		FromTerms: []string{"davem@davemloft.net"}, SentAt: int64p(200)},
+	{ID: 3, Subject: "mm: reclaim tweaks", Text: "Nothing about rings here, only memory reclaim and ring buffers.",
+		IsPatch: true, PatchVersion: 1, PatchPrefixes: []string{"PATCH", "RFC"}, SentAt: int64p(100)},
+}
+
+func openTestEmbedded(t *testing.T, dir string) *Embedded {
+	t.Helper()
+	e, err := OpenEmbedded(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func searchIDs(t *testing.T, e *Embedded, req *Request) []int64 {
+	t.Helper()
+	if req.PerPage == 0 {
+		req.PerPage = 10
+	}
+	result, err := e.Search(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int64{}
+	for _, h := range result.Hits {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
+
+func TestEmbeddedSearch(t *testing.T) {
+	e := openTestEmbedded(t, t.TempDir())
+	if err := e.Index(context.Background(), embeddedDocs); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		text string
+		want []int64
+	}{
+		// The subject match of 1 outweighs the repetitions in 2.
+		{"io_uring", []int64{1, 2}},
+		{"uring", []int64{2, 1}},
+		{`"sk_buff skb"`, []int64{2}},
+		{`"skb sk_buff"`, []int64{}},
+		{"reclai", []int64{3}},
+		{"rec* memory", []int64{3}},
+		{"ring", []int64{3}},
+		{"io_uring -skb", []int64{1}},
+		{"-skb", []int64{1, 3}},
+		{"", []int64{1, 2, 3}},
+		{"!!", []int64{1, 2, 3}},
+	} {
+		if got := searchIDs(t, e, &Request{Text: tt.text}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+
+	if got := searchIDs(t, e, &Request{Text: "uring", Sort: SortDateAsc}); !reflect.DeepEqual(got, []int64{2, 1}) {
+		t.Errorf("search sorted by date = %v, want [2 1]", got)
+	}
+	if got := searchIDs(t, e, &Request{Page: 2, PerPage: 2}); !reflect.DeepEqual(got, []int64{3}) {
+		t.Errorf("second page = %v, want [3]", got)
+	}
+	if got := searchIDs(t, e, &Request{Page: math.MaxInt64, PerPage: 20}); len(got) != 0 {
+		t.Errorf("last possible page = %v, want none", got)
+	}
+}
+
+func TestEmbeddedFilters(t *testing.T) {
+	e := openTestEmbedded(t, t.TempDir())
+	if err := e.Index(context.Background(), embeddedDocs); err != nil {
+		t.Fatal(err)
+	}
+
+	query, err := ParseQuery("f:axboe@kernel.dk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		filters []Filter
+		want    []int64
+	}{
+		{query.Filters, []int64{1}},
+		{[]Filter{Equals("PatchPrefixes", "RFC")}, []int64{3}},
+		{[]Filter{{All: []Condition{{Field: "SentAt", Op: ">=", Value: int64(200)}}}}, []int64{1, 2}},
+		{[]Filter{{All: []Condition{{Field: "IsPatch", Op: "=", Value: true}, {Field: "PatchVersion", Op: ">", Value: int64(1)}}, Not: true}}, []int64{2, 3}},
+	} {
+		if got := searchIDs(t, e, &Request{Filters: tt.filters}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("filters %v = %v, want %v", tt.filters, got, tt.want)
+		}
+	}
+
+	result, err := e.Search(context.Background(), &Request{PerPage: 10, Facets: []string{"IsPatch", "PatchPrefixes", "PatchVersion"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFacets := map[string]map[string]int64{
+		"IsPatch":       {"true": 2, "false": 1},
+		"PatchPrefixes": {"PATCH": 2, "RFC": 1},
+		"PatchVersion":  {"2": 1, "0": 1, "1": 1},
+	}
+	if !reflect.DeepEqual(result.Facets, wantFacets) {
+		t.Errorf("facets = %v, want %v", result.Facets, wantFacets)
+	}
+
+	if _, err := e.Search(context.Background(), &Request{Filters: []Filter{Equals("Raw", "x")}}); err == nil {
+		t.Error("filter on unknown field succeeded, want error")
+	}
+}
+
+func TestEmbeddedHighlight(t *testing.T) {
+	e := openTestEmbedded(t, t.TempDir())
+	if err := e.Index(context.Background(), embeddedDocs[1:2]); err != nil {
+		t.Fatal(err)
+	}
+	result, err := e.Search(context.Background(), &Request{Text: `"sk_buff" lea`, PerPage: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Hits) != 1 {
+		t.Fatalf("got %d hits, want 1", len(result.Hits))
+	}
+	hit := result.Hits[0]
+	want := "The struct <em>sk</em>_<em>buff</em> *skb is <em>leaked</em> when uring is not used. io_uring io_uring io_uring."
+	if hit.Highlighted != want {
+		t.Errorf("highlighted = %q, want %q", hit.Highlighted, want)
+	}
+	if hit.Text != embeddedDocs[1].Text {
+		t.Errorf("text = %q, want %q", hit.Text, embeddedDocs[1].Text)
+	}
+}
+
+func TestEmbeddedPersistence(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	e, err := OpenEmbedded(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Index(ctx, embeddedDocs); err != nil {
+		t.Fatal(err)
+	}
+	updated := embeddedDocs[2]
+	updated.Text = "Now about rings."
+	if err := e.Index(ctx, []Document{updated}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Delete(ctx, []int64{1}); err != nil {
+		t.Fatal(err)
+	}
+	name := e.IndexerName()
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A record cut short by a crash is dropped.
+	f, err := os.OpenFile(filepath.Join(dir, embeddedLogName), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Document":{"ID":4,"Text":"partial`)
+	f.Close()
+
+	e = openTestEmbedded(t, dir)
+	if e.IndexerName() != name || !strings.HasPrefix(name, "embedded:") {
+		t.Errorf("indexer name = %q after reopening, want %q", e.IndexerName(), name)
+	}
+	if got := searchIDs(t, e, &Request{}); !reflect.DeepEqual(got, []int64{2, 3}) {
+		t.Errorf("documents after reopening = %v, want [2 3]", got)
+	}
+	if got := searchIDs(t, e, &Request{Text: "rings"}); !reflect.DeepEqual(got, []int64{3}) {
+		t.Errorf("search for updated text = %v, want [3]", got)
+	}
+	if got := searchIDs(t, e, &Request{Text: "reclaim memory"}); len(got) != 0 {
+		t.Errorf("search for replaced text = %v, want none", got)
+	}
+
+	e.dead = compactThreshold
+	if err := e.maybeCompact(); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchIDs(t, e, &Request{Text: "rings"}); !reflect.DeepEqual(got, []int64{3}) {
+		t.Errorf("search after compacting = %v, want [3]", got)
+	}
+	if err := e.Index(ctx, embeddedDocs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	e.Close()
+	e = openTestEmbedded(t, dir)
+	if got := searchIDs(t, e, &Request{}); !reflect.DeepEqual(got, []int64{1, 2, 3}) {
+		t.Errorf("documents after compacting and reopening = %v, want [1 2 3]", got)
+	}
+}
+
+func TestEmbeddedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	e, err := OpenEmbedded(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Index(ctx, embeddedDocs[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records written after the snapshot are replayed, also without Close.
+	e, err = OpenEmbedded(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.snapshotSize != e.size {
+		t.Fatalf("loaded snapshot of %d bytes, want the whole log of %d", e.snapshotSize, e.size)
+	}
+	if err := e.Index(ctx, embeddedDocs[2:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Delete(ctx, []int64{1}); err != nil {
+		t.Fatal(err)
+	}
+	e.log.Close()
+	e.lock.Close()
+
+	e = openTestEmbedded(t, dir)
+	if e.snapshotSize == 0 || e.snapshotSize == e.size {
+		t.Errorf("snapshot of %d bytes with a log of %d, want one of the first records", e.snapshotSize, e.size)
+	}
+	if got := searchIDs(t, e, &Request{}); !reflect.DeepEqual(got, []int64{2, 3}) {
+		t.Errorf("documents = %v, want [2 3]", got)
+	}
+	for text, want := range map[string][]int64{`"sk_buff skb"`: {2}, "reclaim": {3}, "io_uring": {2}} {
+		if got := searchIDs(t, e, &Request{Text: text}); !reflect.DeepEqual(got, want) {
+			t.Errorf("search %q = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestEmbeddedLock(t *testing.T) {
+	dir := t.TempDir()
+	e, err := OpenEmbedded(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenEmbedded(dir); err == nil {
+		t.Fatal("second open succeeded, want error")
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	openTestEmbedded(t, dir)
+}
+
+func TestHighlight(t *testing.T) {
+	got := highlight("Fix <b> io_uring_submit and Ünïcode", map[string]bool{"uring": true, "ünïcode": true, "b": true})
+	want := "Fix <<em>b</em>> io_<em>uring</em>_submit and <em>Ünïcode</em>"
+	if got != want {
+		t.Errorf("highlight = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Condition compares a Document field with a value. Op is one of =, >, >=,
+// < and <=. Value is a string, an int64 or a bool. On a list field, = means
+// the list contains Value.
+type Condition struct {
+	Field string
+	Op    string
+	Value any
+}
+
+// Filter is a conjunction of conditions, negated if Not is set. The filters
+// of a Request are ANDed together.
+type Filter struct {
+	All []Condition
+	Not bool
+}
+
+// Equals returns a filter on field being value.
+func Equals(field string, value any) Filter {
+	return Filter{All: []Condition{{Field: field, Op: "=", Value: value}}}
+}
+
+// String returns f in Meilisearch filter syntax.
+func (f Filter) String() string {
+	parts := make([]string, len(f.All))
+	for i, c := range f.All {
+		parts[i] = c.Field + " " + c.Op + " " + filterValue(c.Value)
+	}
+	s := strings.Join(parts, " AND ")
+	if f.Not {
+		return "NOT (" + s + ")"
+	}
+	return s
+}
+
+func filterValue(v any) string {
+	if s, ok := v.(string); ok {
+		return QuoteFilterValue(s)
+	}
+	return fmt.Sprint(v)
+}
+
+// QuoteFilterValue quotes v for use as a string in a Meilisearch filter.
+func QuoteFilterValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
@@ -2,87 +2,114 @@ package search
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"time"
+	"strings"
 
-	"github.com/alexmorten/patchy/db" // Replace with the actual import path of your db package
-
-	"github.com/jackc/pgx/v5"
-	"github.com/meilisearch/meilisearch-go"
+	"github.com/alexmorten/patchy/db"
+	"github.com/alexmorten/patchy/internal/diff"
 )
 
-func IndexDocuments() {
-	// Connect to the database
-	conn, err := connectToDatabase()
-	if err != nil {
-		log.Fatalf("Unable to connect to database: %v\n", err)
-	}
-	defer conn.Close(context.Background())
-
-	// Create a new Queries instance
-	queries := db.New(conn)
-
-	// List all documents from the database
-
-	// Initialize Meilisearch client
-	client := meilisearch.New("http://localhost:7700")
-
-	// Prepare documents for Meilisearch
-	// var meiliDocs []map[string]interface{}
-	// for _, doc := range docs {
-	// 	meiliDocs = append(meiliDocs, map[string]interface{}{
-	// 		"id":   doc.ID,
-	// 		"text": doc.Text,
-	// 		"url":  doc.Url,
-	// 	})
-	// }
-
-	// Index documents in Meilisearch
-	index := client.Index("documents")
-	listAllDocs(queries, func(docs []db.Doc) {
+// filterableAttributes are the document fields the search API and the query
+// language filter on.
+var filterableAttributes = []string{
+	"IsPatch", "PatchPrefixes", "PatchTree", "PatchVersion", "PatchIndex", "PatchTotal",
+	"FromTerms", "ToTerms", "CcTerms", "SubjectWords", "DiffFiles", "HunkHeaders", "SentAt", "TrailerKeys",
+	"Identifiers",
+}
 
-		task, err := index.AddDocuments(docs)
-		if err != nil {
-			log.Fatalf("Failed to index documents: %v\n", err)
-		}
+// sortableAttributes are the document fields search results can be sorted by.
+var sortableAttributes = []string{"SentAt"}
 
-		fmt.Printf("Documents indexed with task UID: %d\n", task.TaskUID)
-	})
+// Document is the part of a docs row that goes into the search index. The
+// raw message source stays in Postgres. The *Terms, SubjectWords, DiffFiles
+// and HunkHeaders fields only exist for ParseQuery filters; see terms.go.
+// Prose, Quoted, DiffAdded, DiffRemoved and Identifiers are Text split by
+// kind; see content.go.
+type Document struct {
+	ID            int64
+	Text          string
+	Url           string
+	MessageID     string
+	Subject       string
+	IsPatch       bool
+	PatchPrefixes []string
+	PatchTree     string
+	PatchVersion  int32
+	PatchIndex    int32
+	PatchTotal    int32
+	FromTerms     []string
+	ToTerms       []string
+	CcTerms       []string
+	SubjectWords  []string
+	DiffFiles     []string
+	HunkHeaders   []string
+	SentAt        *int64
+	TrailerKeys   []string
+	Prose         string
+	Quoted        string
+	DiffAdded     string
+	DiffRemoved   string
+	Identifiers   []string
 }
 
-func listAllDocs(queries *db.Queries, f func(docs []db.Doc)) {
-	var id int32
-	hasAlreadySlept := false
-	for {
-		docs, err := queries.ListDocuments(context.Background(), db.ListDocumentsParams{
-			Limit:  1000,
-			Offset: id,
-		})
-		if err != nil {
-			log.Fatalf("Failed to list documents: %v\n", err)
-		}
-		if len(docs) == 0 {
-			if hasAlreadySlept {
-				return
+// toDocuments converts docs rows. keys holds the lower-cased trailer keys
+// attributed to each patch.
+func toDocuments(docs []db.Doc, keys map[int64][]string) []Document {
+	documents := make([]Document, 0, len(docs))
+	for _, doc := range docs {
+		var sections []string
+		for _, f := range diff.Parse(doc.Text).Files {
+			for _, h := range f.Hunks {
+				sections = append(sections, h.Section)
 			}
-			fmt.Printf("waiting for more...")
-			time.Sleep(5 * time.Second)
-			hasAlreadySlept = true
-			continue
 		}
+		content := splitContent(doc.Text)
 
-		hasAlreadySlept = false
-
-		f(docs)
-		id = int32(docs[len(docs)-1].ID)
+		d := Document{
+			ID:            doc.ID,
+			Text:          doc.Text,
+			Url:           doc.Url,
+			MessageID:     doc.MessageID,
+			Subject:       doc.Subject,
+			IsPatch:       doc.IsPatch,
+			PatchPrefixes: doc.PatchPrefixes,
+			PatchTree:     doc.PatchTree,
+			PatchVersion:  doc.PatchVersion,
+			PatchIndex:    doc.PatchIndex,
+			PatchTotal:    doc.PatchTotal,
+			FromTerms:     addressTerms(doc.FromName, doc.FromEmail),
+			ToTerms:       addressTerms(doc.ToAddrs...),
+			CcTerms:       addressTerms(doc.CcAddrs...),
+			SubjectWords:  unique(words(doc.Subject)),
+			DiffFiles:     fileTerms(doc.TouchedFiles),
+			HunkHeaders:   identifiers(sections),
+			TrailerKeys:   keys[doc.ID],
+			Prose:         content.Prose,
+			Quoted:        content.Quoted,
+			DiffAdded:     content.Added,
+			DiffRemoved:   content.Removed,
+			Identifiers:   content.Identifiers,
+		}
+		if doc.SentAt.Valid {
+			sentAt := doc.SentAt.Time.Unix()
+			d.SentAt = &sentAt
+		}
+		documents = append(documents, d)
 	}
+	return documents
 }
 
-func connectToDatabase() (*pgx.Conn, error) {
-	conn, err := pgx.Connect(context.Background(), "postgresql://localhost:5432/patchy")
+func trailerKeys(ctx context.Context, queries *db.Queries, docs []db.Doc) (map[int64][]string, error) {
+	ids := make([]int64, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	rows, err := queries.ListTrailerKeys(ctx, ids)
 	if err != nil {
 		return nil, err
 	}
-	return conn, nil
+	keys := make(map[int64][]string)
+	for _, row := range rows {
+		keys[row.PatchDocID.Int64] = append(keys[row.PatchDocID.Int64], strings.ToLower(row.Key))
+	}
+	return keys, nil
 }
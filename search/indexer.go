@@ -0,0 +1,311 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// docsChangedChannel is notified by the docs_changed trigger.
+const docsChangedChannel = "docs_changed"
+
+// IndexerOptions configures RunIndexer. Zero values use the defaults.
+type IndexerOptions struct {
+	// Name identifies the cursor in indexer_state, which is kept per
+	// backend. Defaults to the backend's IndexerName method if it has one,
+	// else IndexUID.
+	Name string
+	// BatchSize is the number of documents indexed at once.
+	BatchSize int
+	// PollInterval is how long the indexer waits for a notification before
+	// looking for new documents anyway.
+	PollInterval time.Duration
+	// OnBatch, if set, is called after every batch is indexed.
+	OnBatch func(IndexerBatch)
+}
+
+// IndexerBatch reports a batch of changes that reached the index. LastDocID
+// is only set for batches of new documents, Deleted only for outbox batches.
+type IndexerBatch struct {
+	Documents int
+	Deleted   int
+	LastDocID int64
+	Duration  time.Duration
+}
+
+// indexLock is the advisory lock Reindex holds exclusively while it builds a
+// new index. The indexer holds it shared while it writes to the backend, so
+// changes made during a reindex stay in search_outbox until the new index
+// has been swapped in and are then applied to it.
+const indexLock = 0x7061746368 // "patch"
+
+// docsInsertLock is the advisory lock statements inserting into docs hold
+// shared until they commit, set up by the docs_insert_lock trigger.
+const docsInsertLock = 0x646f6373 // "docs"
+
+// RunIndexer keeps backend current with the docs table until ctx is done.
+// It indexes every document after the cursor stored in indexer_state, which
+// fills a new index and then picks up new documents. After that it applies
+// the updates and deletions recorded in search_outbox, and then waits for
+// docs_changed notifications to do both again. The cursor and the outbox
+// only move once the backend has stored a batch, so a restarted indexer
+// carries on where it stopped.
+//
+// Every indexer name gets its own cursor and its own copy of the outbox, so
+// indexers filling different backends do not take changes from each other.
+// An indexer that is no longer run keeps collecting outbox rows until its
+// indexer_state row is deleted.
+//
+// conn is used for LISTEN and must not be shared.
+func RunIndexer(ctx context.Context, conn *pgx.Conn, backend Backend, opts IndexerOptions) error {
+	if opts.Name == "" {
+		opts.Name = IndexUID
+		if named, ok := backend.(interface{ IndexerName() string }); ok {
+			opts.Name = named.IndexerName()
+		}
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Minute
+	}
+
+	// Listen before catching up so nothing committed in between is missed.
+	if _, err := conn.Exec(ctx, "LISTEN "+docsChangedChannel); err != nil {
+		return fmt.Errorf("listening for %s: %w", docsChangedChannel, err)
+	}
+	if err := backend.Setup(ctx); err != nil {
+		return err
+	}
+
+	queries := db.New(conn)
+	if err := registerIndexer(ctx, conn, queries, opts.Name); err != nil {
+		return err
+	}
+	for {
+		if err := catchUp(ctx, conn, queries, backend, opts); err != nil {
+			return err
+		}
+		if err := waitForDocs(ctx, conn, opts.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// registerIndexer adds name to indexer_state, after which search_outbox
+// records changes for it. A new indexer then waits for the transactions that
+// were already running: their changes may not be in its outbox, and they
+// must be committed before it reads the documents to fill its index.
+func registerIndexer(ctx context.Context, conn *pgx.Conn, queries *db.Queries, name string) error {
+	added, err := queries.RegisterIndexer(ctx, name)
+	if err != nil {
+		return fmt.Errorf("registering indexer: %w", err)
+	}
+	if added == 0 {
+		return nil
+	}
+
+	var xmax string
+	if err := conn.QueryRow(ctx, "SELECT pg_snapshot_xmax(pg_current_snapshot())::text").Scan(&xmax); err != nil {
+		return err
+	}
+	for {
+		var done bool
+		err := conn.QueryRow(ctx, "SELECT pg_snapshot_xmin(pg_current_snapshot()) >= $1::text::xid8", xmax).Scan(&done)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// catchUp indexes new documents and applies the outbox, unless a reindex is
+// running.
+func catchUp(ctx context.Context, conn *pgx.Conn, queries *db.Queries, backend Backend, opts IndexerOptions) error {
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock_shared($1)", indexLock).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock_shared($1)", indexLock)
+
+	state, err := queries.GetIndexerState(ctx, opts.Name)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("reading indexer state: %w", err)
+	}
+	horizon, err := insertedHorizon(ctx, conn)
+	if err != nil {
+		return err
+	}
+	cursor, err := indexAfter(ctx, queries, backend, state.LastDocID, horizon, opts)
+	if err != nil {
+		return err
+	}
+	return applyOutbox(ctx, queries, backend, cursor, opts)
+}
+
+// insertedHorizon waits for the statements inserting into docs that are
+// running and returns the highest ID in docs. Documents inserted later get
+// higher IDs, so none up to it can still appear.
+func insertedHorizon(ctx context.Context, conn *pgx.Conn) (int64, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", docsInsertLock); err != nil {
+		return 0, fmt.Errorf("waiting for inserts: %w", err)
+	}
+	var horizon int64
+	if err := tx.QueryRow(ctx, "SELECT coalesce(max(id), 0) FROM docs").Scan(&horizon); err != nil {
+		return 0, err
+	}
+	return horizon, tx.Commit(ctx)
+}
+
+// indexAfter indexes every document with an ID above lastID up to horizon
+// and returns the cursor it saved.
+func indexAfter(ctx context.Context, queries *db.Queries, backend Backend, lastID, horizon int64, opts IndexerOptions) (int64, error) {
+	for lastID < horizon {
+		start := time.Now()
+		docs, err := queries.ListDocumentsAfter(ctx, db.ListDocumentsAfterParams{ID: lastID, Limit: int32(opts.BatchSize)})
+		if err != nil {
+			return lastID, fmt.Errorf("listing documents: %w", err)
+		}
+		full := len(docs) == opts.BatchSize
+		for len(docs) > 0 && docs[len(docs)-1].ID > horizon {
+			docs = docs[:len(docs)-1]
+			full = false
+		}
+		if len(docs) == 0 {
+			return lastID, nil
+		}
+		if err := syncDocuments(ctx, queries, backend, docs, nil); err != nil {
+			return lastID, err
+		}
+
+		lastID = docs[len(docs)-1].ID
+		err = queries.UpsertIndexerState(ctx, db.UpsertIndexerStateParams{Name: opts.Name, LastDocID: lastID})
+		if err != nil {
+			return lastID, fmt.Errorf("saving indexer state: %w", err)
+		}
+		if opts.OnBatch != nil {
+			opts.OnBatch(IndexerBatch{Documents: len(docs), LastDocID: lastID, Duration: time.Since(start)})
+		}
+		if !full {
+			return lastID, nil
+		}
+	}
+	return lastID, nil
+}
+
+// applyOutbox applies and deletes the indexer's changes in search_outbox,
+// oldest first.
+// A batch brings every document it mentions to the state of its docs row:
+// the row is the result of all the changes to it, in order, and a row that
+// no longer exists is deleted from the index. Updates of documents after
+// cursor are dropped, as indexAfter indexes them as they are then.
+func applyOutbox(ctx context.Context, queries *db.Queries, backend Backend, cursor int64, opts IndexerOptions) error {
+	for {
+		start := time.Now()
+		changes, err := queries.ListSearchOutbox(ctx, db.ListSearchOutboxParams{Indexer: opts.Name, Limit: int32(opts.BatchSize)})
+		if err != nil {
+			return fmt.Errorf("listing outbox: %w", err)
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+
+		changeIDs := make([]int64, len(changes))
+		var docIDs []int64
+		seen := make(map[int64]bool)
+		for i, c := range changes {
+			changeIDs[i] = c.ID
+			if c.Op == "upsert" && c.DocID > cursor {
+				continue
+			}
+			if !seen[c.DocID] {
+				seen[c.DocID] = true
+				docIDs = append(docIDs, c.DocID)
+			}
+		}
+		var docs []db.Doc
+		if len(docIDs) > 0 {
+			docs, err = queries.ListDocumentsByIDs(ctx, docIDs)
+			if err != nil {
+				return fmt.Errorf("listing documents: %w", err)
+			}
+		}
+		var deleted []int64
+		for _, doc := range docs {
+			delete(seen, doc.ID)
+		}
+		for _, id := range docIDs {
+			if seen[id] {
+				deleted = append(deleted, id)
+			}
+		}
+		if err := syncDocuments(ctx, queries, backend, docs, deleted); err != nil {
+			return err
+		}
+
+		if err := queries.DeleteSearchOutbox(ctx, changeIDs); err != nil {
+			return fmt.Errorf("deleting outbox: %w", err)
+		}
+		if opts.OnBatch != nil {
+			opts.OnBatch(IndexerBatch{Documents: len(docIDs), Deleted: len(deleted), Duration: time.Since(start)})
+		}
+		if len(changes) < opts.BatchSize {
+			return nil
+		}
+	}
+}
+
+// syncDocuments indexes docs and deletes the deleted IDs.
+func syncDocuments(ctx context.Context, queries *db.Queries, backend Backend, docs []db.Doc, deleted []int64) error {
+	if len(docs) > 0 {
+		keys, err := trailerKeys(ctx, queries, docs)
+		if err != nil {
+			return fmt.Errorf("listing trailers: %w", err)
+		}
+		if err := backend.Index(ctx, toDocuments(docs, keys)); err != nil {
+			return fmt.Errorf("indexing documents: %w", err)
+		}
+	}
+	if len(deleted) > 0 {
+		if err := backend.Delete(ctx, deleted); err != nil {
+			return fmt.Errorf("deleting documents: %w", err)
+		}
+	}
+	return nil
+}
+
+// waitForDocs returns after a docs_changed notification or, in case one was
+// lost, after timeout. It only fails when ctx is done or the connection broke.
+func waitForDocs(ctx context.Context, conn *pgx.Conn, timeout time.Duration) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err := conn.WaitForNotification(waitCtx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil && pgconn.Timeout(err) {
+		return nil
+	}
+	return err
+}
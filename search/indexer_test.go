@@ -0,0 +1,135 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// countingBackend records what the indexer sends it.
+type countingBackend struct {
+	indexed map[int64]int
+	deleted map[int64]int
+}
+
+func (b *countingBackend) Setup(ctx context.Context) error {
+	return nil
+}
+
+func (b *countingBackend) Index(ctx context.Context, docs []Document) error {
+	for _, d := range docs {
+		b.indexed[d.ID]++
+	}
+	return nil
+}
+
+func (b *countingBackend) Delete(ctx context.Context, ids []int64) error {
+	for _, id := range ids {
+		b.deleted[id]++
+	}
+	return nil
+}
+
+func (b *countingBackend) Search(ctx context.Context, req *Request) (*Result, error) {
+	return &Result{}, nil
+}
+
+func TestIndexerIndexesEachChangeOnce(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, "postgresql://postgres@localhost:5432/patchy")
+	if err != nil {
+		t.Fatalf("Unable to connect to database: %v", err)
+	}
+	suffix := time.Now().UnixNano()
+	name := fmt.Sprintf("indexer-test-%d", suffix)
+	t.Cleanup(func() {
+		ctx := context.Background()
+		conn.Exec(ctx, "DELETE FROM indexer_state WHERE name = $1", name)
+		conn.Exec(ctx, "DELETE FROM docs WHERE message_id LIKE $1", fmt.Sprintf("%%-%d@example.com", suffix))
+		conn.Close(ctx)
+	})
+	queries := db.New(conn)
+
+	// Start the cursor at the documents already there.
+	if err := registerIndexer(ctx, conn, queries, name); err != nil {
+		t.Fatal(err)
+	}
+	horizon, err := insertedHorizon(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queries.UpsertIndexerState(ctx, db.UpsertIndexerStateParams{Name: name, LastDocID: horizon}); err != nil {
+		t.Fatal(err)
+	}
+
+	var params []db.CreateDocumentParams
+	var messageIDs []string
+	for i := range 3 {
+		messageID := fmt.Sprintf("indexer-%d-%d@example.com", i, suffix)
+		messageIDs = append(messageIDs, messageID)
+		params = append(params, db.CreateDocumentParams{Text: "text", Url: messageID, MessageID: messageID, IsPatch: i == 0})
+	}
+	if _, err := db.BulkUpsertDocuments(ctx, conn, params); err != nil {
+		t.Fatal(err)
+	}
+	// A patch's own trailers are written with it and need no second pass.
+	trailers := []db.TrailerParams{{MessageID: messageIDs[0], Key: "Signed-off-by", Email: "a@example.com", Value: "A <a@example.com>"}}
+	if err := db.ReplaceTrailers(ctx, conn, messageIDs, trailers); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int64, len(messageIDs))
+	for i, messageID := range messageIDs {
+		doc, err := queries.GetDocumentByMessageID(ctx, messageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = doc.ID
+	}
+
+	backend := &countingBackend{indexed: make(map[int64]int), deleted: make(map[int64]int)}
+	opts := IndexerOptions{Name: name, BatchSize: 2}
+	run := func() {
+		t.Helper()
+		if err := catchUp(ctx, conn, queries, backend, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(step string, indexed ...int) {
+		t.Helper()
+		for i, id := range ids {
+			if backend.indexed[id] != indexed[i] {
+				t.Errorf("%s: document %d indexed %d times, want %d", step, i, backend.indexed[id], indexed[i])
+			}
+		}
+	}
+
+	run()
+	check("after inserting", 1, 1, 1)
+	state, err := queries.GetIndexerState(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.LastDocID < ids[2] {
+		t.Errorf("cursor = %d, want at least %d", state.LastDocID, ids[2])
+	}
+
+	params[1].Text = "changed text"
+	if _, err := queries.CreateDocument(ctx, params[1]); err != nil {
+		t.Fatal(err)
+	}
+	run()
+	check("after updating", 1, 2, 1)
+
+	if _, err := conn.Exec(ctx, "DELETE FROM docs WHERE id = $1", ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	run()
+	check("after deleting", 1, 2, 1)
+	if backend.deleted[ids[2]] != 1 {
+		t.Errorf("deleted document removed %d times, want 1", backend.deleted[ids[2]])
+	}
+}
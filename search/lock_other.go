@@ -0,0 +1,13 @@
+//go:build !unix
+
+package search
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func lockFile(path string) (*os.File, error) {
+	return nil, fmt.Errorf("the embedded search backend is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,109 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/meilisearch/meilisearch-go"
+)
+
+// Meilisearch is the Backend backed by the IndexUID index of a Meilisearch
+// server.
+type Meilisearch struct {
+	client meilisearch.ServiceManager
+}
+
+func NewMeilisearch(client meilisearch.ServiceManager) *Meilisearch {
+	return &Meilisearch{client: client}
+}
+
+// Setup applies the index schema.
+func (m *Meilisearch) Setup(ctx context.Context) error {
+	_, err := ApplySchema(m.client, IndexUID)
+	return err
+}
+
+func (m *Meilisearch) Index(ctx context.Context, docs []Document) error {
+	task, err := m.client.Index(IndexUID).AddDocumentsWithContext(ctx, docs, PrimaryKey)
+	return waitForTask(m.client, task, err)
+}
+
+func (m *Meilisearch) Delete(ctx context.Context, ids []int64) error {
+	identifiers := make([]string, len(ids))
+	for i, id := range ids {
+		identifiers[i] = strconv.FormatInt(id, 10)
+	}
+	task, err := m.client.Index(IndexUID).DeleteDocumentsWithContext(ctx, identifiers)
+	return waitForTask(m.client, task, err)
+}
+
+func (m *Meilisearch) Search(ctx context.Context, req *Request) (*Result, error) {
+	request := &meilisearch.SearchRequest{
+		AttributesToHighlight: []string{"Text"},
+		Page:                  req.Page,
+		HitsPerPage:           req.PerPage,
+		Facets:                req.Facets,
+	}
+	switch req.Sort {
+	case SortDateDesc:
+		request.Sort = []string{"SentAt:desc"}
+	case SortDateAsc:
+		request.Sort = []string{"SentAt:asc"}
+	}
+	if len(req.Filters) > 0 {
+		filter := make([]string, len(req.Filters))
+		for i, f := range req.Filters {
+			filter[i] = f.String()
+		}
+		request.Filter = filter
+	}
+
+	response, err := m.client.Index(IndexUID).SearchWithContext(ctx, req.Text, request)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Hits:           make([]Hit, 0, len(response.Hits)),
+		Total:          response.TotalHits,
+		TotalPages:     response.TotalPages,
+		ProcessingTime: time.Duration(response.ProcessingTimeMs) * time.Millisecond,
+		Facets:         facetCounts(response.FacetDistribution),
+	}
+	for _, hit := range response.Hits {
+		data, err := json.Marshal(hit)
+		if err != nil {
+			return nil, err
+		}
+		var h struct {
+			Document
+			Formatted struct {
+				Text string
+			} `json:"_formatted"`
+		}
+		if err := json.Unmarshal(data, &h); err != nil {
+			return nil, err
+		}
+		result.Hits = append(result.Hits, Hit{Document: h.Document, Highlighted: h.Formatted.Text})
+	}
+	return result, nil
+}
+
+// facetCounts converts Meilisearch's facetDistribution into counts per value.
+func facetCounts(distribution interface{}) map[string]map[string]int64 {
+	facets := make(map[string]map[string]int64)
+	attributes, _ := distribution.(map[string]interface{})
+	for attribute, values := range attributes {
+		counts := make(map[string]int64)
+		valueMap, _ := values.(map[string]interface{})
+		for value, count := range valueMap {
+			if n, ok := count.(float64); ok {
+				counts[value] = int64(n)
+			}
+		}
+		facets[attribute] = counts
+	}
+	return facets
+}
@@ -0,0 +1,322 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/meilisearch/meilisearch-go"
+)
+
+// fakeMeilisearch is an in-memory Meilisearch server that finishes every
+// task at once. Methods the tests do not need panic.
+type fakeMeilisearch struct {
+	meilisearch.ServiceManager
+	indexes map[string]*fakeIndexState
+	// settingsUpdates counts UpdateSettings calls per index.
+	settingsUpdates map[string]int
+	clock           time.Time
+	tasks           int64
+	// response answers every search, which is kept in lastSearch.
+	response   meilisearch.SearchResponse
+	lastSearch *fakeSearch
+}
+
+type fakeSearch struct {
+	index   string
+	query   string
+	request *meilisearch.SearchRequest
+}
+
+type fakeIndexState struct {
+	primaryKey string
+	createdAt  time.Time
+	settings   *meilisearch.Settings
+	docs       map[string]map[string]any
+}
+
+func newFakeMeilisearch() *fakeMeilisearch {
+	return &fakeMeilisearch{
+		indexes:         make(map[string]*fakeIndexState),
+		settingsUpdates: make(map[string]int),
+		clock:           time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (m *fakeMeilisearch) task() *meilisearch.TaskInfo {
+	m.tasks++
+	return &meilisearch.TaskInfo{TaskUID: m.tasks, Status: meilisearch.TaskStatusEnqueued}
+}
+
+func notFound(uid string) error {
+	return &meilisearch.Error{StatusCode: http.StatusNotFound, Function: uid}
+}
+
+// create returns the index uid, creating it like Meilisearch does when
+// documents or settings are written to an index that does not exist.
+func (m *fakeMeilisearch) create(uid, primaryKey string) *fakeIndexState {
+	if state, ok := m.indexes[uid]; ok {
+		return state
+	}
+	m.clock = m.clock.Add(time.Second)
+	state := &fakeIndexState{primaryKey: primaryKey, createdAt: m.clock, docs: make(map[string]map[string]any)}
+	m.indexes[uid] = state
+	return state
+}
+
+func (m *fakeMeilisearch) Index(uid string) meilisearch.IndexManager {
+	return &fakeIndex{client: m, uid: uid}
+}
+
+func (m *fakeMeilisearch) GetIndex(uid string) (*meilisearch.IndexResult, error) {
+	state, ok := m.indexes[uid]
+	if !ok {
+		return nil, notFound(uid)
+	}
+	return &meilisearch.IndexResult{UID: uid, PrimaryKey: state.primaryKey, CreatedAt: state.createdAt}, nil
+}
+
+func (m *fakeMeilisearch) CreateIndex(config *meilisearch.IndexConfig) (*meilisearch.TaskInfo, error) {
+	if _, ok := m.indexes[config.Uid]; ok {
+		return nil, fmt.Errorf("index %s already exists", config.Uid)
+	}
+	m.create(config.Uid, config.PrimaryKey)
+	return m.task(), nil
+}
+
+func (m *fakeMeilisearch) DeleteIndex(uid string) (*meilisearch.TaskInfo, error) {
+	if _, ok := m.indexes[uid]; !ok {
+		return nil, notFound(uid)
+	}
+	delete(m.indexes, uid)
+	return m.task(), nil
+}
+
+func (m *fakeMeilisearch) SwapIndexes(params []*meilisearch.SwapIndexesParams) (*meilisearch.TaskInfo, error) {
+	for _, p := range params {
+		a, b := p.Indexes[0], p.Indexes[1]
+		if m.indexes[a] == nil || m.indexes[b] == nil {
+			return nil, fmt.Errorf("swapping %s and %s: index missing", a, b)
+		}
+		m.indexes[a], m.indexes[b] = m.indexes[b], m.indexes[a]
+	}
+	return m.task(), nil
+}
+
+func (m *fakeMeilisearch) WaitForTask(taskUID int64, interval time.Duration) (*meilisearch.Task, error) {
+	return &meilisearch.Task{UID: taskUID, Status: meilisearch.TaskStatusSucceeded}, nil
+}
+
+// fakeIndex is a handle on an index of a fakeMeilisearch, which need not
+// exist yet.
+type fakeIndex struct {
+	meilisearch.IndexManager
+	client *fakeMeilisearch
+	uid    string
+}
+
+func (i *fakeIndex) UpdateIndex(primaryKey string) (*meilisearch.TaskInfo, error) {
+	i.client.create(i.uid, "").primaryKey = primaryKey
+	return i.client.task(), nil
+}
+
+func (i *fakeIndex) UpdateSettings(settings *meilisearch.Settings) (*meilisearch.TaskInfo, error) {
+	i.client.create(i.uid, "").settings = settings
+	i.client.settingsUpdates[i.uid]++
+	return i.client.task(), nil
+}
+
+func (i *fakeIndex) AddDocuments(documentsPtr interface{}, primaryKey ...string) (*meilisearch.TaskInfo, error) {
+	return i.AddDocumentsWithContext(context.Background(), documentsPtr, primaryKey...)
+}
+
+func (i *fakeIndex) AddDocumentsWithContext(ctx context.Context, documentsPtr interface{}, primaryKey ...string) (*meilisearch.TaskInfo, error) {
+	data, err := json.Marshal(documentsPtr)
+	if err != nil {
+		return nil, err
+	}
+	var docs []map[string]any
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return nil, err
+	}
+	state := i.client.create(i.uid, "")
+	if state.primaryKey == "" && len(primaryKey) > 0 {
+		state.primaryKey = primaryKey[0]
+	}
+	for _, doc := range docs {
+		state.docs[fmt.Sprint(doc[state.primaryKey])] = doc
+	}
+	return i.client.task(), nil
+}
+
+func (i *fakeIndex) GetDocument(identifier string, request *meilisearch.DocumentQuery, documentPtr interface{}) error {
+	state, ok := i.client.indexes[i.uid]
+	if !ok {
+		return notFound(i.uid)
+	}
+	doc, ok := state.docs[identifier]
+	if !ok {
+		return notFound(identifier)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, documentPtr)
+}
+
+func (i *fakeIndex) DeleteDocument(identifier string) (*meilisearch.TaskInfo, error) {
+	return i.DeleteDocumentsWithContext(context.Background(), []string{identifier})
+}
+
+func (i *fakeIndex) DeleteDocumentsWithContext(ctx context.Context, identifiers []string) (*meilisearch.TaskInfo, error) {
+	if state, ok := i.client.indexes[i.uid]; ok {
+		for _, id := range identifiers {
+			delete(state.docs, id)
+		}
+	}
+	return i.client.task(), nil
+}
+
+func (i *fakeIndex) GetStats() (*meilisearch.StatsIndex, error) {
+	state, ok := i.client.indexes[i.uid]
+	if !ok {
+		return nil, notFound(i.uid)
+	}
+	return &meilisearch.StatsIndex{NumberOfDocuments: int64(len(state.docs))}, nil
+}
+
+func (i *fakeIndex) SearchWithContext(ctx context.Context, query string, request *meilisearch.SearchRequest) (*meilisearch.SearchResponse, error) {
+	i.client.lastSearch = &fakeSearch{index: i.uid, query: query, request: request}
+	response := i.client.response
+	return &response, nil
+}
+
+func TestMeilisearchSearchRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		req  Request
+		want meilisearch.SearchRequest
+	}{
+		{
+			name: "text only",
+			req:  Request{Text: "slab leak", Page: 1, PerPage: 20},
+			want: meilisearch.SearchRequest{AttributesToHighlight: []string{"Text"}, Page: 1, HitsPerPage: 20},
+		},
+		{
+			name: "page mapping",
+			req:  Request{Text: "slab leak", Page: 7, PerPage: 50},
+			want: meilisearch.SearchRequest{AttributesToHighlight: []string{"Text"}, Page: 7, HitsPerPage: 50},
+		},
+		{
+			name: "filters",
+			req: Request{
+				Filters: []Filter{
+					Equals("IsPatch", true),
+					Equals("PatchVersion", int64(2)),
+					Equals("PatchTree", `net "next"`),
+					{All: []Condition{{Field: "SentAt", Op: ">=", Value: int64(100)}, {Field: "SentAt", Op: "<", Value: int64(200)}}},
+					{All: []Condition{{Field: "FromTerms", Op: "=", Value: `a\b@x`}}, Not: true},
+				},
+				Page:    1,
+				PerPage: 20,
+			},
+			want: meilisearch.SearchRequest{
+				AttributesToHighlight: []string{"Text"},
+				Page:                  1,
+				HitsPerPage:           20,
+				Filter: []string{
+					"IsPatch = true",
+					"PatchVersion = 2",
+					`PatchTree = "net \"next\""`,
+					"SentAt >= 100 AND SentAt < 200",
+					`NOT (FromTerms = "a\\b@x")`,
+				},
+			},
+		},
+		{
+			name: "relevance",
+			req:  Request{Sort: SortRelevance, Page: 1, PerPage: 20},
+			want: meilisearch.SearchRequest{AttributesToHighlight: []string{"Text"}, Page: 1, HitsPerPage: 20},
+		},
+		{
+			name: "newest first",
+			req:  Request{Sort: SortDateDesc, Page: 1, PerPage: 20},
+			want: meilisearch.SearchRequest{AttributesToHighlight: []string{"Text"}, Page: 1, HitsPerPage: 20, Sort: []string{"SentAt:desc"}},
+		},
+		{
+			name: "oldest first",
+			req:  Request{Sort: SortDateAsc, Page: 1, PerPage: 20},
+			want: meilisearch.SearchRequest{AttributesToHighlight: []string{"Text"}, Page: 1, HitsPerPage: 20, Sort: []string{"SentAt:asc"}},
+		},
+		{
+			name: "facets",
+			req:  Request{Page: 1, PerPage: 20, Facets: []string{"IsPatch", "PatchTree"}},
+			want: meilisearch.SearchRequest{AttributesToHighlight: []string{"Text"}, Page: 1, HitsPerPage: 20, Facets: []string{"IsPatch", "PatchTree"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeMeilisearch()
+			if _, err := NewMeilisearch(client).Search(context.Background(), &tt.req); err != nil {
+				t.Fatal(err)
+			}
+			got := client.lastSearch
+			if got.index != IndexUID || got.query != tt.req.Text {
+				t.Errorf("searched %s for %q, want %s for %q", got.index, got.query, IndexUID, tt.req.Text)
+			}
+			if !reflect.DeepEqual(*got.request, tt.want) {
+				t.Errorf("request = %+v, want %+v", *got.request, tt.want)
+			}
+		})
+	}
+}
+
+func TestMeilisearchSearchResult(t *testing.T) {
+	client := newFakeMeilisearch()
+	client.response = meilisearch.SearchResponse{
+		Hits: []interface{}{
+			map[string]interface{}{
+				"ID": float64(7), "Text": "fix the slab leak", "Subject": "[PATCH 1/2] mm: fix", "IsPatch": true,
+				"PatchVersion": float64(2), "PatchIndex": float64(1), "PatchTotal": float64(2),
+				"_formatted": map[string]interface{}{"Text": "fix the <em>slab</em> leak"},
+			},
+			map[string]interface{}{"ID": float64(8), "Text": "reply"},
+		},
+		TotalHits:        42,
+		TotalPages:       3,
+		Page:             2,
+		HitsPerPage:      20,
+		ProcessingTimeMs: 12,
+		FacetDistribution: map[string]interface{}{
+			"IsPatch":   map[string]interface{}{"true": float64(30), "false": float64(12)},
+			"PatchTree": map[string]interface{}{},
+		},
+	}
+
+	result, err := NewMeilisearch(client).Search(context.Background(), &Request{Text: "slab", Page: 2, PerPage: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Result{
+		Hits: []Hit{
+			{Document: Document{ID: 7, Text: "fix the slab leak", Subject: "[PATCH 1/2] mm: fix", IsPatch: true,
+				PatchVersion: 2, PatchIndex: 1, PatchTotal: 2}, Highlighted: "fix the <em>slab</em> leak"},
+			{Document: Document{ID: 8, Text: "reply"}},
+		},
+		Total:          42,
+		TotalPages:     3,
+		ProcessingTime: 12 * time.Millisecond,
+		Facets: map[string]map[string]int64{
+			"IsPatch":   {"true": 30, "false": 12},
+			"PatchTree": {},
+		},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %+v, want %+v", result, want)
+	}
+}
@@ -0,0 +1,285 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Postgres is the Backend backed by the search_documents table. Text is
+// matched with full-text search and subjects also by trigram similarity, so
+// small instances can search without running Meilisearch.
+type Postgres struct {
+	db db.DBTX
+}
+
+func NewPostgres(conn db.DBTX) *Postgres {
+	return &Postgres{db: conn}
+}
+
+// postgresColumn maps a Document field to its search_documents column.
+type postgresColumn struct {
+	Field  string
+	Column string
+	Type   string
+}
+
+var postgresColumns = []postgresColumn{
+	{"ID", "id", "bigint"},
+	{"Text", "text", "text"},
+	{"Url", "url", "text"},
+	{"MessageID", "message_id", "text"},
+	{"Subject", "subject", "text"},
+	{"IsPatch", "is_patch", "boolean"},
+	{"PatchPrefixes", "patch_prefixes", "text[]"},
+	{"PatchTree", "patch_tree", "text"},
+	{"PatchVersion", "patch_version", "integer"},
+	{"PatchIndex", "patch_index", "integer"},
+	{"PatchTotal", "patch_total", "integer"},
+	{"FromTerms", "from_terms", "text[]"},
+	{"ToTerms", "to_terms", "text[]"},
+	{"CcTerms", "cc_terms", "text[]"},
+	{"SubjectWords", "subject_words", "text[]"},
+	{"DiffFiles", "diff_files", "text[]"},
+	{"HunkHeaders", "hunk_headers", "text[]"},
+	{"SentAt", "sent_at", "bigint"},
+	{"TrailerKeys", "trailer_keys", "text[]"},
+	{"Identifiers", "identifiers", "text[]"},
+}
+
+func postgresColumnOf(field string) (postgresColumn, bool) {
+	for _, c := range postgresColumns {
+		if c.Field == field {
+			return c, true
+		}
+	}
+	return postgresColumn{}, false
+}
+
+// IndexerName gives the postgres backend its own indexer cursor.
+func (p *Postgres) IndexerName() string {
+	return "postgres"
+}
+
+// Setup checks that search_documents exists; it is created by db/schema.sql.
+func (p *Postgres) Setup(ctx context.Context) error {
+	_, err := p.db.Exec(ctx, "SELECT 1 FROM search_documents LIMIT 1")
+	return err
+}
+
+// Index upserts docs in one statement. They are sent as JSON, which Postgres
+// turns back into rows, arrays included.
+func (p *Postgres) Index(ctx context.Context, docs []Document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(docs)
+	if err != nil {
+		return err
+	}
+
+	columns := make([]string, len(postgresColumns))
+	fields := make([]string, len(postgresColumns))
+	definitions := make([]string, len(postgresColumns))
+	updates := make([]string, 0, len(postgresColumns)-1)
+	for i, c := range postgresColumns {
+		columns[i] = c.Column
+		fields[i] = fmt.Sprintf("%q", c.Field)
+		definitions[i] = fields[i] + " " + c.Type
+		if c.Column != "id" {
+			updates = append(updates, c.Column+" = EXCLUDED."+c.Column)
+		}
+	}
+
+	_, err = p.db.Exec(ctx, `INSERT INTO search_documents (`+strings.Join(columns, ", ")+`)
+		SELECT `+strings.Join(fields, ", ")+`
+		FROM jsonb_to_recordset($1::jsonb) AS d(`+strings.Join(definitions, ", ")+`)
+		ON CONFLICT (id) DO UPDATE SET `+strings.Join(updates, ", "), data)
+	return err
+}
+
+func (p *Postgres) Delete(ctx context.Context, ids []int64) error {
+	_, err := p.db.Exec(ctx, "DELETE FROM search_documents WHERE id = ANY($1)", ids)
+	return err
+}
+
+func (p *Postgres) Search(ctx context.Context, req *Request) (*Result, error) {
+	start := time.Now()
+	q, err := buildPostgresQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Facets: make(map[string]map[string]int64)}
+	if err := p.db.QueryRow(ctx, q.count(), q.args...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+	result.TotalPages = totalPages(result.Total, req.PerPage)
+
+	rows, err := p.db.Query(ctx, q.hits(req), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h Hit
+		err := rows.Scan(&h.ID, &h.Text, &h.Url, &h.MessageID, &h.Subject, &h.IsPatch,
+			&h.PatchPrefixes, &h.PatchTree, &h.PatchVersion, &h.PatchIndex, &h.PatchTotal,
+			&h.FromTerms, &h.ToTerms, &h.CcTerms, &h.SubjectWords, &h.DiffFiles, &h.HunkHeaders,
+			&h.SentAt, &h.TrailerKeys, &h.Identifiers, &h.Highlighted)
+		if err != nil {
+			return nil, err
+		}
+		result.Hits = append(result.Hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, field := range req.Facets {
+		counts, err := p.facet(ctx, q, field)
+		if err != nil {
+			return nil, err
+		}
+		result.Facets[field] = counts
+	}
+	result.ProcessingTime = time.Since(start)
+	return result, nil
+}
+
+func (p *Postgres) facet(ctx context.Context, q *postgresQuery, field string) (map[string]int64, error) {
+	column, ok := postgresColumnOf(field)
+	if !ok {
+		return nil, fmt.Errorf("unknown facet %s", field)
+	}
+	rows, err := p.db.Query(ctx, q.facet(column), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var value pgtype.Text
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			counts[value.String] = count
+		}
+	}
+	return counts, rows.Err()
+}
+
+// postgresQuery is the FROM and WHERE part shared by the queries of a
+// search. With text, $1 is the text and query.tsq the tsquery made of it.
+type postgresQuery struct {
+	where   []string
+	args    []any
+	hasText bool
+}
+
+func buildPostgresQuery(req *Request) (*postgresQuery, error) {
+	q := &postgresQuery{}
+	if req.Text != "" {
+		q.hasText = true
+		q.arg(req.Text)
+		q.where = append(q.where, "(d.tsv @@ query.tsq OR $1 <% d.subject)")
+	}
+	for _, f := range req.Filters {
+		where, err := q.filter(f)
+		if err != nil {
+			return nil, err
+		}
+		q.where = append(q.where, where)
+	}
+	return q, nil
+}
+
+func (q *postgresQuery) arg(v any) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// filter renders f. Like in Meilisearch, a negated filter also matches
+// documents that lack the field.
+func (q *postgresQuery) filter(f Filter) (string, error) {
+	parts := make([]string, len(f.All))
+	for i, c := range f.All {
+		column, ok := postgresColumnOf(c.Field)
+		if !ok {
+			return "", fmt.Errorf("unknown filter field %s", c.Field)
+		}
+		switch {
+		case strings.HasSuffix(column.Type, "[]") && c.Op == "=":
+			parts[i] = fmt.Sprintf("d.%s @> ARRAY[%s::text]", column.Column, q.arg(c.Value))
+		case strings.HasSuffix(column.Type, "[]"):
+			return "", fmt.Errorf("%s cannot be compared with %s", c.Field, c.Op)
+		case c.Op == "=" || c.Op == ">" || c.Op == ">=" || c.Op == "<" || c.Op == "<=":
+			parts[i] = fmt.Sprintf("d.%s %s %s", column.Column, c.Op, q.arg(c.Value))
+		default:
+			return "", fmt.Errorf("unknown filter operator %s", c.Op)
+		}
+	}
+	where := "(" + strings.Join(parts, " AND ") + ")"
+	if f.Not {
+		return "NOT coalesce(" + where + ", false)", nil
+	}
+	return where, nil
+}
+
+// from returns the FROM and WHERE clauses. join is added to the FROM list.
+func (q *postgresQuery) from(join string) string {
+	from := "FROM search_documents d" + join
+	if q.hasText {
+		from += ", (SELECT websearch_to_tsquery('english', $1) AS tsq) query"
+	}
+	if len(q.where) > 0 {
+		from += " WHERE " + strings.Join(q.where, " AND ")
+	}
+	return from
+}
+
+func (q *postgresQuery) count() string {
+	return "SELECT count(*) " + q.from("")
+}
+
+func (q *postgresQuery) hits(req *Request) string {
+	columns := make([]string, len(postgresColumns))
+	for i, c := range postgresColumns {
+		columns[i] = "d." + c.Column
+	}
+	highlighted := "d.text"
+	if q.hasText {
+		highlighted = "ts_headline('english', d.text, query.tsq, 'HighlightAll=true, StartSel=<em>, StopSel=</em>')"
+	}
+
+	var order string
+	switch {
+	case req.Sort == SortDateDesc:
+		order = "d.sent_at DESC NULLS LAST, d.id DESC"
+	case req.Sort == SortDateAsc:
+		order = "d.sent_at ASC NULLS LAST, d.id"
+	case q.hasText:
+		order = "ts_rank_cd(d.tsv, query.tsq) + word_similarity($1, d.subject) DESC, d.sent_at DESC NULLS LAST"
+	default:
+		order = "d.sent_at DESC NULLS LAST, d.id DESC"
+	}
+
+	offset, _ := pageBounds(req.Page, req.PerPage, math.MaxInt64)
+	return fmt.Sprintf("SELECT %s, %s %s ORDER BY %s LIMIT %d OFFSET %d",
+		strings.Join(columns, ", "), highlighted, q.from(""), order, req.PerPage, offset)
+}
+
+func (q *postgresQuery) facet(column postgresColumn) string {
+	if strings.HasSuffix(column.Type, "[]") {
+		return fmt.Sprintf("SELECT v, count(*) %s GROUP BY v", q.from(", unnest(d."+column.Column+") v"))
+	}
+	return fmt.Sprintf("SELECT d.%s::text, count(*) %s GROUP BY 1", column.Column, q.from(""))
+}
@@ -0,0 +1,55 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPostgresQuery(t *testing.T) {
+	query, err := ParseQuery(`io_uring f:axboe@kernel.dk -has:acked-by d:20240101.. is:cover`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := buildPostgresQuery(&Request{Text: query.Text, Filters: query.Filters, Page: 2, PerPage: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantWhere := []string{
+		"(d.tsv @@ query.tsq OR $1 <% d.subject)",
+		"(d.from_terms @> ARRAY[$2::text])",
+		"NOT coalesce((d.trailer_keys @> ARRAY[$3::text]), false)",
+		"(d.sent_at >= $4)",
+		"(d.is_patch = $5 AND d.patch_index = $6 AND d.patch_total > $7)",
+	}
+	if !reflect.DeepEqual(q.where, wantWhere) {
+		t.Errorf("where = %q, want %q", q.where, wantWhere)
+	}
+	wantArgs := []any{"io_uring", "axboe@kernel.dk", "acked-by", int64(1704067200), true, int64(0), int64(0)}
+	if !reflect.DeepEqual(q.args, wantArgs) {
+		t.Errorf("args = %v, want %v", q.args, wantArgs)
+	}
+
+	hits := q.hits(&Request{Page: 2, PerPage: 20})
+	for _, want := range []string{"ts_headline(", "ORDER BY ts_rank_cd(", "LIMIT 20 OFFSET 20"} {
+		if !strings.Contains(hits, want) {
+			t.Errorf("hits query %q does not contain %q", hits, want)
+		}
+	}
+	if facet := q.facet(postgresColumn{"PatchPrefixes", "patch_prefixes", "text[]"}); !strings.Contains(facet, "unnest(d.patch_prefixes) v") {
+		t.Errorf("facet query %q does not unnest the list", facet)
+	}
+}
+
+func TestPostgresQueryErrors(t *testing.T) {
+	for _, f := range []Filter{
+		Equals("Raw", "x"),
+		{All: []Condition{{Field: "DiffFiles", Op: ">", Value: "x"}}},
+		{All: []Condition{{Field: "SentAt", Op: "!=", Value: int64(1)}}},
+	} {
+		if _, err := buildPostgresQuery(&Request{Filters: []Filter{f}}); err == nil {
+			t.Errorf("filter %s succeeded, want error", f)
+		}
+	}
+}
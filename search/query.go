@@ -0,0 +1,220 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query is a parsed search query: free text for the backend to rank and
+// filters, which are ANDed together.
+type Query struct {
+	Text    string
+	Filters []Filter
+}
+
+// ParseQuery parses the query language of the search box. It follows the
+// prefixes public-inbox uses on lore.kernel.org:
+//
+//	f:      From address or name
+//	t:, c:  To and Cc addresses or names
+//	s:      subject words
+//	dfn:    file name touched by the diff (path, base name or directory)
+//	dfhh:   identifier in a hunk header function context
+//	sym:    identifier in the code or text, matched exactly
+//	d:      date or date range, YYYYMMDD..YYYYMMDD with either end optional
+//	v:      patch version
+//	is:     cover or patch
+//	has:    a trailer, such as has:reviewed-by
+//
+// Values with spaces are quoted, as in s:"memory leak", and a leading '-'
+// negates a term. Everything else is free text.
+func ParseQuery(q string) (*Query, error) {
+	query := &Query{}
+	var text []string
+	for _, token := range splitQuery(q) {
+		negate := false
+		term := token
+		if strings.HasPrefix(term, "-") && len(term) > 1 {
+			negate, term = true, term[1:]
+		}
+
+		prefix, value, ok := strings.Cut(term, ":")
+		prefix = strings.ToLower(prefix)
+		if !ok || !isPrefix(prefix) {
+			text = append(text, token)
+			continue
+		}
+		value = unquoteValue(value)
+		if value == "" {
+			return nil, fmt.Errorf("%s: needs a value", prefix)
+		}
+
+		filter, err := prefixFilter(prefix, value)
+		if err != nil {
+			return nil, err
+		}
+		filter.Not = negate
+		query.Filters = append(query.Filters, filter)
+	}
+	query.Text = strings.Join(text, " ")
+	return query, nil
+}
+
+// ExactIdentifiers makes the free-text words of q that are identifiers
+// match like sym:, only documents that contain exactly that identifier.
+// They also stay in Text, so the matches are still ranked and highlighted.
+// Other words and quoted phrases are left alone.
+func (q *Query) ExactIdentifiers() {
+	var text []string
+	for _, word := range splitQuery(q.Text) {
+		negate := false
+		id := word
+		if strings.HasPrefix(id, "-") && len(id) > 1 {
+			negate, id = true, id[1:]
+		}
+		if len(id) < 2 || identifierPattern.FindString(id) != id {
+			text = append(text, word)
+			continue
+		}
+		filter := Equals("Identifiers", id)
+		filter.Not = negate
+		q.Filters = append(q.Filters, filter)
+		if !negate {
+			text = append(text, word)
+		}
+	}
+	q.Text = strings.Join(text, " ")
+}
+
+func isPrefix(prefix string) bool {
+	switch prefix {
+	case "f", "t", "c", "s", "dfn", "dfhh", "sym", "d", "v", "is", "has":
+		return true
+	}
+	return false
+}
+
+func prefixFilter(prefix, value string) (Filter, error) {
+	switch prefix {
+	case "f":
+		return termsFilter("FromTerms", addressQueryTerms(value))
+	case "t":
+		return termsFilter("ToTerms", addressQueryTerms(value))
+	case "c":
+		return termsFilter("CcTerms", addressQueryTerms(value))
+	case "s":
+		return termsFilter("SubjectWords", words(value))
+	case "dfn":
+		return termsFilter("DiffFiles", []string{strings.Trim(value, "/")})
+	case "dfhh":
+		return termsFilter("HunkHeaders", identifierPattern.FindAllString(value, -1))
+	case "sym":
+		return termsFilter("Identifiers", identifierPattern.FindAllString(value, -1))
+	case "d":
+		return dateFilter(value)
+	case "v":
+		version, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(value), "v"))
+		if err != nil {
+			return Filter{}, fmt.Errorf("invalid version %q", value)
+		}
+		return Equals("PatchVersion", int64(version)), nil
+	case "is":
+		switch strings.ToLower(value) {
+		case "cover":
+			return Filter{All: []Condition{
+				{Field: "IsPatch", Op: "=", Value: true},
+				{Field: "PatchIndex", Op: "=", Value: int64(0)},
+				{Field: "PatchTotal", Op: ">", Value: int64(0)},
+			}}, nil
+		case "patch":
+			return Equals("IsPatch", true), nil
+		}
+		return Filter{}, fmt.Errorf("unknown is: value %q", value)
+	case "has":
+		return Equals("TrailerKeys", strings.ToLower(value)), nil
+	}
+	return Filter{}, fmt.Errorf("unknown prefix %q", prefix)
+}
+
+func termsFilter(attribute string, terms []string) (Filter, error) {
+	if len(terms) == 0 {
+		return Filter{}, fmt.Errorf("no searchable words in %s filter", attribute)
+	}
+	var f Filter
+	for _, t := range terms {
+		f.All = append(f.All, Condition{Field: attribute, Op: "=", Value: t})
+	}
+	return f, nil
+}
+
+// dateFilter turns YYYYMMDD, YYYYMMDD.., ..YYYYMMDD or YYYYMMDD..YYYYMMDD
+// into a SentAt range. Both ends are whole days in UTC and inclusive.
+func dateFilter(value string) (Filter, error) {
+	from, to, isRange := strings.Cut(value, "..")
+	if !isRange {
+		to = from
+	}
+
+	var f Filter
+	if from != "" {
+		start, err := parseDay(from)
+		if err != nil {
+			return Filter{}, err
+		}
+		f.All = append(f.All, Condition{Field: "SentAt", Op: ">=", Value: start.Unix()})
+	}
+	if to != "" {
+		end, err := parseDay(to)
+		if err != nil {
+			return Filter{}, err
+		}
+		f.All = append(f.All, Condition{Field: "SentAt", Op: "<", Value: end.AddDate(0, 0, 1).Unix()})
+	}
+	if len(f.All) == 0 {
+		return Filter{}, fmt.Errorf("invalid date range %q", value)
+	}
+	return f, nil
+}
+
+func parseDay(s string) (time.Time, error) {
+	for _, layout := range []string{"20060102", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, want YYYYMMDD", s)
+}
+
+// splitQuery splits q at whitespace outside double quotes.
+func splitQuery(q string) []string {
+	var tokens []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+func unquoteValue(v string) string {
+	if len(v) >= 2 && strings.HasPrefix(v, `"`) && strings.HasSuffix(v, `"`) {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
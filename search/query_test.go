@@ -0,0 +1,150 @@
+package search
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		q           string
+		wantText    string
+		wantFilters []string
+	}{
+		{
+			name:     "free text only",
+			q:        "io_uring  deadlock",
+			wantText: "io_uring deadlock",
+		},
+		{
+			name:        "from email and name",
+			q:           "f:Torvalds@Linux-Foundation.org f:\"Andrew Morton\" oops",
+			wantText:    "oops",
+			wantFilters: []string{`FromTerms = "torvalds@linux-foundation.org"`, `FromTerms = "andrew" AND FromTerms = "morton"`},
+		},
+		{
+			name:        "to and cc",
+			q:           "t:linux-mm@kvack.org c:stable",
+			wantFilters: []string{`ToTerms = "linux-mm@kvack.org"`, `CcTerms = "stable"`},
+		},
+		{
+			name:        "subject words",
+			q:           `s:"mm: fix leak" S:slab`,
+			wantFilters: []string{`SubjectWords = "mm" AND SubjectWords = "fix" AND SubjectWords = "leak"`, `SubjectWords = "slab"`},
+		},
+		{
+			name:        "diff file and hunk header",
+			q:           "dfn:mm/slab.c dfn:mm/ dfhh:kmem_cache_alloc",
+			wantFilters: []string{`DiffFiles = "mm/slab.c"`, `DiffFiles = "mm"`, `HunkHeaders = "kmem_cache_alloc"`},
+		},
+		{
+			name:        "symbol",
+			q:           "sym:io_uring_submit_sqe -sym:skb",
+			wantFilters: []string{`Identifiers = "io_uring_submit_sqe"`, `NOT (Identifiers = "skb")`},
+		},
+		{
+			name:        "date range",
+			q:           "d:20240101..20240131",
+			wantFilters: []string{"SentAt >= 1704067200 AND SentAt < 1706745600"},
+		},
+		{
+			name:        "open date ranges and single day",
+			q:           "d:20240101.. d:..2024-01-31 d:20240101",
+			wantFilters: []string{"SentAt >= 1704067200", "SentAt < 1706745600", "SentAt >= 1704067200 AND SentAt < 1704153600"},
+		},
+		{
+			name:        "version, is and has",
+			q:           "v:v3 is:cover has:Reviewed-by",
+			wantFilters: []string{"PatchVersion = 3", "IsPatch = true AND PatchIndex = 0 AND PatchTotal > 0", `TrailerKeys = "reviewed-by"`},
+		},
+		{
+			name:        "negation",
+			q:           "-has:acked-by -word",
+			wantText:    "-word",
+			wantFilters: []string{`NOT (TrailerKeys = "acked-by")`},
+		},
+		{
+			name:     "unknown prefixes and urls stay text",
+			q:        `https://lore.kernel.org/x "exact phrase" fixes:abc`,
+			wantText: `https://lore.kernel.org/x "exact phrase" fixes:abc`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQuery(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Text != tt.wantText {
+				t.Errorf("Text = %q, want %q", got.Text, tt.wantText)
+			}
+			var filters []string
+			for _, f := range got.Filters {
+				filters = append(filters, f.String())
+			}
+			if !reflect.DeepEqual(filters, tt.wantFilters) {
+				t.Errorf("Filters = %q, want %q", filters, tt.wantFilters)
+			}
+		})
+	}
+}
+
+func TestExactIdentifiers(t *testing.T) {
+	query, err := ParseQuery(`io_uring_submit_sqe -sk_buff "struct page" deadlock? a f:axboe`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query.ExactIdentifiers()
+	if want := `io_uring_submit_sqe "struct page" deadlock? a`; query.Text != want {
+		t.Errorf("Text = %q, want %q", query.Text, want)
+	}
+	var filters []string
+	for _, f := range query.Filters {
+		filters = append(filters, f.String())
+	}
+	want := []string{`FromTerms = "axboe"`, `Identifiers = "io_uring_submit_sqe"`, `NOT (Identifiers = "sk_buff")`}
+	if !reflect.DeepEqual(filters, want) {
+		t.Errorf("Filters = %q, want %q", filters, want)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, q := range []string{"f:", "d:2024", "d:..", "v:next", "is:merged", `s:"--"`} {
+		if _, err := ParseQuery(q); err == nil {
+			t.Errorf("ParseQuery(%q) succeeded, want error", q)
+		}
+	}
+}
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []string
+		want string
+	}{
+		{
+			name: "address terms",
+			got:  addressTerms("Jane Doe", "Jane.Doe@Example.org", "linux-mm@kvack.org"),
+			want: "[jane doe jane.doe@example.org jane.doe example.org linux-mm@kvack.org linux-mm kvack.org]",
+		},
+		{
+			name: "file terms",
+			got:  fileTerms([]string{"mm/kasan/common.c", "Makefile"}),
+			want: "[mm/kasan/common.c common.c mm/kasan mm Makefile]",
+		},
+		{
+			name: "hunk header identifiers",
+			got:  identifiers([]string{"static int slab_alloc(struct kmem_cache *s)", "int slab_free(void)"}),
+			want: "[static int slab_alloc struct kmem_cache s slab_free void]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fmt.Sprint(tt.got); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/meilisearch/meilisearch-go"
+)
+
+// reindexBatchSize is the number of documents sent to Meilisearch at once.
+const reindexBatchSize = 1000
+
+// ReindexProgress is reported after every batch Reindex sends.
+type ReindexProgress struct {
+	Index   string
+	Indexed int64
+	Total   int64
+}
+
+// Reindex rebuilds the documents index without taking it offline. It creates
+// documents_<unix time> with the current Settings, fills it from a single
+// snapshot of the docs table, waits for Meilisearch to finish every task and
+// checks that the new index holds as many documents as the snapshot. Only
+// then is the new index swapped with IndexUID, atomically, and the old one
+// deleted. If anything fails before the swap, the new index is deleted and
+// IndexUID is left untouched.
+//
+// Changes made after the snapshot was taken are not in the new index. New
+// documents are past the indexer's cursor and updates and deletions wait in
+// search_outbox, which the indexer leaves alone until Reindex is done, so
+// they reach the new index afterwards.
+func Reindex(ctx context.Context, conn *pgx.Conn, client meilisearch.ServiceManager, progress func(ReindexProgress)) error {
+	// Keep the indexer from consuming changes the snapshot does not have
+	// until the new index is live.
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", indexLock); err != nil {
+		return fmt.Errorf("waiting for the indexer: %w", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", indexLock)
+
+	uid := fmt.Sprintf("%s_%d", IndexUID, time.Now().Unix())
+	if _, err := ApplySchema(client, uid); err != nil {
+		return err
+	}
+
+	if err := fillIndex(ctx, conn, client, uid, progress); err != nil {
+		deleteIndex(client, uid)
+		return err
+	}
+
+	if _, _, err := ensureIndex(client, IndexUID); err != nil {
+		deleteIndex(client, uid)
+		return err
+	}
+	task, err := client.SwapIndexes([]*meilisearch.SwapIndexesParams{{Indexes: []string{IndexUID, uid}}})
+	if err := waitForTask(client, task, err); err != nil {
+		deleteIndex(client, uid)
+		return fmt.Errorf("swapping %s and %s: %w", IndexUID, uid, err)
+	}
+
+	// IndexUID now has the settings of the new index. Record that, so
+	// ApplySchema does not configure it again on the next start.
+	if _, err := ApplySchema(client, IndexUID); err != nil {
+		return err
+	}
+	return deleteIndex(client, uid)
+}
+
+// fillIndex adds every document to uid and checks the count against Postgres.
+func fillIndex(ctx context.Context, conn *pgx.Conn, client meilisearch.ServiceManager, uid string, progress func(ReindexProgress)) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	// Listing and counting must see the same rows while ingestion goes on.
+	if _, err := tx.Exec(ctx, "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY"); err != nil {
+		return err
+	}
+	queries := db.New(tx)
+
+	total, err := queries.CountDocuments(ctx)
+	if err != nil {
+		return fmt.Errorf("counting documents: %w", err)
+	}
+
+	index := client.Index(uid)
+	var tasks []*meilisearch.TaskInfo
+	var lastID, indexed int64
+	for {
+		docs, err := queries.ListDocumentsAfter(ctx, db.ListDocumentsAfterParams{ID: lastID, Limit: reindexBatchSize})
+		if err != nil {
+			return fmt.Errorf("listing documents: %w", err)
+		}
+		if len(docs) == 0 {
+			break
+		}
+		keys, err := trailerKeys(ctx, queries, docs)
+		if err != nil {
+			return fmt.Errorf("listing trailers: %w", err)
+		}
+		task, err := index.AddDocuments(toDocuments(docs, keys), PrimaryKey)
+		if err != nil {
+			return fmt.Errorf("adding documents to %s: %w", uid, err)
+		}
+		tasks = append(tasks, task)
+
+		lastID = docs[len(docs)-1].ID
+		indexed += int64(len(docs))
+		if progress != nil {
+			progress(ReindexProgress{Index: uid, Indexed: indexed, Total: total})
+		}
+	}
+
+	for _, task := range tasks {
+		if err := waitForTask(client, task, nil); err != nil {
+			return fmt.Errorf("indexing documents in %s: %w", uid, err)
+		}
+	}
+
+	stats, err := index.GetStats()
+	if err != nil {
+		return err
+	}
+	if stats.NumberOfDocuments != total {
+		return fmt.Errorf("%s has %d documents, Postgres has %d", uid, stats.NumberOfDocuments, total)
+	}
+	return nil
+}
+
+// deleteIndex deletes uid and its schema record.
+func deleteIndex(client meilisearch.ServiceManager, uid string) error {
+	task, err := client.DeleteIndex(uid)
+	if err := waitForTask(client, task, err); err != nil {
+		return fmt.Errorf("deleting index %s: %w", uid, err)
+	}
+	task, err = client.Index(schemaIndexUID).DeleteDocument(uid)
+	if err := waitForTask(client, task, err); err != nil {
+		return fmt.Errorf("deleting schema of %s: %w", uid, err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestReindex(t *testing.T) {
+	ctx := context.Background()
+	connect := func() *pgx.Conn {
+		t.Helper()
+		conn, err := pgx.Connect(ctx, "postgresql://postgres@localhost:5432/patchy")
+		if err != nil {
+			t.Fatalf("Unable to connect to database: %v", err)
+		}
+		return conn
+	}
+	// conn runs Reindex, other makes changes while it runs.
+	conn, other := connect(), connect()
+	suffix := time.Now().UnixNano()
+	name := fmt.Sprintf("reindex-test-%d", suffix)
+	t.Cleanup(func() {
+		ctx := context.Background()
+		other.Exec(ctx, "DELETE FROM indexer_state WHERE name = $1", name)
+		other.Exec(ctx, "DELETE FROM docs WHERE message_id LIKE $1", fmt.Sprintf("%%-%d@example.com", suffix))
+		other.Close(ctx)
+		conn.Close(ctx)
+	})
+	queries := db.New(other)
+
+	messageID := func(key string) string {
+		return fmt.Sprintf("reindex-%s-%d@example.com", key, suffix)
+	}
+	create := func(key, text string) int64 {
+		t.Helper()
+		messageID := messageID(key)
+		doc, err := queries.CreateDocument(ctx, db.CreateDocumentParams{Text: text, Url: messageID, MessageID: messageID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doc.ID
+	}
+	kept, deleted := create("kept", "before"), create("deleted", "before")
+
+	// The indexer has seen both documents.
+	if err := registerIndexer(ctx, other, queries, name); err != nil {
+		t.Fatal(err)
+	}
+	horizon, err := insertedHorizon(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queries.UpsertIndexerState(ctx, db.UpsertIndexerStateParams{Name: name, LastDocID: horizon}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeMeilisearch()
+	if _, err := ApplySchema(client, IndexUID); err != nil {
+		t.Fatal(err)
+	}
+	opts := IndexerOptions{Name: name, BatchSize: 100}
+
+	// Change everything once the snapshot has been taken, and check that the
+	// indexer stays out of the way.
+	var added int64
+	var newUID string
+	progress := func(p ReindexProgress) {
+		if newUID != "" {
+			return
+		}
+		newUID = p.Index
+		if _, err := queries.CreateDocument(ctx, db.CreateDocumentParams{
+			Text: "after", Url: messageID("kept"), MessageID: messageID("kept"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Exec(ctx, "DELETE FROM docs WHERE id = $1", deleted); err != nil {
+			t.Fatal(err)
+		}
+		added = create("added", "after")
+
+		backend := &countingBackend{indexed: make(map[int64]int), deleted: make(map[int64]int)}
+		if err := catchUp(ctx, other, queries, backend, opts); err != nil {
+			t.Fatal(err)
+		}
+		if len(backend.indexed) > 0 || len(backend.deleted) > 0 {
+			t.Errorf("indexer ran during the reindex: indexed %v, deleted %v", backend.indexed, backend.deleted)
+		}
+	}
+	if err := Reindex(ctx, conn, client, progress); err != nil {
+		t.Fatal(err)
+	}
+	if newUID == "" {
+		t.Fatal("no progress reported")
+	}
+
+	docs := func() map[string]map[string]any {
+		return client.indexes[IndexUID].docs
+	}
+	text := func(id int64) any {
+		doc, ok := docs()[strconv.FormatInt(id, 10)]
+		if !ok {
+			return nil
+		}
+		return doc["Text"]
+	}
+	// The new index holds the snapshot and was swapped in for the old one.
+	if _, ok := client.indexes[newUID]; ok {
+		t.Errorf("%s was not deleted after the swap", newUID)
+	}
+	if _, ok := client.indexes[schemaIndexUID].docs[newUID]; ok {
+		t.Errorf("schema of %s was not deleted", newUID)
+	}
+	if text(kept) != "before" || text(deleted) != "before" || text(added) != nil {
+		t.Errorf("after reindexing: kept %v, deleted %v, added %v, want the snapshot", text(kept), text(deleted), text(added))
+	}
+	if changed, err := ApplySchema(client, IndexUID); err != nil || changed {
+		t.Errorf("ApplySchema after the swap = %v, %v, want the settings recorded", changed, err)
+	}
+
+	// The changes made during the reindex reach the new index.
+	if err := catchUp(ctx, other, queries, NewMeilisearch(client), opts); err != nil {
+		t.Fatal(err)
+	}
+	if text(kept) != "after" || text(deleted) != nil || text(added) != "after" {
+		t.Errorf("after catching up: kept %v, deleted %v, added %v, want after, deleted, after", text(kept), text(deleted), text(added))
+	}
+}
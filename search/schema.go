@@ -0,0 +1,183 @@
+package search
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/meilisearch/meilisearch-go"
+)
+
+// IndexUID is the Meilisearch index the server searches.
+const IndexUID = "documents"
+
+// PrimaryKey is the primary key of the documents index.
+const PrimaryKey = "ID"
+
+// schemaIndexUID is the index ApplySchema records the settings hash of every
+// index it configured in, one schemaRecord per index.
+const schemaIndexUID = "patchy_schema"
+
+// schemaVersion is part of the settings hash. Bump it to force a reconfigure
+// when something other than Settings changes how an index must be set up.
+const schemaVersion = 1
+
+// searchableAttributes are ordered by importance for the attribute ranking
+// rule: a match in the subject beats one in the body, one in code beats one
+// in prose, and quoted text counts least. Text stays searchable last for the
+// diff context lines, which are in no other attribute.
+var searchableAttributes = []string{
+	"Subject", "Identifiers", "HunkHeaders", "DiffFiles", "DiffAdded", "DiffRemoved",
+	"Prose", "Quoted", "Text", "FromTerms", "MessageID",
+}
+
+// displayedAttributes leave out the term lists, which only exist for filters,
+// and the parts Text is split into.
+var displayedAttributes = []string{
+	"ID", "Url", "MessageID", "Subject", "Text", "SentAt",
+	"IsPatch", "PatchPrefixes", "PatchTree", "PatchVersion", "PatchIndex", "PatchTotal",
+}
+
+// rankingRules are Meilisearch's defaults with newer messages breaking ties.
+var rankingRules = []string{
+	"words", "typo", "proximity", "attribute", "sort", "exactness", "SentAt:desc",
+}
+
+// stopWords are English filler words. C keywords such as "if", "for" and "do"
+// are deliberately missing so they can still be searched in code.
+var stopWords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "been", "but", "by", "has", "have",
+	"i", "in", "into", "is", "it", "its", "of", "on", "or", "that", "the", "their",
+	"there", "these", "they", "this", "to", "was", "we", "were", "which", "with", "would",
+}
+
+// Settings returns the settings of the documents index.
+//
+// Typo tolerance is kept off attributes holding identifiers, paths and
+// Message-IDs, where a near miss is a different thing rather than a typo,
+// and words need to be longer than Meilisearch's default before a typo is
+// allowed, so kmalloc does not match kvmalloc.
+func Settings() *meilisearch.Settings {
+	return &meilisearch.Settings{
+		SearchableAttributes: searchableAttributes,
+		DisplayedAttributes:  displayedAttributes,
+		FilterableAttributes: filterableAttributes,
+		SortableAttributes:   sortableAttributes,
+		RankingRules:         rankingRules,
+		StopWords:            stopWords,
+		TypoTolerance: &meilisearch.TypoTolerance{
+			Enabled: true,
+			MinWordSizeForTypos: meilisearch.MinWordSizeForTypos{
+				OneTypo:  8,
+				TwoTypos: 12,
+			},
+			DisableOnAttributes: []string{"MessageID", "DiffFiles", "HunkHeaders", "FromTerms", "Identifiers"},
+		},
+		Pagination: &meilisearch.Pagination{MaxTotalHits: MaxHits},
+	}
+}
+
+// SettingsHash identifies the schema version and Settings.
+func SettingsHash() (string, error) {
+	data, err := json.Marshal(Settings())
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("v%d-%s", schemaVersion, hex.EncodeToString(sum[:8])), nil
+}
+
+// schemaRecord is the document ApplySchema stores in schemaIndexUID. The
+// creation time of the index is kept so a deleted and recreated index is
+// configured again.
+type schemaRecord struct {
+	Index     string
+	Hash      string
+	CreatedAt time.Time
+}
+
+// ApplySchema creates the index uid with PrimaryKey if it does not exist and
+// updates its settings unless the current SettingsHash was already applied to
+// it. It waits for Meilisearch to finish and reports whether anything changed.
+func ApplySchema(client meilisearch.ServiceManager, uid string) (bool, error) {
+	hash, err := SettingsHash()
+	if err != nil {
+		return false, err
+	}
+	info, created, err := ensureIndex(client, uid)
+	if err != nil {
+		return false, err
+	}
+
+	var record schemaRecord
+	err = client.Index(schemaIndexUID).GetDocument(uid, nil, &record)
+	if err != nil && !isNotFound(err) {
+		return false, fmt.Errorf("reading schema of %s: %w", uid, err)
+	}
+	if err == nil && record.Hash == hash && record.CreatedAt.Equal(info.CreatedAt) {
+		return created, nil
+	}
+
+	task, err := client.Index(uid).UpdateSettings(Settings())
+	if err := waitForTask(client, task, err); err != nil {
+		return false, fmt.Errorf("updating settings of %s: %w", uid, err)
+	}
+
+	record = schemaRecord{Index: uid, Hash: hash, CreatedAt: info.CreatedAt}
+	task, err = client.Index(schemaIndexUID).AddDocuments([]schemaRecord{record}, "Index")
+	if err := waitForTask(client, task, err); err != nil {
+		return false, fmt.Errorf("recording schema of %s: %w", uid, err)
+	}
+	return true, nil
+}
+
+// ensureIndex returns the index uid, creating it first if needed.
+func ensureIndex(client meilisearch.ServiceManager, uid string) (*meilisearch.IndexResult, bool, error) {
+	info, err := client.GetIndex(uid)
+	if err == nil {
+		if info.PrimaryKey == "" {
+			task, err := client.Index(uid).UpdateIndex(PrimaryKey)
+			if err := waitForTask(client, task, err); err != nil {
+				return nil, false, fmt.Errorf("setting primary key of %s: %w", uid, err)
+			}
+		} else if info.PrimaryKey != PrimaryKey {
+			return nil, false, fmt.Errorf("index %s has primary key %s, want %s", uid, info.PrimaryKey, PrimaryKey)
+		}
+		return info, false, nil
+	}
+	if !isNotFound(err) {
+		return nil, false, err
+	}
+
+	task, err := client.CreateIndex(&meilisearch.IndexConfig{Uid: uid, PrimaryKey: PrimaryKey})
+	if err := waitForTask(client, task, err); err != nil {
+		return nil, false, fmt.Errorf("creating index %s: %w", uid, err)
+	}
+	info, err = client.GetIndex(uid)
+	return info, true, err
+}
+
+// waitForTask waits for an enqueued task. It takes the error of the call
+// that enqueued it so callers can check both at once.
+func waitForTask(client meilisearch.ServiceManager, info *meilisearch.TaskInfo, err error) error {
+	if err != nil {
+		return err
+	}
+	task, err := client.WaitForTask(info.TaskUID, 100*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	if task.Status != meilisearch.TaskStatusSucceeded {
+		return fmt.Errorf("task %d %s: %s", task.UID, task.Status, task.Error.Message)
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var meiliErr *meilisearch.Error
+	return errors.As(err, &meiliErr) && meiliErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,108 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSettingsAttributesExist(t *testing.T) {
+	fields := make(map[string]bool)
+	for _, f := range reflect.VisibleFields(reflect.TypeOf(Document{})) {
+		fields[f.Name] = true
+	}
+
+	settings := Settings()
+	lists := map[string][]string{
+		"searchable":  settings.SearchableAttributes,
+		"displayed":   settings.DisplayedAttributes,
+		"filterable":  settings.FilterableAttributes,
+		"sortable":    settings.SortableAttributes,
+		"typo":        settings.TypoTolerance.DisableOnAttributes,
+		"primary key": {PrimaryKey},
+	}
+	for _, rule := range settings.RankingRules {
+		if attribute, _, ok := strings.Cut(rule, ":"); ok {
+			lists["ranking"] = append(lists["ranking"], attribute)
+		}
+	}
+	for name, attributes := range lists {
+		for _, a := range attributes {
+			if !fields[a] {
+				t.Errorf("%s attribute %s is not a document field", name, a)
+			}
+		}
+	}
+}
+
+func TestSettingsHash(t *testing.T) {
+	first, err := SettingsHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := SettingsHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("SettingsHash is not stable: %s != %s", first, second)
+	}
+	if !strings.HasPrefix(first, "v1-") {
+		t.Errorf("SettingsHash = %s, want schema version prefix", first)
+	}
+
+	saved := stopWords
+	defer func() { stopWords = saved }()
+	stopWords = append(stopWords[:len(stopWords):len(stopWords)], "patch")
+	changed, err := SettingsHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == first {
+		t.Error("SettingsHash did not change with the settings")
+	}
+}
+
+func TestApplySchema(t *testing.T) {
+	client := newFakeMeilisearch()
+	apply := func(step string, wantChanged bool) {
+		t.Helper()
+		changed, err := ApplySchema(client, IndexUID)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if changed != wantChanged {
+			t.Errorf("%s: changed = %v, want %v", step, changed, wantChanged)
+		}
+	}
+
+	apply("new index", true)
+	if state := client.indexes[IndexUID]; state == nil || state.primaryKey != PrimaryKey || state.settings == nil {
+		t.Fatalf("index = %+v, want it created with settings", state)
+	}
+	if client.settingsUpdates[IndexUID] != 1 {
+		t.Errorf("settings updated %d times, want once", client.settingsUpdates[IndexUID])
+	}
+
+	// The recorded hash matches, so the settings are left alone.
+	apply("unchanged", false)
+	apply("unchanged again", false)
+	if client.settingsUpdates[IndexUID] != 1 {
+		t.Errorf("settings updated %d times with a matching hash, want once", client.settingsUpdates[IndexUID])
+	}
+
+	// Settings recorded under an older hash are applied again.
+	client.indexes[schemaIndexUID].docs[IndexUID]["Hash"] = "v0-old"
+	apply("old hash", true)
+	if client.settingsUpdates[IndexUID] != 2 {
+		t.Errorf("settings updated %d times after a hash change, want twice", client.settingsUpdates[IndexUID])
+	}
+
+	// A deleted and recreated index has default settings again.
+	delete(client.indexes, IndexUID)
+	apply("recreated", true)
+	if client.settingsUpdates[IndexUID] != 3 {
+		t.Errorf("settings updated %d times after recreating the index, want 3", client.settingsUpdates[IndexUID])
+	}
+	apply("recreated, unchanged", false)
+}
@@ -0,0 +1,84 @@
+package search
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// The query language filters on exact values, so every field a prefix
+// searches is also indexed as a list of terms. The helpers here produce
+// those terms at index time and split query values the same way.
+
+var (
+	emailPattern      = regexp.MustCompile(`[^\s<>"',;]+@[^\s<>"',;]+`)
+	identifierPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+)
+
+// addressTerms returns the lower-cased terms an address can be found by: the
+// whole email, its local part and domain, and every word of the name.
+func addressTerms(addrs ...string) []string {
+	var terms []string
+	for _, addr := range addrs {
+		addr = strings.ToLower(addr)
+		for _, e := range emailPattern.FindAllString(addr, -1) {
+			local, domain, _ := strings.Cut(e, "@")
+			terms = append(terms, e, local, domain)
+			addr = strings.Replace(addr, e, " ", 1)
+		}
+		terms = append(terms, words(addr)...)
+	}
+	return unique(terms)
+}
+
+// addressQueryTerms splits the value of f:, t: or c:. Values that look like
+// an email or a domain are kept whole.
+func addressQueryTerms(value string) []string {
+	value = strings.ToLower(value)
+	if strings.ContainsAny(value, "@.") && !strings.ContainsAny(value, " \t") {
+		return []string{value}
+	}
+	return words(value)
+}
+
+// words splits s into lower-cased runs of letters and digits.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// fileTerms returns the terms a touched file can be found by: its path, its
+// base name and every directory above it.
+func fileTerms(files []string) []string {
+	var terms []string
+	for _, f := range files {
+		terms = append(terms, f, path.Base(f))
+		for dir := path.Dir(f); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			terms = append(terms, dir)
+		}
+	}
+	return unique(terms)
+}
+
+// identifiers returns the identifiers in hunk header function contexts.
+func identifiers(sections []string) []string {
+	var terms []string
+	for _, s := range sections {
+		terms = append(terms, identifierPattern.FindAllString(s, -1)...)
+	}
+	return unique(terms)
+}
+
+func unique(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	out := terms[:0]
+	for _, t := range terms {
+		if t != "" && !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
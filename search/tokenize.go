@@ -0,0 +1,60 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token is a word of a text. Start and End are byte offsets into the text,
+// Position counts words.
+type token struct {
+	Term     string
+	Start    int
+	End      int
+	Position int
+}
+
+// tokenize splits s into lower-cased runs of letters and digits.
+func tokenize(s string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{Term: strings.ToLower(s[start:i]), Start: start, End: i, Position: len(tokens)})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{Term: strings.ToLower(s[start:]), Start: start, End: len(s), Position: len(tokens)})
+	}
+	return tokens
+}
+
+// highlight wraps the tokens of s whose term is in terms in <em> tags, the
+// markup Meilisearch produces and the server's sanitizer lets through.
+func highlight(s string, terms map[string]bool) string {
+	if len(terms) == 0 || !utf8.ValidString(s) {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, t := range tokenize(s) {
+		if !terms[t.Term] {
+			continue
+		}
+		b.WriteString(s[last:t.Start])
+		b.WriteString("<em>")
+		b.WriteString(s[t.Start:t.End])
+		b.WriteString("</em>")
+		last = t.End
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
@@ -0,0 +1,269 @@
+// Package series groups patch mails into the series they were posted in,
+// using the thread they belong to and the M/N numbering in their subjects.
+package series
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// mergeWindow is how far apart the patches of an unthreaded series may be
+// sent. git send-email sends a whole series within seconds.
+const mergeWindow = 10 * time.Minute
+
+// Patch is a patch mail or cover letter.
+type Patch struct {
+	DocID     int64
+	MessageID string
+	// ThreadID is the thread the mail belongs to, or 0 if it has not been
+	// threaded.
+	ThreadID  int64
+	Subject   string
+	FromName  string
+	FromEmail string
+	Date      time.Time
+	Version   int
+	Index     int
+	Total     int
+	ChangeID  string
+	Files     []string
+}
+
+// IsCoverLetter reports whether p is the 0/N mail of a series.
+func (p *Patch) IsCoverLetter() bool {
+	return p.Index == 0 && p.Total > 0
+}
+
+// Series is one posting of a patch series.
+type Series struct {
+	Cover *Patch
+	// Patches are ordered by index, each at most once: a resent patch starts
+	// a new series.
+	Patches []*Patch
+	Version int
+	// Total is the number of patches announced by the subjects, 1 for a
+	// single patch.
+	Total int
+}
+
+// Root is the mail identifying the series: the cover letter, or the first
+// patch if there is none.
+func (s *Series) Root() *Patch {
+	if s.Cover != nil {
+		return s.Cover
+	}
+	return s.Patches[0]
+}
+
+// Subject is the subject of the cover letter, or of the first patch.
+func (s *Series) Subject() string {
+	return s.Root().Subject
+}
+
+// Date is when the earliest mail of the series was sent.
+func (s *Series) Date() time.Time {
+	earliest := s.Root().Date
+	for _, p := range s.Patches {
+		if !p.Date.IsZero() && (earliest.IsZero() || p.Date.Before(earliest)) {
+			earliest = p.Date
+		}
+	}
+	return earliest
+}
+
+// Received is the number of distinct patches of the series that arrived.
+func (s *Series) Received() int {
+	return s.Total - len(s.Missing())
+}
+
+// Complete reports whether every announced patch arrived.
+func (s *Series) Complete() bool {
+	return len(s.Missing()) == 0
+}
+
+// Missing lists the indexes of announced patches that did not arrive.
+func (s *Series) Missing() []int {
+	if s.Total <= 1 {
+		if len(s.Patches) == 0 {
+			return []int{1}
+		}
+		return nil
+	}
+	have := make(map[int]bool, len(s.Patches))
+	for _, p := range s.Patches {
+		have[p.Index] = true
+	}
+	var missing []int
+	for i := 1; i <= s.Total; i++ {
+		if !have[i] {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+func (s *Series) has(index int) bool {
+	if s.Total <= 1 {
+		return len(s.Patches) > 0
+	}
+	for _, p := range s.Patches {
+		if p.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Series) add(p *Patch) {
+	if p.IsCoverLetter() {
+		s.Cover = p
+		return
+	}
+	s.Patches = append(s.Patches, p)
+}
+
+func (s *Series) last() time.Time {
+	var latest time.Time
+	for _, p := range append([]*Patch{s.Cover}, s.Patches...) {
+		if p != nil && p.Date.After(latest) {
+			latest = p.Date
+		}
+	}
+	return latest
+}
+
+type groupKey struct {
+	threadID int64
+	from     string
+	version  int
+	total    int
+}
+
+// Group sorts patches into series and returns them oldest first. Patches
+// belong to the same series when they are in the same thread, come from the
+// same sender and agree on version and total. Within such a group a new
+// series starts whenever an index repeats, which separates resends and v2s
+// posted in reply to v1. Incomplete series of patches sent without threading
+// are then joined by sender, version and total if they were sent close
+// together.
+func Group(patches []*Patch) []*Series {
+	groups := make(map[groupKey][]*Patch)
+	var keys []groupKey
+	for _, p := range patches {
+		key := groupKey{
+			threadID: p.ThreadID,
+			from:     strings.ToLower(p.FromEmail),
+			version:  p.Version,
+			total:    p.Total,
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], p)
+	}
+
+	var all []*Series
+	for _, key := range keys {
+		group := groups[key]
+		sortPatches(group)
+
+		var current *Series
+		for _, p := range group {
+			if current == nil || key.threadID == 0 || startsNewSeries(current, p) {
+				current = &Series{Version: p.Version, Total: max(p.Total, 1)}
+				all = append(all, current)
+			}
+			current.add(p)
+		}
+	}
+
+	all = mergeUnthreaded(all)
+	for _, s := range all {
+		sort.SliceStable(s.Patches, func(i, j int) bool {
+			return s.Patches[i].Index < s.Patches[j].Index
+		})
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Date().Before(all[j].Date())
+	})
+	return all
+}
+
+func startsNewSeries(s *Series, p *Patch) bool {
+	if p.IsCoverLetter() {
+		return s.Cover != nil
+	}
+	return s.has(p.Index)
+}
+
+// mergeUnthreaded joins incomplete multi-patch series from the same sender
+// with the same version and total when their patches do not overlap and
+// were sent within mergeWindow of each other.
+func mergeUnthreaded(all []*Series) []*Series {
+	type mergeKey struct {
+		from    string
+		version int
+		total   int
+	}
+
+	candidates := make(map[mergeKey][]*Series)
+	for _, s := range all {
+		if s.Total > 1 && !s.Complete() {
+			key := mergeKey{strings.ToLower(s.Root().FromEmail), s.Version, s.Total}
+			candidates[key] = append(candidates[key], s)
+		}
+	}
+
+	merged := make(map[*Series]bool)
+	for _, group := range candidates {
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].Date().Before(group[j].Date())
+		})
+		var current *Series
+		for _, s := range group {
+			if current != nil && canMerge(current, s) {
+				if s.Cover != nil {
+					current.Cover = s.Cover
+				}
+				current.Patches = append(current.Patches, s.Patches...)
+				merged[s] = true
+				continue
+			}
+			current = s
+		}
+	}
+
+	out := all[:0]
+	for _, s := range all {
+		if !merged[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func canMerge(into, s *Series) bool {
+	if into.Cover != nil && s.Cover != nil {
+		return false
+	}
+	if s.Date().Sub(into.last()) > mergeWindow {
+		return false
+	}
+	for _, p := range s.Patches {
+		if into.has(p.Index) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortPatches(patches []*Patch) {
+	sort.SliceStable(patches, func(i, j int) bool {
+		a, b := patches[i], patches[j]
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		return a.Index < b.Index
+	})
+}
@@ -0,0 +1,169 @@
+package series
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func patch(id string, thread int64, from string, version, index, total int, seconds int) *Patch {
+	return &Patch{
+		MessageID: id,
+		ThreadID:  thread,
+		Subject:   id,
+		FromEmail: from,
+		Date:      base.Add(time.Duration(seconds) * time.Second),
+		Version:   version,
+		Index:     index,
+		Total:     total,
+	}
+}
+
+// render prints series as "cover[p1 p2]/total" with a "!" for incomplete
+// series.
+func render(all []*Series) string {
+	var parts []string
+	for _, s := range all {
+		cover := "-"
+		if s.Cover != nil {
+			cover = s.Cover.MessageID
+		}
+		var ids []string
+		for _, p := range s.Patches {
+			ids = append(ids, p.MessageID)
+		}
+		part := fmt.Sprintf("%s[%s]/%d", cover, strings.Join(ids, " "), s.Total)
+		if !s.Complete() {
+			part += "!"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestGroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		patches []*Patch
+		want    string
+	}{
+		{
+			name: "cover letter and patches in one thread",
+			patches: []*Patch{
+				patch("p2", 1, "a@x", 1, 2, 2, 2),
+				patch("c", 1, "a@x", 1, 0, 2, 0),
+				patch("p1", 1, "a@x", 1, 1, 2, 1),
+			},
+			want: "c[p1 p2]/2",
+		},
+		{
+			name: "incomplete series",
+			patches: []*Patch{
+				patch("c", 1, "a@x", 1, 0, 3, 0),
+				patch("p1", 1, "a@x", 1, 1, 3, 1),
+				patch("p3", 1, "a@x", 1, 3, 3, 3),
+			},
+			want: "c[p1 p3]/3!",
+		},
+		{
+			name: "series without cover letter",
+			patches: []*Patch{
+				patch("p1", 1, "a@x", 2, 1, 2, 0),
+				patch("p2", 1, "a@x", 2, 2, 2, 1),
+			},
+			want: "-[p1 p2]/2",
+		},
+		{
+			name: "single patches are their own series",
+			patches: []*Patch{
+				patch("s1", 1, "a@x", 1, 0, 0, 0),
+				patch("s2", 2, "a@x", 1, 0, 0, 5),
+			},
+			want: "-[s1]/1 -[s2]/1",
+		},
+		{
+			name: "v2 posted in reply to v1 is a separate series",
+			patches: []*Patch{
+				patch("v1p1", 1, "a@x", 1, 1, 1, 0),
+				patch("v2p1", 1, "a@x", 2, 1, 1, 3600),
+			},
+			want: "-[v1p1]/1 -[v2p1]/1",
+		},
+		{
+			name: "resend in the same thread starts a new series",
+			patches: []*Patch{
+				patch("c", 1, "a@x", 1, 0, 2, 0),
+				patch("p1", 1, "a@x", 1, 1, 2, 1),
+				patch("p2", 1, "a@x", 1, 2, 2, 2),
+				patch("rc", 1, "a@x", 1, 0, 2, 7200),
+				patch("rp1", 1, "a@x", 1, 1, 2, 7201),
+				patch("rp2", 1, "a@x", 1, 2, 2, 7202),
+			},
+			want: "c[p1 p2]/2 rc[rp1 rp2]/2",
+		},
+		{
+			name: "other senders in the thread are kept apart",
+			patches: []*Patch{
+				patch("p1", 1, "a@x", 1, 1, 2, 0),
+				patch("p2", 1, "a@x", 1, 2, 2, 1),
+				patch("fix", 1, "b@x", 1, 1, 2, 60),
+			},
+			want: "-[p1 p2]/2 -[fix]/2!",
+		},
+		{
+			name: "unthreaded patches joined by numbering",
+			patches: []*Patch{
+				patch("p1", 0, "a@x", 1, 1, 3, 0),
+				patch("p3", 0, "a@x", 1, 3, 3, 2),
+				patch("p2", 0, "A@x", 1, 2, 3, 1),
+			},
+			want: "-[p1 p2 p3]/3",
+		},
+		{
+			name: "unthreaded patches far apart stay separate",
+			patches: []*Patch{
+				patch("p1", 0, "a@x", 1, 1, 2, 0),
+				patch("p2", 0, "a@x", 1, 2, 2, 3600),
+			},
+			want: "-[p1]/2! -[p2]/2!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := render(Group(tt.patches)); got != tt.want {
+				t.Errorf("Group() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeriesRows(t *testing.T) {
+	c := patch("c", 1, "a@x", 3, 0, 2, 0)
+	p2 := patch("p2", 1, "a@x", 3, 2, 2, 2)
+	c.DocID, p2.DocID = 10, 12
+	single := patch("s", 2, "b@x", 1, 0, 0, 10)
+	single.DocID = 20
+
+	series, patches := seriesRows(Group([]*Patch{c, p2, single}))
+	if len(series) != 2 {
+		t.Fatalf("got %d series, want 2", len(series))
+	}
+	if s := series[0]; s.RootMessageID != "c" || s.Lineage != "c" || s.Version != 3 || s.Total != 2 || s.Received != 1 || s.Complete {
+		t.Errorf("series 0 = %+v", s)
+	}
+	if s := series[1]; s.RootMessageID != "s" || s.Total != 1 || s.Received != 1 || !s.Complete {
+		t.Errorf("series 1 = %+v", s)
+	}
+
+	var got []string
+	for _, p := range patches {
+		got = append(got, fmt.Sprintf("%s:%d@%d", p.RootMessageID, p.DocID, p.PatchIndex))
+	}
+	if want := "c:10@0 c:12@2 s:20@1"; strings.Join(got, " ") != want {
+		t.Errorf("patches = %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package series
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/alexmorten/patchy/internal/email"
+	"github.com/alexmorten/patchy/internal/mbox"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// WriteMbox writes the patches of the series with the given ID to w as
+// mboxrd, ordered by patch index, ready for git am. The cover letter is left
+// out. With trailers set, review trailers from replies to each patch and to
+// the cover letter are added to the patch, like b4 am does. It returns the
+// IDs of the patches that had trailers to add but are written unchanged,
+// because email.AddTrailers cannot edit them.
+func WriteMbox(ctx context.Context, queries *db.Queries, w io.Writer, seriesID int64, trailers bool) ([]int64, error) {
+	messages, err := queries.ListSeriesMessages(ctx, seriesID)
+	if err != nil {
+		return nil, err
+	}
+
+	var coverTrailers []email.Trailer
+	if trailers {
+		for _, m := range messages {
+			if m.PatchIndex != 0 {
+				continue
+			}
+			if coverTrailers, err = ReviewTrailers(ctx, queries, m.DocID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	out := mbox.NewWriter(w)
+	var unchanged []int64
+	for _, m := range messages {
+		if m.PatchIndex == 0 {
+			continue
+		}
+		raw := m.Raw
+		if trailers {
+			review, err := ReviewTrailers(ctx, queries, m.DocID)
+			if err != nil {
+				return nil, err
+			}
+			var added bool
+			if raw, added = addTrailers(raw, append(review, coverTrailers...)); !added {
+				unchanged = append(unchanged, m.DocID)
+			}
+		}
+		if err := out.WriteMessage(raw); err != nil {
+			return nil, err
+		}
+	}
+	return unchanged, out.Flush()
+}
+
+// WriteMessageMbox writes a single message to w as mboxrd. With trailers set,
+// a patch gets the review trailers of its replies and of the cover letter of
+// its series. It returns the message's ID if it had trailers to add but is
+// written unchanged, like WriteMbox.
+func WriteMessageMbox(ctx context.Context, queries *db.Queries, w io.Writer, doc db.Doc, trailers bool) ([]int64, error) {
+	raw := doc.Raw
+	var unchanged []int64
+	if trailers && doc.IsPatch {
+		review, err := ReviewTrailers(ctx, queries, doc.ID)
+		if err != nil {
+			return nil, err
+		}
+		cover, err := coverTrailers(ctx, queries, doc.ID)
+		if err != nil {
+			return nil, err
+		}
+		var added bool
+		if raw, added = addTrailers(raw, append(review, cover...)); !added {
+			unchanged = append(unchanged, doc.ID)
+		}
+	}
+
+	out := mbox.NewWriter(w)
+	if err := out.WriteMessage(raw); err != nil {
+		return nil, err
+	}
+	return unchanged, out.Flush()
+}
+
+// addTrailers is email.AddTrailers, except that having no trailers to add
+// counts as success for messages it cannot edit.
+func addTrailers(raw []byte, trailers []email.Trailer) ([]byte, bool) {
+	if len(trailers) == 0 {
+		return raw, true
+	}
+	return email.AddTrailers(raw, trailers)
+}
+
+// ReviewTrailers returns the trailers given in replies to the patch with the
+// given doc ID. Signed-off-by is only ever added by the author or whoever
+// applies the patch, so it is not taken from replies.
+func ReviewTrailers(ctx context.Context, queries *db.Queries, docID int64) ([]email.Trailer, error) {
+	rows, err := queries.ListPatchTrailers(ctx, pgtype.Int8{Int64: docID, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+	var trailers []email.Trailer
+	for _, t := range rows {
+		if t.DocID == docID || t.Key == "Signed-off-by" {
+			continue
+		}
+		trailers = append(trailers, email.Trailer{Key: t.Key, Name: t.Name, Email: t.Email, Value: t.Value})
+	}
+	return trailers, nil
+}
+
+// coverTrailers returns the review trailers given on the cover letter of the
+// series the patch belongs to, which apply to every patch of the series.
+func coverTrailers(ctx context.Context, queries *db.Queries, docID int64) ([]email.Trailer, error) {
+	patch, err := queries.GetSeriesPatchByDocID(ctx, docID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	patches, err := queries.ListSeriesPatches(ctx, patch.SeriesID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range patches {
+		if p.PatchIndex == 0 {
+			return ReviewTrailers(ctx, queries, p.DocID)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,109 @@
+package series
+
+import (
+	"context"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const loadPageSize = 10000
+
+// Rebuild groups every patch in docs into series and replaces the stored
+// series. Threads should be rebuilt first, since patches are grouped by
+// thread. It returns the number of series.
+func Rebuild(ctx context.Context, conn *pgx.Conn) (int, error) {
+	patches, err := loadPatches(ctx, db.New(conn))
+	if err != nil {
+		return 0, err
+	}
+	series, members := seriesRows(Group(patches))
+	if err := db.ReplaceSeries(ctx, conn, series, members); err != nil {
+		return 0, err
+	}
+	return len(series), nil
+}
+
+func loadPatches(ctx context.Context, queries *db.Queries) ([]*Patch, error) {
+	var patches []*Patch
+	var after int64
+	for {
+		rows, err := queries.ListSeriesCandidates(ctx, db.ListSeriesCandidatesParams{
+			ID:    after,
+			Limit: loadPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			patches = append(patches, &Patch{
+				DocID:     row.ID,
+				MessageID: row.MessageID,
+				ThreadID:  row.ThreadID.Int64,
+				Subject:   row.CleanSubject,
+				FromName:  row.FromName,
+				FromEmail: row.FromEmail,
+				Date:      row.SentAt.Time,
+				Version:   int(row.PatchVersion),
+				Index:     int(row.PatchIndex),
+				Total:     int(row.PatchTotal),
+				ChangeID:  row.ChangeID,
+				Files:     row.TouchedFiles,
+			})
+		}
+		if len(rows) < loadPageSize {
+			return patches, nil
+		}
+		after = rows[len(rows)-1].ID
+	}
+}
+
+// seriesRows turns series into rows. Every series records the root message
+// of the first version in its lineage.
+func seriesRows(all []*Series) ([]db.SeriesParams, []db.SeriesPatchParams) {
+	lineage := make(map[*Series]string, len(all))
+	for _, versions := range Lineages(all) {
+		for _, s := range versions {
+			lineage[s] = versions[0].Root().MessageID
+		}
+	}
+
+	rows := make([]db.SeriesParams, 0, len(all))
+	var patches []db.SeriesPatchParams
+	for _, s := range all {
+		root := s.Root()
+		row := db.SeriesParams{
+			RootMessageID: root.MessageID,
+			Subject:       s.Subject(),
+			FromName:      root.FromName,
+			FromEmail:     root.FromEmail,
+			Version:       int32(s.Version),
+			Total:         int32(s.Total),
+			Received:      int32(s.Received()),
+			Complete:      s.Complete(),
+			ChangeID:      s.ChangeID(),
+			Lineage:       lineage[s],
+		}
+		if d := s.Date(); !d.IsZero() {
+			row.SentAt = pgtype.Timestamptz{Time: d, Valid: true}
+		}
+		rows = append(rows, row)
+
+		if s.Cover != nil {
+			patches = append(patches, db.SeriesPatchParams{RootMessageID: root.MessageID, DocID: s.Cover.DocID})
+		}
+		for _, p := range s.Patches {
+			index := p.Index
+			if s.Total == 1 {
+				index = 1
+			}
+			patches = append(patches, db.SeriesPatchParams{
+				RootMessageID: root.MessageID,
+				DocID:         p.DocID,
+				PatchIndex:    int32(index),
+			})
+		}
+	}
+	return rows, patches
+}
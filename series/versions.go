@@ -0,0 +1,190 @@
+package series
+
+import (
+	"sort"
+	"strings"
+)
+
+// minSubjectSimilarity is the share of subject words two series must have in
+// common to be taken for versions of each other.
+const minSubjectSimilarity = 0.6
+
+// ChangeID is the change-id of the series, taken from the cover letter or
+// else the first patch that has one.
+func (s *Series) ChangeID() string {
+	if s.Cover != nil && s.Cover.ChangeID != "" {
+		return s.Cover.ChangeID
+	}
+	for _, p := range s.Patches {
+		if p.ChangeID != "" {
+			return p.ChangeID
+		}
+	}
+	return ""
+}
+
+// changeIDs are the change-ids of every mail in the series. Gerrit style
+// Change-Id trailers are per patch, so any one of them links two series.
+func (s *Series) changeIDs() []string {
+	var ids []string
+	for _, p := range append([]*Patch{s.Cover}, s.Patches...) {
+		if p != nil && p.ChangeID != "" {
+			ids = append(ids, p.ChangeID)
+		}
+	}
+	return ids
+}
+
+// Files are the files touched by the patches of the series.
+func (s *Series) Files() map[string]bool {
+	files := make(map[string]bool)
+	for _, p := range s.Patches {
+		for _, f := range p.Files {
+			files[f] = true
+		}
+	}
+	return files
+}
+
+// Lineages groups series that are versions of the same change. Series are
+// linked when they share a change-id, or else when they come from the same
+// author, have similar subjects, touch some of the same files and carry
+// different version numbers with the later version sent later. Series with
+// the same version, such as a RESEND, are only linked by change-id. Each lineage
+// is ordered by version and date.
+func Lineages(all []*Series) [][]*Series {
+	parent := make([]int, len(all))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(i, j int) {
+		if ri, rj := find(i), find(j); ri != rj {
+			parent[rj] = ri
+		}
+	}
+
+	byChangeID := make(map[string]int)
+	byAuthor := make(map[string][]int)
+	for i, s := range all {
+		for _, id := range s.changeIDs() {
+			if j, ok := byChangeID[id]; ok {
+				union(j, i)
+			} else {
+				byChangeID[id] = i
+			}
+		}
+		author := strings.ToLower(s.Root().FromEmail)
+		if author != "" {
+			byAuthor[author] = append(byAuthor[author], i)
+		}
+	}
+
+	// Versions must touch a common file, so an author's series are only
+	// compared with the earlier ones in the buckets of their files.
+	for _, indexes := range byAuthor {
+		infos := make([]versionInfo, len(indexes))
+		byFile := make(map[string][]int)
+		for a, i := range indexes {
+			infos[a] = newVersionInfo(all[i])
+			compared := make(map[int]bool)
+			for f := range infos[a].files {
+				for _, b := range byFile[f] {
+					if compared[b] {
+						continue
+					}
+					compared[b] = true
+					if find(indexes[b]) != find(i) && infos[b].isVersionOf(infos[a]) {
+						union(indexes[b], i)
+					}
+				}
+				byFile[f] = append(byFile[f], a)
+			}
+		}
+	}
+
+	groups := make(map[int][]*Series)
+	var roots []int
+	for i, s := range all {
+		r := find(i)
+		if _, ok := groups[r]; !ok {
+			roots = append(roots, r)
+		}
+		groups[r] = append(groups[r], s)
+	}
+
+	lineages := make([][]*Series, 0, len(roots))
+	for _, r := range roots {
+		group := groups[r]
+		sort.SliceStable(group, func(i, j int) bool {
+			if group[i].Version != group[j].Version {
+				return group[i].Version < group[j].Version
+			}
+			return group[i].Date().Before(group[j].Date())
+		})
+		lineages = append(lineages, group)
+	}
+	return lineages
+}
+
+// versionInfo caches what the heuristic compares.
+type versionInfo struct {
+	series *Series
+	words  map[string]bool
+	files  map[string]bool
+}
+
+func newVersionInfo(s *Series) versionInfo {
+	words := make(map[string]bool)
+	for _, w := range strings.Fields(strings.ToLower(s.Subject())) {
+		words[w] = true
+	}
+	return versionInfo{series: s, words: words, files: s.Files()}
+}
+
+// isVersionOf reports whether a and b are versions of the same change. A
+// series posted again with the same version, such as a RESEND, only counts
+// when the change-ids match: by subject and files alone it cannot be told
+// from another series.
+func (a versionInfo) isVersionOf(b versionInfo) bool {
+	if a.series.Version == b.series.Version {
+		id := a.series.ChangeID()
+		return id != "" && id == b.series.ChangeID()
+	}
+	older, newer := a.series, b.series
+	if older.Version > newer.Version {
+		older, newer = newer, older
+	}
+	if od, nd := older.Date(), newer.Date(); !od.IsZero() && !nd.IsZero() && nd.Before(od) {
+		return false
+	}
+	return jaccard(a.words, b.words) >= minSubjectSimilarity && overlaps(a.files, b.files)
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for w := range a {
+		if b[w] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+func overlaps(a, b map[string]bool) bool {
+	for f := range a {
+		if b[f] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package series
+
+import (
+	"strings"
+	"testing"
+)
+
+func single(id, from, subject string, version int, days int, files ...string) *Series {
+	p := patch(id, 0, from, version, 1, 1, days*86400)
+	p.Subject = subject
+	p.Files = files
+	return &Series{Patches: []*Patch{p}, Version: version, Total: 1}
+}
+
+func renderLineages(lineages [][]*Series) string {
+	var parts []string
+	for _, versions := range lineages {
+		var ids []string
+		for _, s := range versions {
+			ids = append(ids, s.Root().MessageID)
+		}
+		parts = append(parts, strings.Join(ids, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestLineages(t *testing.T) {
+	withChangeID := func(s *Series, id string) *Series {
+		s.Patches[0].ChangeID = id
+		return s
+	}
+
+	tests := []struct {
+		name   string
+		series []*Series
+		want   string
+	}{
+		{
+			name: "change-id links different subjects",
+			series: []*Series{
+				withChangeID(single("v1", "a@x", "mm: add frob", 1, 0), "20240301-frob"),
+				withChangeID(single("v2", "b@x", "mm: add frobnicator helper", 2, 7), "20240301-frob"),
+			},
+			want: "v1,v2",
+		},
+		{
+			name: "heuristic match on author, subject and files",
+			series: []*Series{
+				single("v3", "a@x", "mm: fix slab leak in frob path", 3, 14, "mm/slab.c"),
+				single("v1", "a@x", "mm: fix slab leak in frob", 1, 0, "mm/slab.c", "mm/frob.c"),
+				single("v2", "A@x", "mm: fix slab leak in frob", 2, 7, "mm/slab.c"),
+			},
+			want: "v1,v2,v3",
+		},
+		{
+			name: "different author",
+			series: []*Series{
+				single("v1", "a@x", "mm: fix slab leak", 1, 0, "mm/slab.c"),
+				single("v2", "b@x", "mm: fix slab leak", 2, 7, "mm/slab.c"),
+			},
+			want: "v1 v2",
+		},
+		{
+			name: "no shared files",
+			series: []*Series{
+				single("v1", "a@x", "fix typo", 1, 0, "mm/slab.c"),
+				single("v2", "a@x", "fix typo", 2, 7, "net/core/dev.c"),
+			},
+			want: "v1 v2",
+		},
+		{
+			name: "dissimilar subjects",
+			series: []*Series{
+				single("v1", "a@x", "mm: fix slab leak", 1, 0, "mm/slab.c"),
+				single("v2", "a@x", "mm: rework slab allocator statistics", 2, 7, "mm/slab.c"),
+			},
+			want: "v1 v2",
+		},
+		{
+			name: "same version is not a revision",
+			series: []*Series{
+				single("a", "a@x", "mm: fix slab leak", 1, 0, "mm/slab.c"),
+				single("b", "a@x", "mm: fix slab leak", 1, 7, "mm/slab.c"),
+			},
+			want: "a b",
+		},
+		{
+			name: "resend with the same change-id",
+			series: []*Series{
+				withChangeID(single("a", "a@x", "mm: fix slab leak", 2, 0, "mm/slab.c"), "20240301-slab"),
+				withChangeID(single("b", "a@x", "mm: fix slab leak", 2, 7, "mm/slab.c"), "20240301-slab"),
+			},
+			want: "a,b",
+		},
+		{
+			name: "higher version sent earlier is not a revision",
+			series: []*Series{
+				single("v2", "a@x", "mm: fix slab leak", 2, 0, "mm/slab.c"),
+				single("v1", "a@x", "mm: fix slab leak", 1, 7, "mm/slab.c"),
+			},
+			want: "v2 v1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderLineages(Lineages(tt.series)); got != tt.want {
+				t.Errorf("Lineages() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsVersionOfSameVersion(t *testing.T) {
+	info := func(id, changeID string) versionInfo {
+		s := single(id, "a@x", "mm: fix slab leak", 2, 0, "mm/slab.c")
+		s.Patches[0].ChangeID = changeID
+		return newVersionInfo(s)
+	}
+
+	tests := []struct {
+		name    string
+		a, b    versionInfo
+		matches bool
+	}{
+		{"same change-id", info("a", "20240301-slab"), info("b", "20240301-slab"), true},
+		{"different change-id", info("a", "20240301-slab"), info("b", "20240302-slab"), false},
+		{"no change-id", info("a", ""), info("b", ""), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.isVersionOf(tt.b); got != tt.matches {
+				t.Errorf("isVersionOf() = %v, want %v", got, tt.matches)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/alexmorten/patchy/series"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Server) addMboxRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/series/{id}/mbox", s.seriesMboxHandler)
+	mux.HandleFunc("GET /api/result/{id}/raw", s.resultRawHandler)
+}
+
+// seriesMboxHandler returns the patches of a series as an mboxrd file that
+// git am can apply. With trailers=1, review trailers from replies are added
+// to the patches.
+func (s *Server) seriesMboxHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	trailers, err := trailersParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.config.Querier.GetSeries(r.Context(), id); errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	unchanged, err := series.WriteMbox(r.Context(), s.config.Querier, &buf, id, trailers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeMbox(w, fmt.Sprintf("series-%d.mbox", id), buf.Bytes(), unchanged)
+}
+
+// resultRawHandler returns the original message as an mboxrd file. With
+// trailers=1, review trailers are added like for series.
+func (s *Server) resultRawHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	trailers, err := trailersParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	doc, err := s.config.Querier.GetDocumentByID(r.Context(), id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	unchanged, err := series.WriteMessageMbox(r.Context(), s.config.Querier, &buf, doc, trailers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeMbox(w, fmt.Sprintf("%d.mbox", id), buf.Bytes(), unchanged)
+}
+
+func trailersParam(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("trailers")
+	if value == "" {
+		return false, nil
+	}
+	trailers, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid trailers value %q", value)
+	}
+	return trailers, nil
+}
+
+// unchangedHeader lists the IDs of the patches in an mbox response that
+// trailers could not be added to. patchy am reports them.
+const unchangedHeader = "X-Patchy-Trailers-Unchanged"
+
+// writeMbox sends data as filename, listing the unchanged patch IDs in
+// unchangedHeader.
+func writeMbox(w http.ResponseWriter, filename string, data []byte, unchanged []int64) {
+	w.Header().Set("Content-Type", "application/mbox")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	for _, id := range unchanged {
+		w.Header().Add(unchangedHeader, strconv.FormatInt(id, 10))
+	}
+	if _, err := w.Write(data); err != nil {
+		fmt.Println("error", err)
+	}
+}
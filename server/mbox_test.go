@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexmorten/patchy/db"
+)
+
+func TestResultRawTrailers(t *testing.T) {
+	plain := "From: Jane Doe <jane@example.com>\nSubject: [PATCH] mm: one\n\nFix it.\n\nSigned-off-by: Jane Doe <jane@example.com>\n---\n"
+	encoded := "From: Jane Doe <jane@example.com>\nSubject: [PATCH] mm: one\nContent-Transfer-Encoding: base64\n\nRml4IGl0Lgo=\n"
+	review := db.Trailer{DocID: 2, Key: "Reviewed-by", Name: "Joe", Email: "joe@example.com", Value: "Joe <joe@example.com>"}
+
+	tests := []struct {
+		name      string
+		raw       string
+		wantAdded bool
+		unchanged []string
+	}{
+		{"plain text", plain, true, nil},
+		{"base64", encoded, false, []string{"1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTestServer(map[string][]any{
+				"GetDocumentByID":   {db.Doc{ID: 1, Raw: []byte(tt.raw), IsPatch: true}},
+				"ListPatchTrailers": {review},
+			})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/result/1/raw?trailers=1", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if added := strings.Contains(rec.Body.String(), "Reviewed-by: Joe <joe@example.com>"); added != tt.wantAdded {
+				t.Errorf("trailer added = %v, want %v:\n%s", added, tt.wantAdded, rec.Body)
+			}
+			if got := rec.Header().Values(unchangedHeader); !reflect.DeepEqual(got, tt.unchanged) {
+				t.Errorf("%s = %v, want %v", unchangedHeader, got, tt.unchanged)
+			}
+		})
+	}
+}
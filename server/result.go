@@ -5,16 +5,38 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type ResultDetail struct {
-	ID   string `json:"id"`
-	Text string `json:"text"`
-	URL  string `json:"url"`
+	ID           string   `json:"id"`
+	Text         string   `json:"text"`
+	URL          string   `json:"url"`
+	ThreadID     string   `json:"thread_id,omitempty"`
+	SeriesID     string   `json:"series_id,omitempty"`
+	Files        []string `json:"files,omitempty"`
+	LinesAdded   int32    `json:"lines_added"`
+	LinesRemoved int32    `json:"lines_removed"`
+	// Trailers are those of the patch itself followed by the ones given in
+	// replies to it.
+	Trailers []ResultTrailer `json:"trailers,omitempty"`
+}
+
+type ResultTrailer struct {
+	Key   string `json:"key"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	Value string `json:"value"`
+	// FromID is the message the trailer was found in when it is a reply.
+	FromID string `json:"from_id,omitempty"`
 }
 
 func (s *Server) addResultRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/result/{id}", s.jsonResultHandler)
+	mux.HandleFunc("GET /api/message/{message_id...}", s.jsonMessageHandler)
 }
 
 func (s *Server) jsonResultHandler(w http.ResponseWriter, r *http.Request) {
@@ -38,13 +60,64 @@ func (s *Server) jsonResultHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.writeResult(w, r, doc)
+}
+
+// jsonMessageHandler is jsonResultHandler for a Message-ID, with or without
+// angle brackets.
+func (s *Server) jsonMessageHandler(w http.ResponseWriter, r *http.Request) {
+	messageID := strings.Trim(r.PathValue("message_id"), "<>")
+	if messageID == "" {
+		http.Error(w, "Message-ID is required", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := s.config.Querier.GetDocumentByMessageID(r.Context(), messageID)
+	if err != nil {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+
+	s.writeResult(w, r, doc)
+}
+
+func (s *Server) writeResult(w http.ResponseWriter, r *http.Request, doc db.Doc) {
+	id := strconv.FormatInt(doc.ID, 10)
+
 	// Sanitize the text
 	sanitizedText := s.sanitizerPolicy.Sanitize(doc.Text)
 
 	result := ResultDetail{
-		ID:   id,
-		Text: sanitizedText,
-		URL:  doc.Url,
+		ID:           id,
+		Text:         sanitizedText,
+		URL:          doc.Url,
+		Files:        doc.TouchedFiles,
+		LinesAdded:   doc.LinesAdded,
+		LinesRemoved: doc.LinesRemoved,
+	}
+
+	// Messages ingested since the last thread rebuild have no thread yet.
+	member, err := s.config.Querier.GetThreadMemberByDocID(r.Context(), pgtype.Int8{Int64: doc.ID, Valid: true})
+	if err == nil {
+		result.ThreadID = strconv.FormatInt(member.ThreadID, 10)
+	}
+	if patch, err := s.config.Querier.GetSeriesPatchByDocID(r.Context(), doc.ID); err == nil {
+		result.SeriesID = strconv.FormatInt(patch.SeriesID, 10)
+	}
+
+	if doc.IsPatch {
+		trailers, err := s.config.Querier.ListPatchTrailers(r.Context(), pgtype.Int8{Int64: doc.ID, Valid: true})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, t := range trailers {
+			trailer := ResultTrailer{Key: t.Key, Name: t.Name, Email: t.Email, Value: t.Value}
+			if t.DocID != doc.ID {
+				trailer.FromID = strconv.FormatInt(t.DocID, 10)
+			}
+			result.Trailers = append(result.Trailers, trailer)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
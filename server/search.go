@@ -1,23 +1,68 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 
-	"github.com/meilisearch/meilisearch-go"
+	"github.com/alexmorten/patchy/search"
 )
 
 type SearchResult struct {
-	ID   string `json:"id"`
-	Text string `json:"text"`
-	URL  string `json:"url"`
+	ID      string      `json:"id"`
+	Text    string      `json:"text"`
+	URL     string      `json:"url"`
+	Subject string      `json:"subject,omitempty"`
+	Patch   *PatchShape `json:"patch,omitempty"`
+}
+
+// PatchShape is what the subject tags say about a patch mail.
+type PatchShape struct {
+	Prefixes []string `json:"prefixes"`
+	Tree     string   `json:"tree,omitempty"`
+	Version  int      `json:"version"`
+	Index    int      `json:"index"`
+	Total    int      `json:"total"`
+}
+
+// SearchResponse is the envelope of /api/v1/search.
+type SearchResponse struct {
+	Total            int64                       `json:"total"`
+	Page             int64                       `json:"page"`
+	PerPage          int64                       `json:"per_page"`
+	TotalPages       int64                       `json:"total_pages"`
+	ProcessingTimeMs int64                       `json:"processing_time_ms"`
+	Hits             []SearchResult              `json:"hits"`
+	Facets           map[string]map[string]int64 `json:"facets"`
+}
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// searchFacets are the attributes /api/v1/search counts values of.
+var searchFacets = []string{"IsPatch", "PatchTree", "PatchVersion", "PatchPrefixes"}
+
+// configureSearchIndex sets up the search backend. Search being unavailable
+// does not stop the server, since threads and series are served from Postgres.
+func (s *Server) configureSearchIndex() {
+	if err := s.searchBackend.Setup(context.Background()); err != nil {
+		log.Printf("Failed to configure search index: %v", err)
+	}
 }
 
 func (s *Server) addSearchRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/search", s.jsonSearchHandler)
+	mux.HandleFunc("GET /api/v1/search", s.jsonSearchV1Handler)
 }
 
+// jsonSearchHandler returns the ten best hits as a bare array. It is kept
+// for existing clients; new ones use /api/v1/search.
 func (s *Server) jsonSearchHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
 	if query == "" {
@@ -25,33 +70,193 @@ func (s *Server) jsonSearchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	searchRes, err := s.searchClient.Index("documents").Search(query,
-		&meilisearch.SearchRequest{
-			AttributesToHighlight: []string{"Text"},
-			Limit:                 10,
-		})
+	text, filters, err := searchFilter(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	results := make([]SearchResult, 0, len(searchRes.Hits))
-	for _, hit := range searchRes.Hits {
-		hitMap := hit.(map[string]interface{})
-		formatted := hitMap["_formatted"].(map[string]interface{})
-		formattedText := formatted["Text"].(string)
-		sanitizedText := s.sanitizerPolicy.Sanitize(formattedText)
-
-		results = append(results, SearchResult{
-			ID:   fmt.Sprintf("%d", int(hitMap["ID"].(float64))),
-			Text: sanitizedText,
-			URL:  hitMap["Url"].(string),
-		})
+	searchRes, err := s.searchBackend.Search(r.Context(), &search.Request{
+		Text:    text,
+		Filters: filters,
+		Page:    1,
+		PerPage: 10,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	results := s.searchResults(searchRes.Hits)
+
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(results); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		log.Printf("Failed to encode search response: %v", err)
+	}
+}
+
+// jsonSearchV1Handler is the paginated search. On top of the parameters of
+// jsonSearchHandler it takes page (from 1), per_page and
+// sort=relevance|date:desc|date:asc. An empty q lists everything the
+// filters match. Only the first search.MaxHits hits can be paged through.
+func (s *Server) jsonSearchV1Handler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	text, filters, err := searchFilter(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	perPage, err := intParam(params, "per_page", defaultPerPage, 1, maxPerPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxPage := search.MaxHits / perPage
+	page, err := intParam(params, "page", 1, 1, maxPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort, err := sortParam(params.Get("sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	searchRes, err := s.searchBackend.Search(r.Context(), &search.Request{
+		Text:    text,
+		Filters: filters,
+		Sort:    sort,
+		Page:    page,
+		PerPage: perPage,
+		Facets:  searchFacets,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	response := SearchResponse{
+		Total:            searchRes.Total,
+		Page:             page,
+		PerPage:          perPage,
+		TotalPages:       min(searchRes.TotalPages, maxPage),
+		ProcessingTimeMs: searchRes.ProcessingTime.Milliseconds(),
+		Hits:             s.searchResults(searchRes.Hits),
+		Facets:           searchRes.Facets,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode search response: %v", err)
+	}
+}
+
+func (s *Server) searchResults(hits []search.Hit) []SearchResult {
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		result := SearchResult{
+			ID:      strconv.FormatInt(hit.ID, 10),
+			Text:    s.sanitizerPolicy.Sanitize(hit.Highlighted),
+			URL:     hit.Url,
+			Subject: hit.Subject,
+		}
+		if hit.IsPatch {
+			result.Patch = patchShape(hit.Document)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// searchFilter parses q and the patch parameters into the free text to
+// search for and the filter to apply. With mode=identifier, words of q that
+// are identifiers only match documents containing exactly that identifier.
+func searchFilter(params url.Values) (string, []search.Filter, error) {
+	parsed, err := search.ParseQuery(params.Get("q"))
+	if err != nil {
+		return "", nil, err
+	}
+	switch mode := params.Get("mode"); mode {
+	case "", "text":
+	case "identifier":
+		parsed.ExactIdentifiers()
+	default:
+		return "", nil, fmt.Errorf("invalid mode %q, want text or identifier", mode)
+	}
+	filter, err := patchFilter(params)
+	if err != nil {
+		return "", nil, err
+	}
+	return parsed.Text, append(filter, parsed.Filters...), nil
+}
+
+// intParam reads an integer parameter. A max of 0 means no upper bound.
+func intParam(params url.Values, name string, fallback, min, max int64) (int64, error) {
+	v := params.Get(name)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < min || (max > 0 && n > max) {
+		if max > 0 {
+			return 0, fmt.Errorf("%s must be between %d and %d", name, min, max)
+		}
+		return 0, fmt.Errorf("%s must be at least %d", name, min)
+	}
+	return n, nil
+}
+
+func sortParam(v string) (search.Sort, error) {
+	switch v {
+	case "", "relevance":
+		return search.SortRelevance, nil
+	case "date:desc", "date":
+		return search.SortDateDesc, nil
+	case "date:asc":
+		return search.SortDateAsc, nil
+	}
+	return 0, fmt.Errorf("invalid sort %q, want relevance, date:desc or date:asc", v)
+}
+
+// patchFilter turns the patch query parameters into search filters:
+// patch=true limits results to patch mails, version=N, tree=NAME and
+// prefix=TAG (repeatable) narrow them further.
+func patchFilter(params url.Values) ([]search.Filter, error) {
+	var filter []search.Filter
+	if v := params.Get("patch"); v != "" {
+		patch, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid patch parameter %q", v)
+		}
+		filter = append(filter, search.Equals("IsPatch", patch))
+	}
+	if v := params.Get("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version parameter %q", v)
+		}
+		filter = append(filter, search.Equals("PatchVersion", int64(version)))
+	}
+	if v := params.Get("tree"); v != "" {
+		filter = append(filter, search.Equals("PatchTree", v))
+	}
+	for _, v := range params["prefix"] {
+		filter = append(filter, search.Equals("PatchPrefixes", v))
+	}
+	return filter, nil
+}
+
+func patchShape(doc search.Document) *PatchShape {
+	shape := &PatchShape{
+		Prefixes: doc.PatchPrefixes,
+		Tree:     doc.PatchTree,
+		Version:  int(doc.PatchVersion),
+		Index:    int(doc.PatchIndex),
+		Total:    int(doc.PatchTotal),
+	}
+	if shape.Prefixes == nil {
+		shape.Prefixes = []string{}
+	}
+	return shape
 }
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/alexmorten/patchy/search"
+)
+
+// fakeBackend answers every search with result and keeps the last request.
+type fakeBackend struct {
+	result  search.Result
+	request *search.Request
+}
+
+func (b *fakeBackend) Setup(ctx context.Context) error                         { return nil }
+func (b *fakeBackend) Index(ctx context.Context, docs []search.Document) error { return nil }
+func (b *fakeBackend) Delete(ctx context.Context, ids []int64) error           { return nil }
+
+func (b *fakeBackend) Search(ctx context.Context, req *search.Request) (*search.Result, error) {
+	b.request = req
+	result := b.result
+	return &result, nil
+}
+
+func newSearchServer(backend *fakeBackend) http.Handler {
+	s := NewServer(ServerConfig{Querier: db.New(&fakeDB{}), SearchBackend: backend})
+	return s.setupRoutes()
+}
+
+func TestSearchV1Envelope(t *testing.T) {
+	backend := &fakeBackend{result: search.Result{
+		Hits: []search.Hit{
+			{Document: search.Document{ID: 7, Url: "a@x", Subject: "[PATCH net v2 1/3] tcp: fix", IsPatch: true,
+				PatchTree: "net", PatchVersion: 2, PatchIndex: 1, PatchTotal: 3}, Highlighted: "<em>tcp</em> <script>x</script>"},
+			{Document: search.Document{ID: 8, Url: "b@x", Subject: "Re: tcp"}, Highlighted: "reply"},
+		},
+		Total:          2,
+		TotalPages:     1,
+		ProcessingTime: 12 * time.Millisecond,
+		Facets:         map[string]map[string]int64{"IsPatch": {"true": 1, "false": 1}},
+	}}
+	handler := newSearchServer(backend)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/search?q=tcp&patch=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k := range envelope {
+		keys = append(keys, k)
+	}
+	for _, k := range []string{"total", "page", "per_page", "total_pages", "processing_time_ms", "hits", "facets"} {
+		if _, ok := envelope[k]; !ok {
+			t.Errorf("envelope has no %q, got %v", k, keys)
+		}
+	}
+
+	var resp SearchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := SearchResponse{
+		Total:            2,
+		Page:             1,
+		PerPage:          defaultPerPage,
+		TotalPages:       1,
+		ProcessingTimeMs: 12,
+		Hits: []SearchResult{
+			{ID: "7", Text: "<em>tcp</em> ", URL: "a@x", Subject: "[PATCH net v2 1/3] tcp: fix",
+				Patch: &PatchShape{Prefixes: []string{}, Tree: "net", Version: 2, Index: 1, Total: 3}},
+			{ID: "8", Text: "reply", URL: "b@x", Subject: "Re: tcp"},
+		},
+		Facets: map[string]map[string]int64{"IsPatch": {"true": 1, "false": 1}},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+
+	req := backend.request
+	if req.Text != "tcp" || req.Page != 1 || req.PerPage != defaultPerPage || req.Sort != search.SortRelevance ||
+		!reflect.DeepEqual(req.Facets, searchFacets) {
+		t.Errorf("request = %+v", req)
+	}
+	if len(req.Filters) != 1 || req.Filters[0].String() != "IsPatch = true" {
+		t.Errorf("filters = %v, want IsPatch = true", req.Filters)
+	}
+}
+
+func TestSearchV1Paging(t *testing.T) {
+	tests := []struct {
+		query      string
+		status     int
+		page       int64
+		perPage    int64
+		sort       search.Sort
+		totalPages int64
+	}{
+		{query: "", status: http.StatusOK, page: 1, perPage: defaultPerPage, totalPages: search.MaxHits / defaultPerPage},
+		{query: "page=3&per_page=50", status: http.StatusOK, page: 3, perPage: 50, totalPages: search.MaxHits / 50},
+		{query: "per_page=100&page=10", status: http.StatusOK, page: 10, perPage: 100, totalPages: search.MaxHits / 100},
+		{query: "per_page=100&page=11", status: http.StatusBadRequest},
+		{query: "per_page=30&page=33", status: http.StatusOK, page: 33, perPage: 30, totalPages: search.MaxHits / 30},
+		{query: "per_page=30&page=34", status: http.StatusBadRequest},
+		{query: "page=0", status: http.StatusBadRequest},
+		{query: "page=x", status: http.StatusBadRequest},
+		{query: "per_page=0", status: http.StatusBadRequest},
+		{query: "per_page=101", status: http.StatusBadRequest},
+		{query: "sort=relevance", status: http.StatusOK, page: 1, perPage: defaultPerPage, sort: search.SortRelevance, totalPages: search.MaxHits / defaultPerPage},
+		{query: "sort=date", status: http.StatusOK, page: 1, perPage: defaultPerPage, sort: search.SortDateDesc, totalPages: search.MaxHits / defaultPerPage},
+		{query: "sort=date:desc", status: http.StatusOK, page: 1, perPage: defaultPerPage, sort: search.SortDateDesc, totalPages: search.MaxHits / defaultPerPage},
+		{query: "sort=date:asc", status: http.StatusOK, page: 1, perPage: defaultPerPage, sort: search.SortDateAsc, totalPages: search.MaxHits / defaultPerPage},
+		{query: "sort=subject", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			// More matches than can be paged through.
+			backend := &fakeBackend{result: search.Result{Total: 5000, TotalPages: 5000}}
+			var resp SearchResponse
+			code := get(t, newSearchServer(backend), "/api/v1/search?q=tcp&"+tt.query, &resp)
+			if code != tt.status {
+				t.Fatalf("status %d, want %d", code, tt.status)
+			}
+			if code != http.StatusOK {
+				if backend.request != nil {
+					t.Error("searched despite the bad request")
+				}
+				return
+			}
+			if resp.Page != tt.page || resp.PerPage != tt.perPage || resp.TotalPages != tt.totalPages || resp.Total != 5000 {
+				t.Errorf("page %d of %d, %d per page, total %d, want page %d of %d, %d per page, total 5000",
+					resp.Page, resp.TotalPages, resp.PerPage, resp.Total, tt.page, tt.totalPages, tt.perPage)
+			}
+			if req := backend.request; req.Page != tt.page || req.PerPage != tt.perPage || req.Sort != tt.sort {
+				t.Errorf("request = %+v", req)
+			}
+		})
+	}
+}
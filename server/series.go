@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+)
+
+type SeriesDetail struct {
+	ID          string         `json:"id"`
+	Subject     string         `json:"subject"`
+	From        string         `json:"from"`
+	Date        string         `json:"date,omitempty"`
+	Version     int32          `json:"version"`
+	Total       int32          `json:"total"`
+	Received    int32          `json:"received"`
+	Complete    bool           `json:"complete"`
+	Missing     []int32        `json:"missing"`
+	CoverLetter *SeriesPatch   `json:"cover_letter"`
+	Patches     []*SeriesPatch `json:"patches"`
+}
+
+type SeriesPatch struct {
+	ID        string `json:"id"`
+	Index     int32  `json:"index"`
+	MessageID string `json:"message_id"`
+	Subject   string `json:"subject"`
+	From      string `json:"from"`
+	Date      string `json:"date,omitempty"`
+}
+
+// SeriesVersion summarizes one revision of a series.
+type SeriesVersion struct {
+	ID       string `json:"id"`
+	Subject  string `json:"subject"`
+	From     string `json:"from"`
+	Date     string `json:"date,omitempty"`
+	Version  int32  `json:"version"`
+	Total    int32  `json:"total"`
+	Received int32  `json:"received"`
+	Complete bool   `json:"complete"`
+	ChangeID string `json:"change_id,omitempty"`
+}
+
+func (s *Server) addSeriesRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/series/{id}", s.jsonSeriesHandler)
+	mux.HandleFunc("GET /api/series/{id}/versions", s.jsonSeriesVersionsHandler)
+}
+
+func (s *Server) jsonSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+
+	series, err := s.config.Querier.GetSeries(r.Context(), id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	patches, err := s.config.Querier.ListSeriesPatches(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := SeriesDetail{
+		ID:       strconv.FormatInt(series.ID, 10),
+		Subject:  series.Subject,
+		From:     formatFrom(series.FromName, series.FromEmail),
+		Version:  series.Version,
+		Total:    series.Total,
+		Received: series.Received,
+		Complete: series.Complete,
+		Missing:  []int32{},
+		Patches:  []*SeriesPatch{},
+	}
+	if series.SentAt.Valid {
+		result.Date = series.SentAt.Time.UTC().Format(time.RFC3339)
+	}
+
+	have := make(map[int32]bool, len(patches))
+	for _, p := range patches {
+		patch := seriesPatch(p)
+		if p.PatchIndex == 0 {
+			result.CoverLetter = patch
+			continue
+		}
+		have[p.PatchIndex] = true
+		result.Patches = append(result.Patches, patch)
+	}
+	for i := int32(1); i <= series.Total; i++ {
+		if !have[i] {
+			result.Missing = append(result.Missing, i)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		fmt.Println("error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func seriesPatch(p db.ListSeriesPatchesRow) *SeriesPatch {
+	patch := &SeriesPatch{
+		ID:        strconv.FormatInt(p.DocID, 10),
+		Index:     p.PatchIndex,
+		MessageID: p.MessageID,
+		Subject:   p.Subject,
+		From:      formatFrom(p.FromName, p.FromEmail),
+	}
+	if p.SentAt.Valid {
+		patch.Date = p.SentAt.Time.UTC().Format(time.RFC3339)
+	}
+	return patch
+}
+
+func (s *Server) jsonSeriesVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+
+	versions, err := s.config.Querier.ListSeriesVersions(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+
+	results := make([]SeriesVersion, 0, len(versions))
+	for _, v := range versions {
+		result := SeriesVersion{
+			ID:       strconv.FormatInt(v.ID, 10),
+			Subject:  v.Subject,
+			From:     formatFrom(v.FromName, v.FromEmail),
+			Version:  v.Version,
+			Total:    v.Total,
+			Received: v.Received,
+			Complete: v.Complete,
+			ChangeID: v.ChangeID,
+		}
+		if v.SentAt.Valid {
+			result.Date = v.SentAt.Time.UTC().Format(time.RFC3339)
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		fmt.Println("error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
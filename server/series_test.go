@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var seriesSentAt = pgtype.Timestamptz{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Valid: true}
+
+func TestSeriesHandler(t *testing.T) {
+	handler := newTestServer(map[string][]any{
+		"GetSeries": {db.Series{ID: 3, Subject: "[PATCH v2 0/3] mm: reclaim", FromName: "Jane Doe", FromEmail: "jane@example.com",
+			Version: 2, Total: 3, Received: 2, SentAt: seriesSentAt}},
+		"ListSeriesPatches": {
+			db.ListSeriesPatchesRow{DocID: 10, PatchIndex: 0, MessageID: "cover@x", Subject: "[PATCH v2 0/3] mm: reclaim", FromEmail: "jane@example.com"},
+			db.ListSeriesPatchesRow{DocID: 11, PatchIndex: 1, MessageID: "one@x", Subject: "[PATCH v2 1/3] mm: one", FromEmail: "jane@example.com", SentAt: seriesSentAt},
+			db.ListSeriesPatchesRow{DocID: 13, PatchIndex: 3, MessageID: "three@x", Subject: "[PATCH v2 3/3] mm: three", FromEmail: "jane@example.com"},
+		},
+	})
+
+	var series SeriesDetail
+	if code := get(t, handler, "/api/series/3", &series); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if series.ID != "3" || series.From != "Jane Doe <jane@example.com>" || series.Date != "2024-01-02T03:04:05Z" ||
+		series.Version != 2 || series.Total != 3 || series.Received != 2 || series.Complete {
+		t.Errorf("series = %+v", series)
+	}
+	if series.CoverLetter == nil || series.CoverLetter.ID != "10" {
+		t.Errorf("cover letter = %+v, want document 10", series.CoverLetter)
+	}
+	want := []*SeriesPatch{
+		{ID: "11", Index: 1, MessageID: "one@x", Subject: "[PATCH v2 1/3] mm: one", From: "jane@example.com", Date: "2024-01-02T03:04:05Z"},
+		{ID: "13", Index: 3, MessageID: "three@x", Subject: "[PATCH v2 3/3] mm: three", From: "jane@example.com"},
+	}
+	if !reflect.DeepEqual(series.Patches, want) {
+		t.Errorf("patches = %+v, want %+v", series.Patches, want)
+	}
+	if !reflect.DeepEqual(series.Missing, []int32{2}) {
+		t.Errorf("missing = %v, want [2]", series.Missing)
+	}
+
+	if code := get(t, handler, "/api/series/x", nil); code != http.StatusBadRequest {
+		t.Errorf("invalid ID: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := get(t, newTestServer(nil), "/api/series/3", nil); code != http.StatusNotFound {
+		t.Errorf("unknown series: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestSeriesVersionsHandler(t *testing.T) {
+	handler := newTestServer(map[string][]any{
+		"ListSeriesVersions": {
+			db.Series{ID: 1, Subject: "[PATCH 0/3] mm: reclaim", FromEmail: "jane@example.com", Version: 1, Total: 3, Received: 3, Complete: true, ChangeID: "abc"},
+			db.Series{ID: 3, Subject: "[PATCH v2 0/3] mm: reclaim", FromEmail: "jane@example.com", Version: 2, Total: 3, Received: 2, SentAt: seriesSentAt, ChangeID: "abc"},
+		},
+	})
+
+	var versions []SeriesVersion
+	if code := get(t, handler, "/api/series/3/versions", &versions); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	want := []SeriesVersion{
+		{ID: "1", Subject: "[PATCH 0/3] mm: reclaim", From: "jane@example.com", Version: 1, Total: 3, Received: 3, Complete: true, ChangeID: "abc"},
+		{ID: "3", Subject: "[PATCH v2 0/3] mm: reclaim", From: "jane@example.com", Date: "2024-01-02T03:04:05Z", Version: 2, Total: 3, Received: 2, ChangeID: "abc"},
+	}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("versions = %+v, want %+v", versions, want)
+	}
+
+	if code := get(t, newTestServer(nil), "/api/series/3/versions", nil); code != http.StatusNotFound {
+		t.Errorf("unknown series: status %d, want %d", code, http.StatusNotFound)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 
 	"github.com/alexmorten/patchy/db"
+	"github.com/alexmorten/patchy/search"
 	"github.com/meilisearch/meilisearch-go"
 	"github.com/microcosm-cc/bluemonday"
 	"golang.org/x/crypto/acme/autocert"
@@ -19,6 +20,9 @@ type ServerConfig struct {
 	Querier         *db.Queries
 	FrontendDir     string
 	MeilisearchURL  string
+	// SearchBackend is used for search. If nil, Meilisearch at
+	// MeilisearchURL is.
+	SearchBackend   search.Backend
 	CertCacheDir    string
 	Host            string
 	Port            string
@@ -26,14 +30,18 @@ type ServerConfig struct {
 
 type Server struct {
 	config          ServerConfig
-	searchClient    meilisearch.ServiceManager
+	searchBackend   search.Backend
 	sanitizerPolicy *bluemonday.Policy
 }
 
 func NewServer(config ServerConfig) *Server {
+	backend := config.SearchBackend
+	if backend == nil {
+		backend = search.NewMeilisearch(meilisearch.New(config.MeilisearchURL))
+	}
 	return &Server{
 		config:          config,
-		searchClient:    meilisearch.New(config.MeilisearchURL),
+		searchBackend:   backend,
 		sanitizerPolicy: bluemonday.NewPolicy().AllowElements("em", "mark"),
 	}
 }
@@ -41,6 +49,7 @@ func NewServer(config ServerConfig) *Server {
 
 
 func (s *Server) ListenAndServe() error {
+	s.configureSearchIndex()
 	handler := s.setupRoutes()
 	
 	if s.config.Domain != "" {
@@ -54,6 +63,9 @@ func (s *Server) setupRoutes() http.Handler {
 	mux := http.NewServeMux()
 	s.addSearchRoutes(mux)
 	s.addResultRoutes(mux)
+	s.addThreadRoutes(mux)
+	s.addSeriesRoutes(mux)
+	s.addMboxRoutes(mux)
 	mux.HandleFunc("/", s.serveStaticFiles())
 	return corsMiddleware(mux)
 }
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeDB answers the generated queries with canned rows, keyed by the query
+// name. A row is a struct whose fields are scanned in order, as sqlc does.
+type fakeDB struct {
+	rows map[string][]any
+}
+
+func queryName(sql string) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(sql, "-- name: "), " ")
+	return name
+}
+
+func (f *fakeDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+func (f *fakeDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return &fakeRows{rows: f.rows[queryName(sql)], next: -1}, nil
+}
+
+func (f *fakeDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	rows := f.rows[queryName(sql)]
+	if len(rows) == 0 {
+		return fakeRow{err: pgx.ErrNoRows}
+	}
+	return fakeRow{value: rows[0]}
+}
+
+type fakeRow struct {
+	value any
+	err   error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	v := reflect.ValueOf(r.value)
+	if v.NumField() != len(dest) {
+		return fmt.Errorf("scanning %d columns into %T with %d fields", len(dest), r.value, v.NumField())
+	}
+	for i, d := range dest {
+		reflect.ValueOf(d).Elem().Set(v.Field(i))
+	}
+	return nil
+}
+
+type fakeRows struct {
+	rows []any
+	next int
+}
+
+func (r *fakeRows) Close()                                       {}
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+func (r *fakeRows) Values() ([]any, error)                       { return nil, nil }
+
+func (r *fakeRows) Next() bool {
+	r.next++
+	return r.next < len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	return fakeRow{value: r.rows[r.next]}.Scan(dest...)
+}
+
+// newTestServer returns the routes of a server reading rows from a fakeDB.
+func newTestServer(rows map[string][]any) http.Handler {
+	s := NewServer(ServerConfig{Querier: db.New(&fakeDB{rows: rows})})
+	return s.setupRoutes()
+}
+
+// get requests path and decodes the JSON response into v, unless the status
+// is not 200, which it returns.
+func get(t *testing.T, handler http.Handler, path string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		return rec.Code
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v\n%s", path, err, rec.Body)
+	}
+	return rec.Code
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+)
+
+type ThreadDetail struct {
+	ID           string        `json:"id"`
+	Subject      string        `json:"subject"`
+	MessageCount int32         `json:"message_count"`
+	Messages     []*ThreadNode `json:"messages"`
+}
+
+// ThreadNode is a message in a thread. Placeholders stand for messages that
+// were replied to but are not in the archive; they have no ID.
+type ThreadNode struct {
+	ID          string        `json:"id,omitempty"`
+	MessageID   string        `json:"message_id"`
+	Subject     string        `json:"subject,omitempty"`
+	From        string        `json:"from,omitempty"`
+	Date        string        `json:"date,omitempty"`
+	Placeholder bool          `json:"placeholder,omitempty"`
+	Replies     []*ThreadNode `json:"replies"`
+}
+
+func (s *Server) addThreadRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/thread/{id}", s.jsonThreadHandler)
+}
+
+func (s *Server) jsonThreadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+
+	thread, err := s.config.Querier.GetThread(r.Context(), id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Thread not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	members, err := s.config.Querier.ListThreadMembers(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := ThreadDetail{
+		ID:           strconv.FormatInt(thread.ID, 10),
+		Subject:      thread.Subject,
+		MessageCount: thread.MessageCount,
+		Messages:     buildThreadTree(members),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		fmt.Println("error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// buildThreadTree nests members, which are ordered depth first, under their
+// parents.
+func buildThreadTree(members []db.ListThreadMembersRow) []*ThreadNode {
+	nodes := make(map[string]*ThreadNode, len(members))
+	roots := []*ThreadNode{}
+	for _, m := range members {
+		node := &ThreadNode{
+			MessageID: m.MessageID,
+			Replies:   []*ThreadNode{},
+		}
+		if m.DocID.Valid {
+			node.ID = strconv.FormatInt(m.DocID.Int64, 10)
+			node.Subject = m.Subject.String
+			node.From = formatFrom(m.FromName.String, m.FromEmail.String)
+			if m.SentAt.Valid {
+				node.Date = m.SentAt.Time.UTC().Format(time.RFC3339)
+			}
+		} else {
+			node.Placeholder = true
+		}
+		nodes[m.MessageID] = node
+
+		if parent, ok := nodes[m.ParentMessageID]; ok && m.ParentMessageID != "" {
+			parent.Replies = append(parent.Replies, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+func formatFrom(name, email string) string {
+	switch {
+	case name == "":
+		return email
+	case email == "":
+		return name
+	}
+	return fmt.Sprintf("%s <%s>", name, email)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func threadMember(messageID, parent string, docID int64, subject string) db.ListThreadMembersRow {
+	m := db.ListThreadMembersRow{MessageID: messageID, ParentMessageID: parent}
+	if docID != 0 {
+		m.DocID = pgtype.Int8{Int64: docID, Valid: true}
+		m.Subject = pgtype.Text{String: subject, Valid: true}
+		m.FromName = pgtype.Text{String: "Jane Doe", Valid: true}
+		m.FromEmail = pgtype.Text{String: "jane@example.com", Valid: true}
+		m.SentAt = pgtype.Timestamptz{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Valid: true}
+	}
+	return m
+}
+
+// threadMembers is a cover letter whose first reply is missing from the
+// archive, and a reply to the first patch listed after its parent.
+var threadMembers = []any{
+	threadMember("cover@x", "", 1, "[PATCH 0/2] cover"),
+	threadMember("missing@x", "cover@x", 0, ""),
+	threadMember("patch1@x", "missing@x", 2, "[PATCH 1/2] one"),
+	threadMember("review@x", "patch1@x", 3, "Re: [PATCH 1/2] one"),
+	threadMember("patch2@x", "cover@x", 4, "[PATCH 2/2] two"),
+	threadMember("stray@x", "gone@x", 5, "Re: something else"),
+}
+
+func TestBuildThreadTree(t *testing.T) {
+	var members []db.ListThreadMembersRow
+	for _, m := range threadMembers {
+		members = append(members, m.(db.ListThreadMembersRow))
+	}
+	roots := buildThreadTree(members)
+
+	// Each node as "message ID: replies".
+	shape := make(map[string][]string)
+	var walk func(nodes []*ThreadNode)
+	walk = func(nodes []*ThreadNode) {
+		for _, n := range nodes {
+			shape[n.MessageID] = []string{}
+			for _, r := range n.Replies {
+				shape[n.MessageID] = append(shape[n.MessageID], r.MessageID)
+			}
+			walk(n.Replies)
+		}
+	}
+	walk(roots)
+	want := map[string][]string{
+		"cover@x":   {"missing@x", "patch2@x"},
+		"missing@x": {"patch1@x"},
+		"patch1@x":  {"review@x"},
+		"review@x":  {},
+		"patch2@x":  {},
+		"stray@x":   {},
+	}
+	if !reflect.DeepEqual(shape, want) {
+		t.Errorf("tree = %v, want %v", shape, want)
+	}
+	if len(roots) != 2 || roots[0].MessageID != "cover@x" || roots[1].MessageID != "stray@x" {
+		t.Fatalf("roots = %+v, want cover@x and stray@x, whose parent is unknown", roots)
+	}
+
+	missing := roots[0].Replies[0]
+	if !missing.Placeholder || missing.ID != "" || missing.Subject != "" {
+		t.Errorf("missing message = %+v, want a placeholder", missing)
+	}
+	cover := roots[0]
+	if cover.Placeholder || cover.ID != "1" || cover.From != "Jane Doe <jane@example.com>" || cover.Date != "2024-01-02T03:04:05Z" {
+		t.Errorf("cover = %+v", cover)
+	}
+}
+
+func TestThreadHandler(t *testing.T) {
+	handler := newTestServer(map[string][]any{
+		"GetThread":         {db.Thread{ID: 7, RootMessageID: "cover@x", Subject: "[PATCH 0/2] cover", MessageCount: 5}},
+		"ListThreadMembers": threadMembers,
+	})
+
+	var thread ThreadDetail
+	if code := get(t, handler, "/api/thread/7", &thread); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if thread.ID != "7" || thread.Subject != "[PATCH 0/2] cover" || thread.MessageCount != 5 {
+		t.Errorf("thread = %+v", thread)
+	}
+	if len(thread.Messages) != 2 || len(thread.Messages[0].Replies) != 2 {
+		t.Errorf("messages = %+v, want the cover letter with two replies and a stray message", thread.Messages)
+	}
+
+	if code := get(t, handler, "/api/thread/x", nil); code != http.StatusBadRequest {
+		t.Errorf("invalid ID: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := get(t, newTestServer(nil), "/api/thread/7", nil); code != http.StatusNotFound {
+		t.Errorf("unknown thread: status %d, want %d", code, http.StatusNotFound)
+	}
+}
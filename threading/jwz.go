@@ -0,0 +1,304 @@
+// Package threading groups messages into conversations with Jamie Zawinski's
+// threading algorithm (https://www.jwz.org/doc/threading.html).
+package threading
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message is the part of an email needed to thread it.
+type Message struct {
+	DocID      int64
+	MessageID  string
+	InReplyTo  string
+	References []string
+	Subject    string
+	Date       time.Time
+}
+
+// Container is a node of a thread tree. Message is nil for placeholders:
+// messages that were referenced but never seen, and the dummy roots created
+// when threads are grouped by subject. Dummy roots have no MessageID.
+type Container struct {
+	MessageID string
+	Message   *Message
+	Parent    *Container
+	Children  []*Container
+}
+
+// IsPlaceholder reports whether the container has no message of its own.
+func (c *Container) IsPlaceholder() bool {
+	return c.Message == nil
+}
+
+// Date is the date of the message, or of the earliest descendant for
+// placeholders.
+func (c *Container) Date() time.Time {
+	if c.Message != nil {
+		return c.Message.Date
+	}
+	var earliest time.Time
+	for _, child := range c.Children {
+		if d := child.Date(); !d.IsZero() && (earliest.IsZero() || d.Before(earliest)) {
+			earliest = d
+		}
+	}
+	return earliest
+}
+
+// subject is the subject of the message, or of the first child that has one.
+func (c *Container) subject() string {
+	if c.Message != nil {
+		return c.Message.Subject
+	}
+	for _, child := range c.Children {
+		if s := child.subject(); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// hasDescendant reports whether d is c or below it.
+func (c *Container) hasDescendant(d *Container) bool {
+	for ; d != nil; d = d.Parent {
+		if d == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Container) addChild(child *Container) {
+	if child.Parent != nil {
+		child.Parent.removeChild(child)
+	}
+	child.Parent = c
+	c.Children = append(c.Children, child)
+}
+
+func (c *Container) removeChild(child *Container) {
+	for i, existing := range c.Children {
+		if existing == child {
+			c.Children = append(c.Children[:i], c.Children[i+1:]...)
+			break
+		}
+	}
+	child.Parent = nil
+}
+
+// Thread builds thread trees from msgs and returns their roots, oldest
+// first. Messages without a Message-ID are ignored and only the first
+// message with a given ID is used.
+func Thread(msgs []*Message) []*Container {
+	containers := make(map[string]*Container, len(msgs))
+	get := func(id string) *Container {
+		c, ok := containers[id]
+		if !ok {
+			c = &Container{MessageID: id}
+			containers[id] = c
+		}
+		return c
+	}
+
+	for _, msg := range msgs {
+		if msg.MessageID == "" {
+			continue
+		}
+		c := get(msg.MessageID)
+		if c.Message != nil {
+			continue
+		}
+		c.Message = msg
+
+		// Link the references chain parent to child, without overriding
+		// links we already know and without creating loops.
+		refs := references(msg)
+		var prev *Container
+		for _, id := range refs {
+			ref := get(id)
+			if prev != nil && ref.Parent == nil && !ref.hasDescendant(prev) {
+				prev.addChild(ref)
+			}
+			prev = ref
+		}
+
+		// The last reference is the parent of this message, whatever an
+		// earlier message claimed.
+		if prev != nil && c.hasDescendant(prev) {
+			prev = nil
+		}
+		if c.Parent != nil {
+			c.Parent.removeChild(c)
+		}
+		if prev != nil {
+			prev.addChild(c)
+		}
+	}
+
+	var roots []*Container
+	for _, c := range containers {
+		if c.Parent == nil {
+			roots = append(roots, c)
+		}
+	}
+
+	root := &Container{}
+	for _, c := range roots {
+		root.addChild(c)
+	}
+	prune(root)
+	sortByDate(root.Children)
+	groupBySubject(root)
+
+	roots = root.Children
+	for _, c := range roots {
+		c.Parent = nil
+	}
+	sortByDate(roots)
+	return roots
+}
+
+// references returns the References of msg with In-Reply-To appended when
+// it is not already the last entry.
+func references(msg *Message) []string {
+	refs := make([]string, 0, len(msg.References)+1)
+	for _, id := range msg.References {
+		if id != "" && id != msg.MessageID {
+			refs = append(refs, id)
+		}
+	}
+	if msg.InReplyTo != "" && msg.InReplyTo != msg.MessageID &&
+		(len(refs) == 0 || refs[len(refs)-1] != msg.InReplyTo) {
+		refs = append(refs, msg.InReplyTo)
+	}
+	return refs
+}
+
+// prune removes placeholders without children and replaces placeholders by
+// their children, except at the top level where a placeholder with several
+// children is kept to hold the thread together.
+func prune(c *Container) {
+	var children []*Container
+	for _, child := range c.Children {
+		prune(child)
+		switch {
+		case !child.IsPlaceholder():
+			children = append(children, child)
+		case len(child.Children) == 0:
+		case c.Parent != nil || c.MessageID != "" || len(child.Children) == 1:
+			for _, grandchild := range child.Children {
+				grandchild.Parent = c
+				children = append(children, grandchild)
+			}
+		default:
+			children = append(children, child)
+		}
+	}
+	c.Children = children
+}
+
+// groupBySubject merges top-level threads whose root is missing into threads
+// with the same subject. Only replies and placeholders are merged: kernel
+// lists are full of unrelated patches titled "fix typo".
+func groupBySubject(root *Container) {
+	bySubject := make(map[string]*Container)
+	for _, c := range root.Children {
+		subject := c.subject()
+		norm := NormalizeSubject(subject)
+		if norm == "" {
+			continue
+		}
+		old, ok := bySubject[norm]
+		if !ok ||
+			(c.IsPlaceholder() && !old.IsPlaceholder()) ||
+			(isReply(old.subject()) && !isReply(subject) && !c.IsPlaceholder()) {
+			bySubject[norm] = c
+		}
+	}
+
+	for _, c := range append([]*Container(nil), root.Children...) {
+		if c.Parent != root {
+			// Already moved below another thread.
+			continue
+		}
+		subject := c.subject()
+		if !c.IsPlaceholder() && !isReply(subject) {
+			continue
+		}
+		other, ok := bySubject[NormalizeSubject(subject)]
+		if !ok || other == c {
+			continue
+		}
+
+		switch {
+		case c.IsPlaceholder() && other.IsPlaceholder():
+			for _, child := range append([]*Container(nil), c.Children...) {
+				other.addChild(child)
+			}
+			root.removeChild(c)
+		case other.IsPlaceholder():
+			other.addChild(c)
+		case c.IsPlaceholder():
+			root.removeChild(other)
+			c.addChild(other)
+			bySubject[NormalizeSubject(subject)] = c
+		case !isReply(other.subject()):
+			other.addChild(c)
+		default:
+			dummy := &Container{}
+			root.removeChild(other)
+			root.addChild(dummy)
+			dummy.addChild(other)
+			dummy.addChild(c)
+			bySubject[NormalizeSubject(subject)] = dummy
+		}
+	}
+}
+
+func sortByDate(cs []*Container) {
+	sort.SliceStable(cs, func(i, j int) bool {
+		di, dj := cs[i].Date(), cs[j].Date()
+		if !di.Equal(dj) {
+			return di.Before(dj)
+		}
+		return cs[i].MessageID < cs[j].MessageID
+	})
+	for _, c := range cs {
+		sortByDate(c.Children)
+	}
+}
+
+var replyPrefix = regexp.MustCompile(`(?i)^\s*(re|fwd?|aw|sv|antw)(\[\d+\])?\s*:\s*`)
+
+func isReply(subject string) bool {
+	return replyPrefix.MatchString(subject)
+}
+
+// NormalizeSubject strips reply and forward prefixes and folds case and
+// whitespace, so that "Re: RE: Foo  bar" and "foo bar" compare equal.
+func NormalizeSubject(subject string) string {
+	for {
+		stripped := replyPrefix.ReplaceAllString(subject, "")
+		if stripped == subject {
+			break
+		}
+		subject = stripped
+	}
+	return strings.ToLower(strings.Join(strings.Fields(subject), " "))
+}
+
+// Walk calls fn for every container below c in depth-first order, c first.
+func Walk(c *Container, fn func(c *Container, depth int)) {
+	walk(c, 0, fn)
+}
+
+func walk(c *Container, depth int, fn func(*Container, int)) {
+	fn(c, depth)
+	for _, child := range c.Children {
+		walk(child, depth+1, fn)
+	}
+}
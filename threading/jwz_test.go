@@ -0,0 +1,218 @@
+package threading
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func msg(id, inReplyTo string, refs []string, subject string, minute int) *Message {
+	return &Message{
+		MessageID:  id,
+		InReplyTo:  inReplyTo,
+		References: refs,
+		Subject:    subject,
+		Date:       time.Date(2024, 1, 1, 0, minute, 0, 0, time.UTC),
+	}
+}
+
+// render prints thread trees as "a(b c(d))", with "_" for a placeholder
+// without a message ID and "?id" for a missing message.
+func render(roots []*Container) string {
+	var parts []string
+	for _, c := range roots {
+		parts = append(parts, renderContainer(c))
+	}
+	return strings.Join(parts, " ")
+}
+
+func renderContainer(c *Container) string {
+	name := c.MessageID
+	switch {
+	case name == "":
+		name = "_"
+	case c.IsPlaceholder():
+		name = "?" + name
+	}
+	if len(c.Children) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s(%s)", name, render(c.Children))
+}
+
+func TestThread(t *testing.T) {
+	tests := []struct {
+		name string
+		msgs []*Message
+		want string
+	}{
+		{
+			name: "reply chain from references",
+			msgs: []*Message{
+				msg("c", "b", []string{"a", "b"}, "Re: topic", 3),
+				msg("a", "", nil, "topic", 1),
+				msg("b", "a", []string{"a"}, "Re: topic", 2),
+			},
+			want: "a(b(c))",
+		},
+		{
+			name: "in-reply-to without references",
+			msgs: []*Message{
+				msg("a", "", nil, "topic", 1),
+				msg("b", "a", nil, "Re: topic", 2),
+				msg("c", "a", nil, "Re: topic", 3),
+			},
+			want: "a(b c)",
+		},
+		{
+			name: "missing intermediate message is pruned",
+			msgs: []*Message{
+				msg("a", "", nil, "topic", 1),
+				msg("c", "b", []string{"a", "b"}, "Re: topic", 3),
+			},
+			want: "a(c)",
+		},
+		{
+			name: "missing root with several replies keeps a placeholder",
+			msgs: []*Message{
+				msg("b", "a", []string{"a"}, "Re: topic", 2),
+				msg("c", "a", []string{"a"}, "Re: topic", 3),
+			},
+			want: "?a(b c)",
+		},
+		{
+			name: "missing root with one reply is promoted",
+			msgs: []*Message{
+				msg("b", "a", []string{"a"}, "Re: topic", 2),
+			},
+			want: "b",
+		},
+		{
+			name: "orphaned reply grouped by subject",
+			msgs: []*Message{
+				msg("a", "", nil, "[PATCH] mm: fix leak", 1),
+				msg("b", "", nil, "Re: [PATCH] mm: fix leak", 2),
+			},
+			want: "a(b)",
+		},
+		{
+			name: "unrelated messages with the same subject stay apart",
+			msgs: []*Message{
+				msg("a", "", nil, "fix typo", 1),
+				msg("b", "", nil, "fix typo", 2),
+			},
+			want: "a b",
+		},
+		{
+			name: "orphaned replies without a root share a dummy",
+			msgs: []*Message{
+				msg("a", "", nil, "Re: question", 1),
+				msg("b", "", nil, "RE: Question", 2),
+			},
+			want: "_(a b)",
+		},
+		{
+			name: "reference loop is broken",
+			msgs: []*Message{
+				msg("a", "b", []string{"b"}, "Re: loop", 1),
+				msg("b", "a", []string{"a"}, "Re: loop", 2),
+			},
+			want: "b(a)",
+		},
+		{
+			name: "self reference is ignored",
+			msgs: []*Message{
+				msg("a", "a", []string{"a"}, "self", 1),
+			},
+			want: "a",
+		},
+		{
+			name: "duplicate message ID uses the first message",
+			msgs: []*Message{
+				msg("a", "", nil, "topic", 1),
+				msg("a", "x", nil, "other", 2),
+			},
+			want: "a",
+		},
+		{
+			name: "roots and replies sorted by date",
+			msgs: []*Message{
+				msg("late", "", nil, "second", 9),
+				msg("a", "", nil, "first", 1),
+				msg("r2", "a", nil, "Re: first", 5),
+				msg("r1", "a", nil, "Re: first", 3),
+			},
+			want: "a(r1 r2) late",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := render(Thread(tt.msgs)); got != tt.want {
+				t.Errorf("Thread() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"Re: RE: Foo  bar", "foo bar"},
+		{"Fwd: Re[2]: foo", "foo"},
+		{"AW: [PATCH v2] mm: fix", "[patch v2] mm: fix"},
+		{"Regression in foo", "regression in foo"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeSubject(tt.subject); got != tt.want {
+			t.Errorf("NormalizeSubject(%q) = %q, want %q", tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestThreadRows(t *testing.T) {
+	roots := Thread([]*Message{
+		{DocID: 1, MessageID: "b", InReplyTo: "a", Subject: "Re: topic", Date: time.Unix(200, 0)},
+		{DocID: 2, MessageID: "c", InReplyTo: "a", Subject: "Re: topic", Date: time.Unix(300, 0)},
+		{DocID: 3, MessageID: "d", InReplyTo: "c", Subject: "Re: topic", Date: time.Unix(400, 0)},
+		{DocID: 4, MessageID: "x", Subject: "Re: other", Date: time.Unix(100, 0)},
+		{DocID: 5, MessageID: "y", Subject: "Re: other", Date: time.Unix(500, 0)},
+	})
+
+	threads, members := threadRows(roots)
+	if len(threads) != 2 {
+		t.Fatalf("got %d threads, want 2", len(threads))
+	}
+
+	// The dummy root of the subject group is not stored.
+	if threads[0].RootMessageID != "x" || threads[0].MessageCount != 2 {
+		t.Errorf("thread 0 = %+v", threads[0])
+	}
+	if threads[1].RootMessageID != "a" || threads[1].MessageCount != 3 || threads[1].Subject != "Re: topic" {
+		t.Errorf("thread 1 = %+v", threads[1])
+	}
+	if !threads[1].LastActivity.Time.Equal(time.Unix(400, 0)) {
+		t.Errorf("thread 1 last activity = %v", threads[1].LastActivity.Time)
+	}
+
+	var got []string
+	for _, m := range members {
+		got = append(got, fmt.Sprintf("%s:%s>%s@%d#%d/%v",
+			m.RootMessageID, m.ParentMessageID, m.MessageID, m.Depth, m.Position, m.DocID.Valid))
+	}
+	want := []string{
+		"x:>x@0#0/true",
+		"x:>y@0#1/true",
+		"a:>a@0#0/false",
+		"a:a>b@1#1/true",
+		"a:a>c@1#2/true",
+		"a:c>d@2#3/true",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("members =\n%v\nwant\n%v", got, want)
+	}
+}
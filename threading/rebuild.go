@@ -0,0 +1,106 @@
+package threading
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alexmorten/patchy/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const loadPageSize = 10000
+
+// Rebuild threads every message in docs and replaces the stored threads.
+// Trailers given in replies are then attributed to the patch they review,
+// since that follows the new threads. It returns the number of threads.
+func Rebuild(ctx context.Context, conn *pgx.Conn) (int, error) {
+	msgs, err := loadMessages(ctx, db.New(conn))
+	if err != nil {
+		return 0, err
+	}
+	threads, members := threadRows(Thread(msgs))
+	if err := db.ReplaceThreads(ctx, conn, threads, members); err != nil {
+		return 0, err
+	}
+	if _, err := db.AttributeTrailers(ctx, conn); err != nil {
+		return 0, fmt.Errorf("attributing trailers: %w", err)
+	}
+	return len(threads), nil
+}
+
+func loadMessages(ctx context.Context, queries *db.Queries) ([]*Message, error) {
+	var msgs []*Message
+	var after int64
+	for {
+		rows, err := queries.ListThreadingMessages(ctx, db.ListThreadingMessagesParams{
+			ID:    after,
+			Limit: loadPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			msgs = append(msgs, &Message{
+				DocID:      row.ID,
+				MessageID:  row.MessageID,
+				InReplyTo:  row.InReplyTo,
+				References: row.Refs,
+				Subject:    row.Subject,
+				Date:       row.SentAt.Time,
+			})
+		}
+		if len(rows) < loadPageSize {
+			return msgs, nil
+		}
+		after = rows[len(rows)-1].ID
+	}
+}
+
+// threadRows flattens thread trees into threads and thread_members rows.
+// Members are listed depth first, so ordering by position gives the tree in
+// reading order. Dummy roots from subject grouping are not stored; their
+// children become top-level members of the thread instead.
+func threadRows(roots []*Container) ([]db.ThreadParams, []db.ThreadMemberParams) {
+	threads := make([]db.ThreadParams, 0, len(roots))
+	var members []db.ThreadMemberParams
+
+	for _, root := range roots {
+		rootID := root.MessageID
+		if rootID == "" && len(root.Children) > 0 {
+			rootID = root.Children[0].MessageID
+		}
+		thread := db.ThreadParams{RootMessageID: rootID, Subject: root.subject()}
+
+		position := 0
+		dummy := root.MessageID == ""
+		Walk(root, func(c *Container, depth int) {
+			if c.MessageID == "" {
+				return
+			}
+			if dummy {
+				depth--
+			}
+			member := db.ThreadMemberParams{
+				RootMessageID: rootID,
+				MessageID:     c.MessageID,
+				Depth:         int32(depth),
+				Position:      int32(position),
+			}
+			position++
+			if c.Parent != nil {
+				member.ParentMessageID = c.Parent.MessageID
+			}
+			if c.Message != nil {
+				member.DocID = pgtype.Int8{Int64: c.Message.DocID, Valid: true}
+				thread.MessageCount++
+				if d := c.Message.Date; !d.IsZero() && (!thread.LastActivity.Valid || d.After(thread.LastActivity.Time)) {
+					thread.LastActivity = pgtype.Timestamptz{Time: d, Valid: true}
+				}
+			}
+			members = append(members, member)
+		})
+		threads = append(threads, thread)
+	}
+	return threads, members
+}